@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"huskki/frames"
+	"huskki/hub"
+	"huskki/units"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_ALERTS warn of the engine overheating. Low voltage and injector duty have their own
+	// watchers, as their limits depend on rpm.
+	DEFAULT_ALERTS = "coolant>105"
+	// How long a value has to stay past its limit before it's flagged, to ride out a glitchy reading
+	ALERT_HOLD = 2 * time.Second
+	// How often held thresholds are checked, as change-only logging won't resend a value that
+	// stays put
+	ALERT_CHECK_INTERVAL = 250 * time.Millisecond
+	// How far back past its limit, as a fraction of it, a value has to go before an alert clears
+	ALERT_HYSTERESIS = 0.02
+)
+
+// alertRule fires while a channel is over or under a limit, given in the channel's metric units
+type alertRule struct {
+	Channel string
+	Over    bool
+	Limit   float64
+}
+
+// Alerts are the -alert thresholds being watched
+var Alerts []alertRule
+
+// ALERT_SOUND has dashboards beep when an alert fires, set by -alert-sound
+var ALERT_SOUND = false
+
+// parseAlerts parses comma separated thresholds, e.g. coolant>105,rpm>9000
+func parseAlerts(value string) ([]alertRule, error) {
+	var rules []alertRule
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		i := strings.IndexAny(s, "<>")
+		if i <= 0 {
+			return nil, fmt.Errorf("expected a channel, < or > and a limit, e.g. coolant>105, not %q", s)
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: expected a number after %c", s, s[i])
+		}
+		rules = append(rules, alertRule{Channel: strings.ToLower(strings.TrimSpace(s[:i])), Over: s[i] == '>', Limit: limit})
+	}
+	return rules, nil
+}
+
+// AlertChannel carries whether the rule is firing, e.g. alert_coolant_high
+func (a alertRule) AlertChannel() string {
+	if a.Over {
+		return "alert_" + a.Channel + "_high"
+	}
+	return "alert_" + a.Channel + "_low"
+}
+
+func (a alertRule) String() string {
+	op := "<"
+	if a.Over {
+		op = ">"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %g %s", a.Channel, op, a.Limit, frames.Unit(a.Channel)))
+}
+
+func (a alertRule) breached(value float64) bool {
+	if a.Over {
+		return value > a.Limit
+	}
+	return value < a.Limit
+}
+
+func (a alertRule) recovered(value float64) bool {
+	margin := max(a.Limit, -a.Limit) * ALERT_HYSTERESIS
+	if a.Over {
+		return value <= a.Limit-margin
+	}
+	return value >= a.Limit+margin
+}
+
+// alertFor finds the rule an alert channel belongs to
+func alertFor(channel string) (alertRule, bool) {
+	for _, rule := range Alerts {
+		if rule.AlertChannel() == channel {
+			return rule, true
+		}
+	}
+	return alertRule{}, false
+}
+
+// soundsAlarm is whether an event should have dashboards beep, an alert firing with -alert-sound
+func soundsAlarm(event hub.SensorEvent) bool {
+	firing, _ := event.Value.(bool)
+	_, ok := alertFor(event.Channel)
+	return ALERT_SOUND && firing && ok
+}
+
+type alertProps struct {
+	ID      string
+	Firing  bool
+	Message string
+}
+
+// props describes the rule for the dashboard's banner, with its limit in the client's units
+func (a alertRule) props(system units.System, firing bool) alertProps {
+	direction := "under"
+	if a.Over {
+		direction = "over"
+	}
+	limit := units.Convert(system, a.Channel, a.Limit)
+	unit := units.Unit(system, a.Channel, frames.Unit(a.Channel))
+	return alertProps{
+		ID:      strings.ReplaceAll(a.AlertChannel(), "_", "-"),
+		Firing:  firing,
+		Message: strings.TrimSpace(fmt.Sprintf("%s %s %v %s", a.Channel, direction, limit, unit)),
+	}
+}
+
+// alertBanners are the dashboard's banners for each rule, before any has fired
+func alertBanners(system units.System) []alertProps {
+	var banners []alertProps
+	for _, rule := range Alerts {
+		banners = append(banners, rule.props(system, false))
+	}
+	return banners
+}
+
+// threshold is the hold and hysteresis shared by the watchers: it fires once its condition has
+// been breached for hold, and clears once the value has recovered
+type threshold struct {
+	hold   time.Duration
+	since  time.Time
+	firing bool
+}
+
+// observe records a reading, returning whether the threshold started or stopped firing
+func (t *threshold) observe(breached, recovered bool, now time.Time) bool {
+	switch {
+	case breached && t.since.IsZero():
+		t.since = now
+	case !breached:
+		t.since = time.Time{}
+	}
+	if t.firing && recovered {
+		t.firing = false
+		return true
+	}
+	return t.elapse(now)
+}
+
+// elapse fires the threshold once it has been breached for hold, whether or not a reading
+// has arrived since
+func (t *threshold) elapse(now time.Time) bool {
+	if !t.firing && !t.since.IsZero() && now.Sub(t.since) >= t.hold {
+		t.firing = true
+		return true
+	}
+	return false
+}
+
+// watchAlerts broadcasts each rule's AlertChannel when its channel crosses the limit, marking
+// the ride where it fired so it can be found in the log afterwards. The returned function
+// stops it.
+func watchAlerts(h *hub.EventHub, rules []alertRule) func() {
+	if len(rules) == 0 {
+		return func() {}
+	}
+	var channels []string
+	for _, rule := range rules {
+		channels = append(channels, rule.Channel)
+	}
+	id, ch, cancel := h.Subscribe(channels...)
+	h.SetName(id, "alerts")
+	go func() {
+		ticker := time.NewTicker(ALERT_CHECK_INTERVAL)
+		defer ticker.Stop()
+		thresholds := make([]threshold, len(rules))
+		last := make([]any, len(rules))
+		for i := range thresholds {
+			thresholds[i].hold = ALERT_HOLD
+		}
+		changed := func(i int) {
+			rule := rules[i]
+			if thresholds[i].firing {
+				log.Printf("alert: %s at %v", rule, last[i])
+				addMarker(fmt.Sprintf("alert: %s", rule))
+			} else {
+				log.Printf("alert cleared: %s at %v", rule.Channel, last[i])
+			}
+			h.Broadcast(hub.Status(rule.AlertChannel(), thresholds[i].firing))
+		}
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				value, ok := event.Float()
+				if !ok {
+					continue
+				}
+				for i, rule := range rules {
+					if rule.Channel != event.Channel {
+						continue
+					}
+					last[i] = event.Value
+					if thresholds[i].observe(rule.breached(value), rule.recovered(value), time.Now()) {
+						changed(i)
+					}
+				}
+			case now := <-ticker.C:
+				for i := range rules {
+					if thresholds[i].elapse(now) {
+						changed(i)
+					}
+				}
+			}
+		}
+	}()
+	return cancel
+}
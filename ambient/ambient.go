@@ -0,0 +1,84 @@
+// Package ambient captures the ambient conditions at the start of a session, since air
+// temperature and pressure matter when comparing fueling and pulls across days.
+package ambient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const READ_TIMEOUT = 5 * time.Second
+
+// Conditions are the ambient readings from a source. Readings a source doesn't provide are nil.
+type Conditions struct {
+	Source       string    `json:"source"`
+	Time         time.Time `json:"time"`
+	TemperatureC *float64  `json:"temperatureC,omitempty"`
+	PressureHPa  *float64  `json:"pressureHPa,omitempty"`
+	HumidityPct  *float64  `json:"humidityPct,omitempty"`
+}
+
+type Source interface {
+	Read(ctx context.Context) (Conditions, error)
+}
+
+// Weather fetches current conditions from a weather API. The response may be Open-Meteo's
+// (request current=temperature_2m,surface_pressure,relative_humidity_2m) or a flat object
+// with temperature, pressure and humidity fields.
+type Weather struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Weather) Read(ctx context.Context) (Conditions, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.URL, nil)
+	if err != nil {
+		return Conditions{}, err
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Conditions{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return Conditions{}, fmt.Errorf("weather api: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Current *struct {
+			Temperature *float64 `json:"temperature_2m"`
+			Pressure    *float64 `json:"surface_pressure"`
+			Humidity    *float64 `json:"relative_humidity_2m"`
+		} `json:"current"`
+		Temperature *float64 `json:"temperature"`
+		Pressure    *float64 `json:"pressure"`
+		Humidity    *float64 `json:"humidity"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Conditions{}, fmt.Errorf("weather api: %w", err)
+	}
+	c := Conditions{Source: "weather", Time: time.Now()}
+	if body.Current != nil {
+		c.TemperatureC, c.PressureHPa, c.HumidityPct = body.Current.Temperature, body.Current.Pressure, body.Current.Humidity
+	} else {
+		c.TemperatureC, c.PressureHPa, c.HumidityPct = body.Temperature, body.Pressure, body.Humidity
+	}
+	if c.TemperatureC == nil && c.PressureHPa == nil && c.HumidityPct == nil {
+		return Conditions{}, fmt.Errorf("weather api: no temperature, pressure or humidity in response")
+	}
+	return c, nil
+}
+
+// Capture reads the source, giving up after READ_TIMEOUT
+func Capture(src Source) (Conditions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), READ_TIMEOUT)
+	defer cancel()
+	return src.Read(ctx)
+}
@@ -0,0 +1,141 @@
+//go:build linux
+
+package ambient
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DEFAULT_BME280_ADDR = 0x76
+
+	i2cSlave = 0x0703 // I2C_SLAVE ioctl
+
+	bme280ChipID     = 0x60
+	bme280RegID      = 0xD0
+	bme280RegCalib1  = 0x88
+	bme280RegCalib2  = 0xE1
+	bme280RegCtrlHum = 0xF2
+	bme280RegCtrl    = 0xF4
+	bme280RegData    = 0xF7
+)
+
+// BME280 reads a Bosch BME280 temperature/pressure/humidity sensor on a local I2C bus,
+// e.g. /dev/i2c-1 on a Raspberry Pi
+type BME280 struct {
+	Bus  string
+	Addr int
+}
+
+func (b *BME280) Read(ctx context.Context) (Conditions, error) {
+	dev, err := os.OpenFile(b.Bus, os.O_RDWR, 0)
+	if err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+	defer dev.Close()
+	if err := unix.IoctlSetInt(int(dev.Fd()), i2cSlave, b.Addr); err != nil {
+		return Conditions{}, fmt.Errorf("bme280: select 0x%02X: %w", b.Addr, err)
+	}
+
+	read := func(reg byte, n int) ([]byte, error) {
+		if _, err := dev.Write([]byte{reg}); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		_, err := dev.Read(buf)
+		return buf, err
+	}
+	write := func(reg, value byte) error {
+		_, err := dev.Write([]byte{reg, value})
+		return err
+	}
+
+	id, err := read(bme280RegID, 1)
+	if err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+	if id[0] != bme280ChipID {
+		return Conditions{}, fmt.Errorf("bme280: unexpected chip id 0x%02X", id[0])
+	}
+	calib1, err := read(bme280RegCalib1, 26)
+	if err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+	calib2, err := read(bme280RegCalib2, 7)
+	if err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+
+	// One forced measurement, x1 oversampling of humidity, temperature and pressure
+	if err := write(bme280RegCtrlHum, 0x01); err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+	if err := write(bme280RegCtrl, 0x25); err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+	select {
+	case <-ctx.Done():
+		return Conditions{}, ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+	}
+	data, err := read(bme280RegData, 8)
+	if err != nil {
+		return Conditions{}, fmt.Errorf("bme280: %w", err)
+	}
+
+	t, p, h := bme280Compensate(calib1, calib2, data)
+	return Conditions{Source: "bme280", Time: time.Now(), TemperatureC: &t, PressureHPa: &p, HumidityPct: &h}, nil
+}
+
+// bme280Compensate applies the factory calibration to raw readings, using the floating point
+// formulas from the datasheet
+func bme280Compensate(calib1, calib2, data []byte) (temperature, pressure, humidity float64) {
+	u16 := func(b []byte, i int) float64 { return float64(binary.LittleEndian.Uint16(b[i:])) }
+	s16 := func(b []byte, i int) float64 { return float64(int16(binary.LittleEndian.Uint16(b[i:]))) }
+
+	t1, t2, t3 := u16(calib1, 0), s16(calib1, 2), s16(calib1, 4)
+	p1, p2, p3 := u16(calib1, 6), s16(calib1, 8), s16(calib1, 10)
+	p4, p5, p6 := s16(calib1, 12), s16(calib1, 14), s16(calib1, 16)
+	p7, p8, p9 := s16(calib1, 18), s16(calib1, 20), s16(calib1, 22)
+	h1 := float64(calib1[25])
+	h2, h3 := s16(calib2, 0), float64(calib2[2])
+	h4 := float64(int16(uint16(int8(calib2[3]))<<4 | uint16(calib2[4]&0x0F)))
+	h5 := float64(int16(uint16(int8(calib2[5]))<<4 | uint16(calib2[4]>>4)))
+	h6 := float64(int8(calib2[6]))
+
+	rawP := float64(int(data[0])<<12 | int(data[1])<<4 | int(data[2])>>4)
+	rawT := float64(int(data[3])<<12 | int(data[4])<<4 | int(data[5])>>4)
+	rawH := float64(int(data[6])<<8 | int(data[7]))
+
+	v1 := (rawT/16384 - t1/1024) * t2
+	v2 := (rawT/131072 - t1/8192) * (rawT/131072 - t1/8192) * t3
+	tFine := v1 + v2
+	temperature = tFine / 5120
+
+	v1 = tFine/2 - 64000
+	v2 = v1 * v1 * p6 / 32768
+	v2 = v2 + v1*p5*2
+	v2 = v2/4 + p4*65536
+	v1 = (p3*v1*v1/524288 + p2*v1) / 524288
+	v1 = (1 + v1/32768) * p1
+	if v1 != 0 {
+		pa := 1048576 - rawP
+		pa = (pa - v2/4096) * 6250 / v1
+		v1 = p9 * pa * pa / 2147483648
+		v2 = pa * p8 / 32768
+		pa = pa + (v1+v2+p7)/16
+		pressure = pa / 100
+	}
+
+	hum := tFine - 76800
+	hum = (rawH - (h4*64 + h5/16384*hum)) * (h2 / 65536 * (1 + h6/67108864*hum*(1+h3/67108864*hum)))
+	hum = hum * (1 - h1*hum/524288)
+	humidity = max(0, min(hum, 100))
+	return temperature, pressure, humidity
+}
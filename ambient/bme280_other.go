@@ -0,0 +1,20 @@
+//go:build !linux
+
+package ambient
+
+import (
+	"context"
+	"errors"
+)
+
+const DEFAULT_BME280_ADDR = 0x76
+
+// BME280 is only available on Linux
+type BME280 struct {
+	Bus  string
+	Addr int
+}
+
+func (b *BME280) Read(ctx context.Context) (Conditions, error) {
+	return Conditions{}, errors.New("bme280: only supported on Linux")
+}
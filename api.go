@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huskki/summary"
+	"huskki/units"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const LOGGING_DISABLED_SETTING = "logging.disabled"
+
+type loggingChannel struct {
+	Channel string `json:"channel"`
+	Enabled bool   `json:"enabled"`
+}
+
+type latestValue struct {
+	Value     any    `json:"value"`
+	Unit      string `json:"unit,omitempty"`
+	Timestamp *int64 `json:"timestamp,omitempty"`
+	Source    string `json:"source,omitempty"`
+	// Updated is when the channel last updated, Age how many seconds ago that was
+	Updated time.Time `json:"updated,omitzero"`
+	Age     *float64  `json:"age,omitempty"`
+}
+
+// LatestHandler returns the latest value of every channel by name, for scripts to poll
+// without holding an SSE connection open. ?channels=rpm,coolant picks the channels and
+// ?units=imperial converts them, as for the dashboard.
+func LatestHandler(w http.ResponseWriter, r *http.Request) {
+	system := resolveUnits(w, r)
+	var channels []string
+	for _, c := range strings.Split(r.URL.Query().Get("channels"), ",") {
+		if c = strings.TrimSpace(strings.ToLower(c)); c != "" {
+			channels = append(channels, c)
+		}
+	}
+
+	out := map[string]latestValue{}
+	status := EventHub.ChannelStatus()
+	for channel, event := range EventHub.Snapshot() {
+		if len(channels) > 0 && !slices.Contains(channels, channel) {
+			continue
+		}
+		latest := latestValue{
+			Value:  units.Convert(system, channel, event.Value),
+			Unit:   units.Unit(system, channel, event.Unit),
+			Source: event.Source,
+		}
+		if event.HasTimestamp {
+			latest.Timestamp = &event.Timestamp
+		}
+		if s, ok := status[channel]; ok {
+			age := s.Age.Seconds()
+			latest.Updated, latest.Age = s.LastUpdate, &age
+		}
+		out[channel] = latest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// knownChannels lists every channel on the dashboard or seen on the hub so far
+func knownChannels() []string {
+	var channels []string
+	for _, card := range cards {
+		channels = append(channels, strings.ToLower(card.Name))
+	}
+	for name := range EventHub.ChannelStatus() {
+		if !slices.Contains(channels, name) {
+			channels = append(channels, name)
+		}
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+func loggingChannels() []loggingChannel {
+	var out []loggingChannel
+	for _, channel := range knownChannels() {
+		out = append(out, loggingChannel{Channel: channel, Enabled: LogFilter.Enabled(channel)})
+	}
+	return out
+}
+
+// LoggingPageHandler serves toggles for which channels are persisted to sinks
+func LoggingPageHandler(w http.ResponseWriter, r *http.Request) {
+	err := Templates.ExecuteTemplate(w, "logging", map[string]interface{}{
+		"theme":    resolveTheme(w, r),
+		"themes":   availableThemes(),
+		"channels": loggingChannels(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// LoggingHandler returns whether each channel is currently persisted to sinks
+func LoggingHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(loggingChannels()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// LoggingToggleHandler enables or disables persisting a channel, e.g. POST /api/logging?channel=rpm&enabled=false
+func LoggingToggleHandler(w http.ResponseWriter, r *http.Request) {
+	channel := strings.ToLower(r.URL.Query().Get("channel"))
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if channel == "" || err != nil {
+		http.Error(w, "expected ?channel=<name>&enabled=<true|false>", http.StatusBadRequest)
+		return
+	}
+	LogFilter.SetEnabled(channel, enabled)
+	if err := Settings.Set(LOGGING_DISABLED_SETTING, LogFilter.Disabled()); err != nil {
+		log.Printf("save logging settings: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadLoggingSettings restores channels that had logging disabled before the last restart
+func loadLoggingSettings() {
+	var disabled []string
+	if _, err := Settings.Get(LOGGING_DISABLED_SETTING, &disabled); err != nil {
+		log.Printf("load logging settings: %v", err)
+	}
+	for _, channel := range disabled {
+		LogFilter.SetEnabled(channel, false)
+	}
+}
+
+// SessionsAPIHandler returns the summaries of the rides recorded to the -log-dir, newest first
+func SessionsAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	sessions, err := summary.List(Recording.flags.LogDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// RecordingHandler returns whether a ride is being recorded, and to which file
+func RecordingHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Recording.Status()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// RecordingControlHandler starts, pauses or stops recording, e.g. POST /api/record/start.
+// Starting while paused resumes the same ride.
+func RecordingControlHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.PathValue("action") {
+	case "start":
+		err = Recording.Start()
+	case "pause":
+		err = Recording.Pause()
+	case "stop":
+		err = Recording.Stop()
+	default:
+		http.Error(w, "expected /api/record/start, /api/record/pause or /api/record/stop", http.StatusNotFound)
+		return
+	}
+	switch {
+	case errors.Is(err, ErrRecordingDisabled), errors.Is(err, ErrNotRecording), errors.Is(err, ErrDiskFull):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
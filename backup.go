@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"huskki/settings"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	DEFAULT_BACKUP_INTERVAL = 24 * time.Hour
+	DEFAULT_BACKUP_KEEP     = 7
+	BACKUP_PREFIX           = "settings-"
+	BACKUP_TIME_FORMAT      = "20060102-150405"
+)
+
+// runBackups periodically snapshots the settings store into dir, keeping the newest keep
+// backups, and/or PUTs the snapshot to url. It's run once at startup and then every interval.
+func runBackups(dir, url string, interval time.Duration, keep int) {
+	for {
+		if err := backupSettings(dir, url, keep); err != nil {
+			log.Printf("backup settings: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func backupSettings(dir, url string, keep int) error {
+	var snapshot bytes.Buffer
+	if err := Settings.Snapshot(&snapshot); err != nil {
+		return err
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		name := filepath.Join(dir, BACKUP_PREFIX+time.Now().Format(BACKUP_TIME_FORMAT)+".db")
+		if err := os.WriteFile(name, snapshot.Bytes(), 0o600); err != nil {
+			return err
+		}
+		pruneBackups(dir, keep)
+	}
+
+	if url != "" {
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(snapshot.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("upload to %s: %s", url, resp.Status)
+		}
+	}
+	return nil
+}
+
+func pruneBackups(dir string, keep int) {
+	backups, _ := filepath.Glob(filepath.Join(dir, BACKUP_PREFIX+"*.db"))
+	// Timestamped names sort oldest first
+	slices.Sort(backups)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Printf("prune backup: %v", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// runRestore replaces the settings database with a backup
+//
+//	huskki restore [-settings settings.db] backup.db
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("settings", settings.DEFAULT_PATH, "path to the settings database to overwrite")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("usage: huskki restore [-settings settings.db] backup.db")
+	}
+	if err := settings.Restore(fs.Arg(0), *path); err != nil {
+		log.Fatalf("restore %s: %v", fs.Arg(0), err)
+	}
+	log.Printf("restored %s from %s", *path, fs.Arg(0))
+}
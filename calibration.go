@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	ds "github.com/starfederation/datastar-go/datastar"
+)
+
+const (
+	CALIBRATION_SETTING = "calibration"
+	CALIBRATION_CLOSED  = "closed"
+	CALIBRATION_OPEN    = "open"
+)
+
+// calibrationChannels are the raw readings the wizard learns the travel of
+var calibrationChannels = []string{"grip", "throttle"}
+
+// calibrationWizard holds the readings recorded so far, until both ends have been
+type calibrationWizard struct {
+	mu     sync.Mutex
+	closed map[string]int
+	open   map[string]int
+}
+
+var CalibrationWizard = &calibrationWizard{}
+
+type calibrationProps struct {
+	Calibrations map[string]frames.Calibration `json:"calibrations"`
+	Closed       map[string]int                `json:"closed,omitempty"`
+	Open         map[string]int                `json:"open,omitempty"`
+	Error        string                        `json:"error,omitempty"`
+}
+
+func currentCalibration() calibrationProps {
+	CalibrationWizard.mu.Lock()
+	defer CalibrationWizard.mu.Unlock()
+	props := calibrationProps{Calibrations: map[string]frames.Calibration{}, Closed: CalibrationWizard.closed, Open: CalibrationWizard.open}
+	for _, channel := range calibrationChannels {
+		if c, ok := frames.Calibrated(channel); ok {
+			props.Calibrations[channel] = c
+		}
+	}
+	return props
+}
+
+// record takes the current raw readings as one end of the travel. Once both ends are in,
+// they're checked, applied and saved.
+func (c *calibrationWizard) record(step string) error {
+	grip, throttle, ok := ThrottleRecorder.Latest()
+	if !ok {
+		return fmt.Errorf("no grip and throttle readings yet, is the ignition on?")
+	}
+	readings := map[string]int{"grip": int(grip), "throttle": int(throttle)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch step {
+	case CALIBRATION_CLOSED:
+		c.closed = readings
+	case CALIBRATION_OPEN:
+		c.open = readings
+	default:
+		return fmt.Errorf("unknown step %q, expected %s or %s", step, CALIBRATION_CLOSED, CALIBRATION_OPEN)
+	}
+	if c.closed == nil || c.open == nil {
+		return nil
+	}
+
+	calibrations := map[string]frames.Calibration{}
+	for _, channel := range calibrationChannels {
+		cal := frames.Calibration{Closed: c.closed[channel], Open: c.open[channel]}
+		if cal.Closed == cal.Open {
+			c.open = nil
+			return fmt.Errorf("%s read %d both closed and open, open the grip fully and record again", channel, cal.Open)
+		}
+		calibrations[channel] = cal
+	}
+	c.closed, c.open = nil, nil
+	frames.SetCalibrations(calibrations)
+	if err := Settings.Set(CALIBRATION_SETTING, calibrations); err != nil {
+		log.Printf("save calibration: %v", err)
+	}
+	return nil
+}
+
+func (c *calibrationWizard) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed, c.open = nil, nil
+	frames.SetCalibrations(nil)
+	if err := Settings.Set(CALIBRATION_SETTING, map[string]frames.Calibration{}); err != nil {
+		log.Printf("save calibration: %v", err)
+	}
+}
+
+// loadCalibration restores the grip and throttle calibration learned before the last restart
+func loadCalibration() {
+	var calibrations map[string]frames.Calibration
+	if _, err := Settings.Get(CALIBRATION_SETTING, &calibrations); err != nil {
+		log.Printf("load calibration: %v", err)
+	}
+	frames.SetCalibrations(calibrations)
+}
+
+// CalibrationHandler returns the current calibration, and any readings recorded towards a new one
+func CalibrationHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(currentCalibration()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// CalibrationStepHandler records the grip closed or fully open, e.g. POST /api/calibration?step=closed
+func CalibrationStepHandler(w http.ResponseWriter, r *http.Request) {
+	err := CalibrationWizard.record(r.URL.Query().Get("step"))
+	if r.Header.Get("Datastar-Request") == "true" {
+		patchCalibration(w, r, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CalibrationResetHandler forgets the calibration, so the percentage channels stop
+func CalibrationResetHandler(w http.ResponseWriter, r *http.Request) {
+	CalibrationWizard.reset()
+	if r.Header.Get("Datastar-Request") == "true" {
+		patchCalibration(w, r, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func patchCalibration(w http.ResponseWriter, r *http.Request, err error) {
+	props := currentCalibration()
+	if err != nil {
+		props.Error = err.Error()
+	}
+	var writer strings.Builder
+	if err := Templates.ExecuteTemplate(&writer, "throttle.calibration", props); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sse := ds.NewSSE(w, r)
+	if err := sse.PatchElements(writer.String()); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// CLOCK_TOLERANCE is how far out the system clock can be before a ride's start is corrected
+	CLOCK_TOLERANCE = 2 * time.Second
+	// CLOCK_CHECK_INTERVAL is how often the system clock is checked for being set
+	CLOCK_CHECK_INTERVAL = 10 * time.Second
+)
+
+// wallClock tracks how far out the system clock is, as a Pi without an RTC or network boots
+// with the wrong time. It's learned from GPS fixes, or from the system clock being set, e.g.
+// by NTP once there's a network.
+type wallClock struct {
+	mu     sync.Mutex
+	offset time.Duration // added to the system clock for the true time
+	known  bool
+}
+
+var Clock = &wallClock{}
+
+// Now is the true time, as far as is known. It has no monotonic reading, so it's only for
+// recording, not measuring elapsed time.
+func (c *wallClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Round(0).Add(c.offset)
+}
+
+// set takes now as the true time when the system clock read at, correcting the start of the
+// ride being recorded if that changes what's known
+func (c *wallClock) set(now, at time.Time, source string) {
+	offset := now.Sub(at.Round(0))
+	c.mu.Lock()
+	changed := !c.known || (offset-c.offset).Abs() >= CLOCK_TOLERANCE
+	if changed {
+		c.offset, c.known = offset, true
+	}
+	c.mu.Unlock()
+
+	if changed && Recording != nil {
+		Recording.CorrectStart(source)
+	}
+}
+
+// watchClockSteps notices the system clock being set, by its wall time moving apart from its
+// monotonic time, and takes the system clock as right from then on
+func watchClockSteps(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		now = time.Now()
+		if step := now.Round(0).Sub(last.Round(0)) - now.Sub(last); step.Abs() >= CLOCK_TOLERANCE {
+			log.Printf("system clock set, %s out", step.Round(time.Second))
+			Clock.set(now, now, "system clock")
+		}
+		last = now
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ds "github.com/starfederation/datastar-go/datastar"
+	"huskki/hub"
+	"huskki/units"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CoalesceWindow, if set, gathers each dashboard's updates for this long and sends them as one
+// patch of the cards and one script of chart points, rather than a message per event
+var CoalesceWindow time.Duration
+
+// coalescer holds a client's events until the window is up
+type coalescer struct {
+	system units.System
+	events []hub.SensorEvent
+}
+
+func (c *coalescer) Add(event hub.SensorEvent) {
+	c.events = append(c.events, event)
+}
+
+func (c *coalescer) Pending() bool {
+	return len(c.events) > 0
+}
+
+// Flush sends the events gathered so far. Cards only need the latest value of each channel,
+// charts get every point and marker.
+func (c *coalescer) Flush(sse *ds.ServerSentEventGenerator) error {
+	latest := map[string]int{}
+	newest, timestamped := int64(0), false
+	batch := map[string][][2]float64{}
+	var markers strings.Builder
+	for i, event := range c.events {
+		latest[event.Channel] = i
+		if !event.HasTimestamp {
+			continue
+		}
+		newest, timestamped = max(newest, event.Timestamp), true
+		if event.Channel == MARKER_CHANNEL && !DISABLE_CHARTS {
+			markers.WriteString(buildMarkerScript(event.Timestamp, event.Value))
+		}
+		if DISABLE_CHARTS || !slices.ContainsFunc(charts, func(c chartProps) bool { return strings.ToLower(c.Name) == event.Channel }) {
+			continue
+		}
+		if v, ok := chartValue(units.Convert(c.system, event.Channel, event.Value)); ok {
+			batch[event.Channel] = append(batch[event.Channel], [2]float64{float64(event.Timestamp), v})
+		}
+	}
+
+	var writer strings.Builder
+	alarm := false
+	for i, event := range c.events {
+		alarm = alarm || soundsAlarm(event)
+		if latest[event.Channel] == i {
+			renderElements(&writer, event, units.Convert(c.system, event.Channel, event.Value), c.system)
+		}
+	}
+
+	// Tag the update with its newest timestamp so the client can resume from it after a dropout
+	var patchOpts []ds.PatchElementOption
+	var scriptOpts []ds.ExecuteScriptOption
+	if timestamped {
+		patchOpts = append(patchOpts, ds.WithPatchElementsEventID(strconv.FormatInt(newest, 10)))
+		scriptOpts = append(scriptOpts, ds.WithExecuteScriptEventID(strconv.FormatInt(newest, 10)))
+	}
+	if writer.Len() > 0 {
+		if err := sse.PatchElements(writer.String(), patchOpts...); err != nil {
+			return err
+		}
+	}
+	if len(batch) > 0 || markers.Len() > 0 {
+		payload, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+		if err := sse.ExecuteScript(fmt.Sprintf(`pushDataBatch(%s);`, payload)+markers.String(), scriptOpts...); err != nil {
+			return err
+		}
+	}
+
+	if alarm {
+		if err := sse.ExecuteScript(`alarm();`, scriptOpts...); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range c.events {
+		if !event.Received.IsZero() {
+			SSELatency.Observe(time.Since(event.Received))
+		}
+	}
+	c.events = c.events[:0]
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"huskki/hub"
+	"huskki/rawlog"
+	"huskki/sink"
+	"huskki/units"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CONVERT_FORMATS are the formats convert writes, each to a file of that extension
+var CONVERT_FORMATS = []string{"csv", "jsonl", "sqlite"}
+
+// runConvert decodes recorded rides as fast as they can be read, without the dashboard, writing
+// each to a file alongside it (or in -o): a wide CSV as with export, a decoded log as with
+// -log-jsonl, or a SQLite database as with -sqlite. Directories are converted ride by ride.
+//
+//	huskki convert [-profile name] [-decoders file] [-dbc file] [-units metric|imperial] [-to csv|jsonl|sqlite] [-o dir] ride.husk|dir...
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	decoding := addDecodeFlags(fs)
+	unitSystem := fs.String("units", string(units.METRIC), "units to write the csv in, metric or imperial")
+	to := fs.String("to", "csv", "csv, jsonl or sqlite")
+	out := fs.String("o", "", "directory to write to (default alongside each ride)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("usage: huskki convert [-profile name] [-decoders file] [-dbc file] [-units metric|imperial] [-to csv|jsonl|sqlite] [-o dir] ride.husk|dir...")
+	}
+	if !slices.Contains(CONVERT_FORMATS, *to) {
+		log.Fatalf("-to: unknown format %q, expected one of %s", *to, strings.Join(CONVERT_FORMATS, ", "))
+	}
+	decoding.apply()
+	system, err := units.Parse(*unitSystem)
+	if err != nil {
+		log.Fatalf("-units: %v", err)
+	}
+
+	paths, err := ridePaths(fs.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		target := rideBase(path) + "." + *to
+		if *out != "" {
+			target = filepath.Join(*out, filepath.Base(target))
+		}
+		events, frames, err := decodeLog(path)
+		if err != nil {
+			log.Fatalf("convert %s: %v", path, err)
+		}
+		if err := convertEvents(target, *to, events, system); err != nil {
+			log.Fatalf("convert %s: %v", path, err)
+		}
+		log.Printf("converted %d frames of %s to %s", frames, path, target)
+	}
+}
+
+// ridePaths expands any directories in paths to the rides recorded in them, in order
+func ridePaths(paths []string) ([]string, error) {
+	var rides []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			rides = append(rides, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			uncompressed := strings.TrimSuffix(name, rawlog.CompressionOf(name).Extension())
+			if !entry.IsDir() && strings.HasSuffix(uncompressed, RIDE_EXTENSION) {
+				rides = append(rides, filepath.Join(path, name))
+			}
+		}
+	}
+	return rides, nil
+}
+
+func convertEvents(path, format string, events []hub.SensorEvent, system units.System) error {
+	if format == "sqlite" {
+		// Appending to a database left from a previous run would duplicate every sample
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		db, err := sink.OpenSQLite(path)
+		if err != nil {
+			return err
+		}
+		if err := db.Write(events); err != nil {
+			db.Close()
+			return err
+		}
+		return db.Close()
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch format {
+	case "csv":
+		_, err = writeWideCSV(file, newWideTable(events, system))
+	case "jsonl":
+		err = writeDecodedEvents(file, events)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeDecodedEvents writes events as the lines of a decoded log
+func writeDecodedEvents(out io.Writer, events []hub.SensorEvent) error {
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		line := decodedEvent{Channel: event.Channel, Value: event.Value, Unit: event.Unit, Source: event.Source}
+		if event.HasTimestamp {
+			line.Timestamp = &event.Timestamp
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"huskki/dbc"
+	"huskki/input"
+	"log"
+)
+
+// DBC, if loaded with -dbc, decodes the messages it defines: raw CAN frames by their CAN ID,
+// everything else by DID
+var DBC *dbc.File
+
+func loadDBC(path string) {
+	var err error
+	if DBC, err = dbc.Load(path); err != nil {
+		log.Fatalf("-dbc: %v", err)
+	}
+	for id := range DBC.Messages {
+		input.RawCANIDs[id&^dbc.EXTENDED_ID_FLAG] = true
+	}
+	log.Printf("Loaded %d messages from %s", len(DBC.Messages), path)
+}
+
+func dbcMessage(did uint16, canID uint32) (*dbc.Message, bool) {
+	if DBC == nil {
+		return nil, false
+	}
+	if canID != 0 {
+		return DBC.Message(canID)
+	}
+	return DBC.Message(uint32(did))
+}
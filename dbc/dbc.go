@@ -0,0 +1,253 @@
+// Package dbc reads the message and signal definitions of Vector .dbc files, the de facto
+// format community CAN databases are shared in, and decodes signals from payloads with them.
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Message is a BO_ definition: a CAN ID (or DID) and the signals packed into its payload
+type Message struct {
+	ID      uint32
+	Name    string
+	Length  int
+	Signals []Signal
+}
+
+// Signal is an SG_ definition: value = raw * Scale + Offset, raw being Length bits from
+// StartBit in the byte order given
+type Signal struct {
+	Name         string
+	StartBit     int
+	Length       int
+	LittleEndian bool // Intel (@1), otherwise Motorola (@0)
+	Signed       bool
+	Scale        float64
+	Offset       float64
+	Min, Max     float64
+	Unit         string
+
+	Multiplexor bool
+	Multiplexed bool // only present when the multiplexor equals MuxValue
+	MuxValue    uint64
+}
+
+// File is the messages of a .dbc, by ID
+type File struct {
+	Messages map[uint32]*Message
+}
+
+// EXTENDED_ID_FLAG is set on the IDs of messages with 29-bit CAN IDs
+const EXTENDED_ID_FLAG = 0x80000000
+
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Parse reads the BO_ and SG_ lines of a .dbc, ignoring everything else
+func Parse(r io.Reader) (*File, error) {
+	file := &File{Messages: map[uint32]*Message{}}
+	var current *Message
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "BO_ "):
+			msg, err := parseMessage(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			file.Messages[msg.ID] = msg
+			current = msg
+		case strings.HasPrefix(line, "SG_ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: signal outside a message", n)
+			}
+			sig, err := parseSignal(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			current.Signals = append(current.Signals, sig)
+		case line == "":
+			current = nil
+		}
+	}
+	return file, scanner.Err()
+}
+
+// Message looks up a message by ID, with or without EXTENDED_ID_FLAG
+func (f *File) Message(id uint32) (*Message, bool) {
+	if msg, ok := f.Messages[id]; ok {
+		return msg, true
+	}
+	msg, ok := f.Messages[id|EXTENDED_ID_FLAG]
+	return msg, ok
+}
+
+// BO_ 1234 EngineData: 8 ECU
+func parseMessage(line string) (*Message, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, "BO_ "))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid message %q", line)
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message ID %q", fields[0])
+	}
+	length, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid message length %q", fields[2])
+	}
+	return &Message{ID: uint32(id), Name: strings.TrimSuffix(fields[1], ":"), Length: length}, nil
+}
+
+// SG_ EngineSpeed [M|mN] : 24|16@1+ (0.25,0) [0|16383.75] "rpm" Vector__XXX
+func parseSignal(line string) (Signal, error) {
+	head, rest, ok := strings.Cut(strings.TrimPrefix(line, "SG_ "), ":")
+	if !ok {
+		return Signal{}, fmt.Errorf("invalid signal %q", line)
+	}
+	var sig Signal
+	names := strings.Fields(head)
+	if len(names) == 0 {
+		return Signal{}, fmt.Errorf("invalid signal %q", line)
+	}
+	sig.Name = names[0]
+	if len(names) > 1 {
+		switch mux := names[1]; {
+		case mux == "M":
+			sig.Multiplexor = true
+		case strings.HasPrefix(mux, "m"):
+			v, err := strconv.ParseUint(strings.TrimSuffix(mux[1:], "M"), 10, 64)
+			if err != nil {
+				return Signal{}, fmt.Errorf("signal %s: invalid multiplexer %q", sig.Name, mux)
+			}
+			sig.Multiplexed, sig.MuxValue = true, v
+		}
+	}
+
+	// 24|16@1+ (0.25,0) [0|16383.75] "rpm" receivers
+	rest = strings.TrimSpace(rest)
+	layout, rest, _ := strings.Cut(rest, " ")
+	bits, order, ok := strings.Cut(layout, "@")
+	start, length, ok2 := strings.Cut(bits, "|")
+	if !ok || !ok2 || len(order) != 2 {
+		return Signal{}, fmt.Errorf("signal %s: invalid layout %q", sig.Name, layout)
+	}
+	var err error
+	if sig.StartBit, err = strconv.Atoi(start); err != nil {
+		return Signal{}, fmt.Errorf("signal %s: invalid start bit %q", sig.Name, start)
+	}
+	if sig.Length, err = strconv.Atoi(length); err != nil || sig.Length < 1 || sig.Length > 64 {
+		return Signal{}, fmt.Errorf("signal %s: invalid length %q", sig.Name, length)
+	}
+	sig.LittleEndian, sig.Signed = order[0] == '1', order[1] == '-'
+
+	scale, rest, ok := cutBetween(rest, "(", ")")
+	factor, offset, ok2 := strings.Cut(scale, ",")
+	if !ok || !ok2 {
+		return Signal{}, fmt.Errorf("signal %s: missing (scale,offset)", sig.Name)
+	}
+	if sig.Scale, err = strconv.ParseFloat(factor, 64); err != nil {
+		return Signal{}, fmt.Errorf("signal %s: invalid scale %q", sig.Name, factor)
+	}
+	if sig.Offset, err = strconv.ParseFloat(offset, 64); err != nil {
+		return Signal{}, fmt.Errorf("signal %s: invalid offset %q", sig.Name, offset)
+	}
+	if limits, r, ok := cutBetween(rest, "[", "]"); ok {
+		lo, hi, _ := strings.Cut(limits, "|")
+		sig.Min, _ = strconv.ParseFloat(lo, 64)
+		sig.Max, _ = strconv.ParseFloat(hi, 64)
+		rest = r
+	}
+	if unit, _, ok := cutBetween(rest, `"`, `"`); ok {
+		sig.Unit = unit
+	}
+	return sig, nil
+}
+
+// cutBetween returns the text between open and close, and what follows close
+func cutBetween(s, open, close string) (string, string, bool) {
+	_, after, ok := strings.Cut(s, open)
+	if !ok {
+		return "", s, false
+	}
+	inside, after, ok := strings.Cut(after, close)
+	return inside, after, ok
+}
+
+// Decode extracts the signal's value from a payload. ok is false if the payload is too short.
+func (s Signal) Decode(data []byte) (float64, bool) {
+	raw, ok := s.Raw(data)
+	if !ok {
+		return 0, false
+	}
+	value := float64(raw)
+	if s.Signed && s.Length < 64 && raw&(1<<(s.Length-1)) != 0 {
+		value -= float64(uint64(1) << s.Length)
+	} else if s.Signed {
+		value = float64(int64(raw))
+	}
+	return value*s.Scale + s.Offset, true
+}
+
+// Raw extracts the signal's bits, unscaled
+func (s Signal) Raw(data []byte) (uint64, bool) {
+	var raw uint64
+	pos := s.StartBit
+	for i := range s.Length {
+		if pos < 0 || pos/8 >= len(data) {
+			return 0, false
+		}
+		bit := uint64(data[pos/8]>>(pos%8)) & 1
+		if s.LittleEndian {
+			// Intel: StartBit is the LSB, counting up through the bytes
+			raw |= bit << i
+			pos++
+		} else {
+			// Motorola: StartBit is the MSB, counting down each byte then on to the next's MSB
+			raw = raw<<1 | bit
+			if pos%8 == 0 {
+				pos += 15
+			} else {
+				pos--
+			}
+		}
+	}
+	return raw, true
+}
+
+// Decode extracts every signal present in the payload, by name, taking multiplexing into account
+func (m *Message) Decode(data []byte) map[string]float64 {
+	var mux uint64
+	muxed := false
+	for _, s := range m.Signals {
+		if s.Multiplexor {
+			mux, muxed = s.Raw(data)
+		}
+	}
+	values := map[string]float64{}
+	for _, s := range m.Signals {
+		if s.Multiplexed && (!muxed || s.MuxValue != mux) {
+			continue
+		}
+		if v, ok := s.Decode(data); ok {
+			values[s.Name] = v
+		}
+	}
+	return values
+}
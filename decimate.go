@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"huskki/hub"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UIRates caps how often each channel is sent to the dashboard, in Hz, e.g. rpm at 20. Channels
+// not listed are sent on every update. Sinks and the raw log are unaffected.
+var UIRates = map[string]float64{}
+
+// RemoteMaxRate, if set, limits how many events per second of each channel are sent to
+// dashboards on other machines, e.g. a phone over a hotspot, so they get a throttled stream. A
+// page can ask for its own limit with ?max-rate=.
+var RemoteMaxRate float64
+
+// maxRate is the per channel events per second limit for a dashboard client, 0 for none
+func maxRate(r *http.Request) float64 {
+	if rate, err := strconv.ParseFloat(r.URL.Query().Get("max-rate"), 64); err == nil && rate >= 0 {
+		return rate
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return 0
+	}
+	return RemoteMaxRate
+}
+
+// parseRates parses a comma separated list of channel=Hz, e.g. "rpm=20,coolant=1"
+func parseRates(s string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		channel, hz, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate %q, expected channel=Hz", pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(hz), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s, expected Hz above 0", hz, channel)
+		}
+		rates[strings.ToLower(strings.TrimSpace(channel))] = rate
+	}
+	return rates, nil
+}
+
+// decimator drops a client's updates to a channel that come sooner than its rate allows, going
+// by the logger's timestamps so replays are decimated the same as live data. The latest dropped
+// update of each channel is held and sent once the interval is up, so a channel that goes quiet
+// still shows its last value.
+type decimator struct {
+	rates map[string]float64
+	sent  map[string]int64
+	held  map[string]heldEvent
+}
+
+type heldEvent struct {
+	event hub.SensorEvent
+	due   time.Time
+}
+
+func newDecimator(rates map[string]float64) *decimator {
+	return &decimator{rates: rates, sent: map[string]int64{}, held: map[string]heldEvent{}}
+}
+
+// Keep reports whether the event should be sent, holding it for Flush if not
+func (d *decimator) Keep(event hub.SensorEvent) bool {
+	rate, ok := d.rates[event.Channel]
+	if !ok || !event.HasTimestamp {
+		return true
+	}
+	interval := 1000 / rate
+	last, sent := d.sent[event.Channel]
+	// Timestamps going backwards mean the logger or replay restarted
+	if sent && event.Timestamp >= last && float64(event.Timestamp-last) < interval {
+		due := time.Now().Add(time.Duration((interval - float64(event.Timestamp-last)) * float64(time.Millisecond)))
+		if held, ok := d.held[event.Channel]; ok {
+			due = held.due
+		}
+		d.held[event.Channel] = heldEvent{event: event, due: due}
+		return false
+	}
+	delete(d.held, event.Channel)
+	d.sent[event.Channel] = event.Timestamp
+	return true
+}
+
+// Next is when the earliest held event is due, false if there are none
+func (d *decimator) Next() (time.Time, bool) {
+	var next time.Time
+	for _, held := range d.held {
+		if next.IsZero() || held.due.Before(next) {
+			next = held.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Flush returns the held events due by now, oldest first, as sent
+func (d *decimator) Flush(now time.Time) []hub.SensorEvent {
+	var events []hub.SensorEvent
+	for channel, held := range d.held {
+		if held.due.After(now) {
+			continue
+		}
+		events = append(events, held.event)
+		d.sent[channel] = held.event.Timestamp
+		delete(d.held, channel)
+	}
+	slices.SortFunc(events, func(a, b hub.SensorEvent) int { return cmp.Compare(a.Timestamp, b.Timestamp) })
+	return events
+}
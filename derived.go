@@ -0,0 +1,39 @@
+package main
+
+import (
+	"huskki/derived"
+	"huskki/hub"
+	"log"
+)
+
+// loadDerived builds the derived channel engine from the built in channels, plus those in path
+func loadDerived(path string) *derived.Engine {
+	channels := derived.Defaults()
+	if path != "" {
+		extra, err := derived.Load(path)
+		if err != nil {
+			log.Fatalf("-derived: %v", err)
+		}
+		channels = derived.Merge(channels, extra)
+	}
+	engine, err := derived.NewEngine(channels)
+	if err != nil {
+		log.Fatalf("-derived: %v", err)
+	}
+	return engine
+}
+
+// watchDerived broadcasts derived channels whenever one of their inputs updates, on the input
+// event's timestamp. The returned function stops it.
+func watchDerived(h *hub.EventHub, engine *derived.Engine) func() {
+	id, ch, cancel := h.Subscribe(engine.Inputs()...)
+	h.SetName(id, "derived")
+	go func() {
+		for event := range ch {
+			for _, derived := range engine.Update(event) {
+				h.Broadcast(derived)
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,216 @@
+// Package derived computes channels from other channels, as defined in derived.yaml
+package derived
+
+import (
+	_ "embed"
+	"fmt"
+	"huskki/hub"
+	"math"
+	"os"
+	"slices"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Operations combining a channel's inputs
+const (
+	OP_DIFFERENCE = "difference"
+	OP_SUM        = "sum"
+	OP_PRODUCT    = "product"
+	OP_RATIO      = "ratio"
+	OP_RATE       = "rate"
+)
+
+// Channel describes a derived channel, see derived.yaml
+type Channel struct {
+	Name     string   `yaml:"name"`
+	Op       string   `yaml:"op"`
+	Inputs   []string `yaml:"inputs"`
+	Scale    float64  `yaml:"scale"` // 0 is taken as 1
+	Offset   float64  `yaml:"offset"`
+	Unit     string   `yaml:"unit"`
+	Decimals int      `yaml:"decimals"`
+}
+
+//go:embed derived.yaml
+var defaultChannels []byte
+
+// Defaults are the built in derived channels
+func Defaults() []Channel {
+	channels, err := Parse(defaultChannels)
+	if err != nil {
+		panic(err)
+	}
+	return channels
+}
+
+// Merge adds to or overrides channels by name
+func Merge(channels, extra []Channel) []Channel {
+	merged := append([]Channel{}, channels...)
+	for _, c := range extra {
+		if i := slices.IndexFunc(merged, func(m Channel) bool { return m.Name == c.Name }); i >= 0 {
+			merged[i] = c
+		} else {
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
+
+// Load reads derived channels in the format of derived.yaml
+func Load(path string) ([]Channel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return channels, nil
+}
+
+func Parse(data []byte) ([]Channel, error) {
+	var channels []Channel
+	if err := yaml.Unmarshal(data, &channels); err != nil {
+		return nil, err
+	}
+	for _, c := range channels {
+		want := 2
+		switch c.Op {
+		case OP_DIFFERENCE, OP_RATIO:
+		case OP_SUM, OP_PRODUCT:
+			want = max(len(c.Inputs), 2)
+		case OP_RATE:
+			want = 1
+		default:
+			return nil, fmt.Errorf("derived channel %s: unknown op %q", c.Name, c.Op)
+		}
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("derived channel with op %s has no name", c.Op)
+		case len(c.Inputs) != want:
+			return nil, fmt.Errorf("derived channel %s: %s takes %d inputs", c.Name, c.Op, want)
+		}
+	}
+	return channels, nil
+}
+
+// Value is a derived channel's rounded value as broadcast: an int, or a float64 if it has decimals
+func (c Channel) Value(value float64) any {
+	pow := math.Pow10(c.Decimals)
+	value = math.Round(value*pow) / pow
+	if c.Decimals > 0 {
+		return value
+	}
+	return int(value)
+}
+
+func (c Channel) scale() float64 {
+	if c.Scale == 0 {
+		return 1
+	}
+	return c.Scale
+}
+
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+// Engine keeps the latest value of every input and computes derived channels as they change
+type Engine struct {
+	mu       sync.Mutex
+	channels []Channel
+	latest   map[string]sample
+	previous map[string]sample
+}
+
+// NewEngine checks no channel takes another derived channel as an input, which could loop
+func NewEngine(channels []Channel) (*Engine, error) {
+	for _, c := range channels {
+		for _, input := range c.Inputs {
+			if slices.ContainsFunc(channels, func(d Channel) bool { return d.Name == input }) {
+				return nil, fmt.Errorf("derived channel %s: input %s is itself derived", c.Name, input)
+			}
+		}
+	}
+	return &Engine{channels: channels, latest: map[string]sample{}, previous: map[string]sample{}}, nil
+}
+
+// Inputs lists every channel the engine needs to see
+func (e *Engine) Inputs() []string {
+	var inputs []string
+	for _, c := range e.channels {
+		for _, input := range c.Inputs {
+			if !slices.Contains(inputs, input) {
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	return inputs
+}
+
+// Update records a timestamped input and returns the derived channels that could be computed
+// with it, on its timestamp
+func (e *Engine) Update(event hub.SensorEvent) []hub.SensorEvent {
+	value, ok := event.Float()
+	if !ok || !event.HasTimestamp {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s, ok := e.latest[event.Channel]; ok {
+		e.previous[event.Channel] = s
+	}
+	e.latest[event.Channel] = sample{value: value, timestamp: event.Timestamp}
+
+	var out []hub.SensorEvent
+	for _, c := range e.channels {
+		if !slices.Contains(c.Inputs, event.Channel) {
+			continue
+		}
+		if v, ok := e.compute(c); ok {
+			derived := hub.Sample(c.Name, c.Value(v*c.scale()+c.Offset), c.Unit, event.Timestamp, event.Received)
+			derived.Source = event.Source
+			out = append(out, derived)
+		}
+	}
+	return out
+}
+
+func (e *Engine) compute(c Channel) (float64, bool) {
+	in := make([]float64, len(c.Inputs))
+	for i, input := range c.Inputs {
+		s, ok := e.latest[input]
+		if !ok {
+			return 0, false
+		}
+		in[i] = s.value
+	}
+	switch c.Op {
+	case OP_DIFFERENCE:
+		return in[0] - in[1], true
+	case OP_SUM, OP_PRODUCT:
+		v := in[0]
+		for _, x := range in[1:] {
+			if c.Op == OP_SUM {
+				v += x
+			} else {
+				v *= x
+			}
+		}
+		return v, true
+	case OP_RATIO:
+		return in[0] / in[1], in[1] != 0
+	case OP_RATE:
+		prev, ok := e.previous[c.Inputs[0]]
+		cur := e.latest[c.Inputs[0]]
+		if !ok || cur.timestamp <= prev.timestamp {
+			return 0, false
+		}
+		return (cur.value - prev.value) * 1000 / float64(cur.timestamp-prev.timestamp), true
+	}
+	return 0, false
+}
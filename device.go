@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"huskki/input"
+	"log"
+	"net/http"
+)
+
+const (
+	DEVICE_CONFIG_SETTING = "device.config"
+	// Bounds on how often the logger can be asked to sample a DID
+	MIN_SAMPLE_INTERVAL_MS = 10
+	MAX_SAMPLE_INTERVAL_MS = 60000
+	// MAX_SAMPLES is how many DIDs the logger has room to be configured with (MAX_CFG_DIDS)
+	MAX_SAMPLES = 12
+)
+
+// Device is the live logger, if the input source can configure it
+var Device input.Configurer
+
+type deviceConfig struct {
+	Samples []frames.Sample `json:"samples"`
+}
+
+// pushDeviceConfig sends the logger the sampling config last saved, if any, as it forgets it
+// when it resets
+func pushDeviceConfig() {
+	var config deviceConfig
+	ok, err := Settings.Get(DEVICE_CONFIG_SETTING, &config)
+	if err != nil {
+		log.Printf("load device config: %v", err)
+		return
+	}
+	if !ok || len(config.Samples) == 0 || Device == nil {
+		return
+	}
+	if err := Device.Configure(config.Samples); err != nil {
+		log.Printf("configure logger: %v", err)
+		return
+	}
+	log.Printf("Sent the logger its sampling config of %d DIDs", len(config.Samples))
+}
+
+// DeviceConfigHandler returns the sampling config last sent to the logger
+func DeviceConfigHandler(w http.ResponseWriter, _ *http.Request) {
+	config := deviceConfig{Samples: []frames.Sample{}}
+	if _, err := Settings.Get(DEVICE_CONFIG_SETTING, &config); err != nil {
+		log.Printf("load device config: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// DeviceConfigUpdateHandler sends a new sampling config to the logger, e.g.
+// POST /api/device/config {"samples":[{"did":256,"intervalMs":50}]}. The config is only saved
+// once the logger has ACKed it, and a NACK or no reply is a 502.
+func DeviceConfigUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if Device == nil {
+		http.Error(w, "the input source can't be configured", http.StatusServiceUnavailable)
+		return
+	}
+	var config deviceConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(config.Samples) == 0 || len(config.Samples) > MAX_SAMPLES {
+		http.Error(w, fmt.Sprintf("expected 1..%d samples", MAX_SAMPLES), http.StatusBadRequest)
+		return
+	}
+	for _, s := range config.Samples {
+		if s.IntervalMs < MIN_SAMPLE_INTERVAL_MS || s.IntervalMs > MAX_SAMPLE_INTERVAL_MS {
+			http.Error(w, fmt.Sprintf("DID 0x%04X: intervalMs must be %d..%d", s.DID, MIN_SAMPLE_INTERVAL_MS, MAX_SAMPLE_INTERVAL_MS), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := Device.Configure(config.Samples); err != nil {
+		http.Error(w, fmt.Sprintf("configure logger: %v", err), http.StatusBadGateway)
+		return
+	}
+	if err := Settings.Set(DEVICE_CONFIG_SETTING, config); err != nil {
+		log.Printf("save device config: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ds "github.com/starfederation/datastar-go/datastar"
+	"huskki/dtc"
+	"huskki/input"
+	"net/http"
+	"strings"
+)
+
+// Diagnostics reads the ECU's trouble codes, if the input source can
+var Diagnostics input.DTCReader
+
+type dtcProps struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Stored      bool   `json:"stored"`
+	Pending     bool   `json:"pending"`
+}
+
+func readDTCs() ([]dtcProps, error) {
+	if Diagnostics == nil {
+		return nil, fmt.Errorf("the input source can't read trouble codes")
+	}
+	codes, err := Diagnostics.ReadDTCs()
+	if err != nil {
+		return nil, err
+	}
+	props := []dtcProps{}
+	for _, c := range codes {
+		props = append(props, dtcPropsOf(c))
+	}
+	return props, nil
+}
+
+func dtcPropsOf(c dtc.Code) dtcProps {
+	return dtcProps{Code: c.String(), Description: c.Description(), Stored: c.Stored(), Pending: c.Pending()}
+}
+
+// DiagnosticsHandler shows the ECU's stored and pending trouble codes
+func DiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	codes, err := readDTCs()
+	data := map[string]interface{}{
+		"theme":  resolveTheme(w, r),
+		"themes": availableThemes(),
+		"codes":  codes,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	if err := Templates.ExecuteTemplate(w, "diagnostics", data); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DTCHandler returns the ECU's trouble codes as JSON
+func DTCHandler(w http.ResponseWriter, _ *http.Request) {
+	codes, err := readDTCs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(codes); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// DTCClearHandler clears the ECU's trouble codes. Called from the diagnostics page, the codes
+// are re-read and patched in so the page shows whatever came straight back.
+func DTCClearHandler(w http.ResponseWriter, r *http.Request) {
+	if Diagnostics == nil {
+		http.Error(w, "the input source can't clear trouble codes", http.StatusServiceUnavailable)
+		return
+	}
+	clearErr := Diagnostics.ClearDTCs()
+	if r.Header.Get("Datastar-Request") != "true" {
+		if clearErr != nil {
+			http.Error(w, fmt.Sprintf("clear trouble codes: %v", clearErr), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	codes, err := readDTCs()
+	data := map[string]interface{}{"codes": codes}
+	if clearErr != nil {
+		data["error"] = fmt.Sprintf("clear trouble codes: %v", clearErr)
+	} else if err != nil {
+		data["error"] = err.Error()
+	}
+	var fragment strings.Builder
+	if err := Templates.ExecuteTemplate(&fragment, "diagnostics.codes", data); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sse := ds.NewSSE(w, r)
+	if err := sse.PatchElements(fragment.String()); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"huskki/discover"
+	"huskki/frames"
+	"net/http"
+	"strings"
+	"time"
+
+	ds "github.com/starfederation/datastar-go/datastar"
+)
+
+const DISCOVER_INTERVAL = 500 * time.Millisecond
+
+type discoverProps struct {
+	discover.DID
+	// Channel is the decoder the DID is matched to, if any
+	Channel string
+}
+
+func discoveredDIDs() []discoverProps {
+	dids := Discovery.DIDs()
+	props := make([]discoverProps, len(dids))
+	for i, d := range dids {
+		channel, _, _ := frames.Decode(d.DID, nil)
+		props[i] = discoverProps{DID: d, Channel: channel}
+	}
+	return props
+}
+
+// DiscoverHandler serves a census of every DID seen, for reverse engineering unknown ones
+func DiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	err := Templates.ExecuteTemplate(w, "discover", map[string]interface{}{
+		"theme":  resolveTheme(w, r),
+		"themes": availableThemes(),
+		"dids":   discoveredDIDs(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DiscoverEventsHandler periodically patches the discover page with the latest payloads
+func DiscoverEventsHandler(w http.ResponseWriter, r *http.Request) {
+	sse := ds.NewSSE(w, r)
+	defer Idle.Connect()()
+
+	ticker := time.NewTicker(DISCOVER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			var writer strings.Builder
+			err := Templates.ExecuteTemplate(&writer, "discover.dids", discoveredDIDs())
+			if err == nil {
+				err = sse.PatchElements(writer.String())
+			}
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	}
+}
+
+// DiscoverResetHandler forgets every DID seen so far
+func DiscoverResetHandler(w http.ResponseWriter, _ *http.Request) {
+	Discovery.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+// Package discover keeps a census of every DID seen on the stream, decoded or not, for working
+// out what unknown DIDs carry.
+package discover
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payloads kept per DID for the live hex view
+const HISTORY = 8
+
+// DID is what's been seen of one DID
+type DID struct {
+	DID     uint16
+	Frames  int
+	First   time.Time
+	Last    time.Time
+	Lengths map[int]int // payload length -> frames
+	// Payloads are the most recent, newest first
+	Payloads [][]byte
+	// Changed marks the byte positions that have taken more than one value
+	Changed []bool
+}
+
+// Rate is the average frames per second since the DID was first seen
+func (d DID) Rate() float64 {
+	elapsed := d.Last.Sub(d.First).Seconds()
+	if d.Frames < 2 || elapsed <= 0 {
+		return 0
+	}
+	return float64(d.Frames-1) / elapsed
+}
+
+// LengthsString lists the payload lengths seen, most common first, e.g. "2 (1200), 4 (3)"
+func (d DID) LengthsString() string {
+	lengths := make([]int, 0, len(d.Lengths))
+	for l := range d.Lengths {
+		lengths = append(lengths, l)
+	}
+	sort.Slice(lengths, func(i, j int) bool {
+		if d.Lengths[lengths[i]] != d.Lengths[lengths[j]] {
+			return d.Lengths[lengths[i]] > d.Lengths[lengths[j]]
+		}
+		return lengths[i] < lengths[j]
+	})
+	parts := make([]string, len(lengths))
+	for i, l := range lengths {
+		parts[i] = fmt.Sprintf("%d (%d)", l, d.Lengths[l])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Byte is one byte of a payload for display, flagged if its position varies
+type Byte struct {
+	Hex     string
+	Changed bool
+}
+
+// Bytes splits a payload for display
+func (d DID) Bytes(payload []byte) []Byte {
+	out := make([]Byte, len(payload))
+	for i, b := range payload {
+		out[i] = Byte{Hex: fmt.Sprintf("%02X", b), Changed: i < len(d.Changed) && d.Changed[i]}
+	}
+	return out
+}
+
+// Recorder counts frames by DID
+type Recorder struct {
+	mu   sync.Mutex
+	dids map[uint16]*DID
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{dids: map[uint16]*DID{}}
+}
+
+// Observe records a frame
+func (r *Recorder) Observe(did uint16, data []byte, received time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.dids[did]
+	if !ok {
+		d = &DID{DID: did, First: received, Lengths: map[int]int{}}
+		r.dids[did] = d
+	}
+	if len(d.Payloads) > 0 {
+		last := d.Payloads[0]
+		for i := range data {
+			if i >= len(d.Changed) {
+				d.Changed = append(d.Changed, false)
+			}
+			if i >= len(last) || data[i] != last[i] {
+				d.Changed[i] = true
+			}
+		}
+	}
+	d.Frames++
+	d.Last = received
+	d.Lengths[len(data)]++
+	d.Payloads = append([][]byte{append([]byte{}, data...)}, d.Payloads...)
+	if len(d.Payloads) > HISTORY {
+		d.Payloads = d.Payloads[:HISTORY]
+	}
+}
+
+// DIDs returns a copy of everything seen, ordered by DID
+func (r *Recorder) DIDs() []DID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]DID, 0, len(r.dids))
+	for _, d := range r.dids {
+		c := *d
+		c.Lengths = make(map[int]int, len(d.Lengths))
+		for l, n := range d.Lengths {
+			c.Lengths[l] = n
+		}
+		c.Payloads = append([][]byte{}, d.Payloads...)
+		c.Changed = append([]bool{}, d.Changed...)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DID < out[j].DID })
+	return out
+}
+
+// Reset forgets everything seen so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dids = map[uint16]*DID{}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"huskki/hub"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DISK_LOW_CHANNEL carries whether the log directory is too full to record to
+const DISK_LOW_CHANNEL = "disk_low"
+
+const (
+	DISK_CHECK_INTERVAL = 30 * time.Second
+	MEGABYTE            = 1 << 20
+)
+
+var ErrDiskFull = errors.New("not enough free disk space to record")
+
+// guardDiskSpace checks the free space of the log directory every interval. Once it drops
+// below minFree, the oldest rides are deleted to make room if prune is set, otherwise (or if
+// that isn't enough) recording stops and the dashboard warns until there's space again.
+func (r *Recorder) guardDiskSpace(minFree uint64, prune bool, interval time.Duration) {
+	low := false
+	for {
+		free, err := r.freeSpace()
+		if err != nil {
+			log.Printf("disk space guard: %v, no longer checking", err)
+			return
+		}
+		if free < minFree && prune {
+			free = r.prune(minFree)
+		}
+
+		if free < minFree && !low {
+			log.Printf("only %d MB free for recording, stopping", free/MEGABYTE)
+			r.mu.Lock()
+			r.diskLow = true
+			r.mu.Unlock()
+			if err := r.Stop(); err != nil {
+				log.Printf("close raw log: %v", err)
+			}
+			EventHub.Broadcast(hub.Status(DISK_LOW_CHANNEL, true))
+			low = true
+		} else if free >= minFree && low {
+			log.Printf("%d MB free for recording again", free/MEGABYTE)
+			r.mu.Lock()
+			r.diskLow = false
+			r.mu.Unlock()
+			EventHub.Broadcast(hub.Status(DISK_LOW_CHANNEL, false))
+			low = false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// freeSpace is the free space where rides are recorded, -log-file's directory if there is one
+func (r *Recorder) freeSpace() (uint64, error) {
+	dir := r.flags.LogDir
+	if r.flags.LogFile != "" {
+		dir = filepath.Dir(r.flags.LogFile)
+	}
+	// The directory may not have been created yet
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return freeSpace(dir)
+}
+
+// prune deletes the oldest rides in the log directory, along with their index, decoded log and
+// summary, until there's minFree, returning the free space left. The ride being recorded is kept.
+func (r *Recorder) prune(minFree uint64) uint64 {
+	rides := map[string][]string{}
+	entries, err := os.ReadDir(r.flags.LogDir)
+	if err != nil {
+		log.Printf("prune rides: %v", err)
+	}
+	for _, entry := range entries {
+		// Rides' files are all named for when it started
+		name, _, _ := strings.Cut(entry.Name(), ".")
+		if _, err := time.Parse(RIDE_TIME_FORMAT, name); err == nil && !entry.IsDir() {
+			rides[name] = append(rides[name], filepath.Join(r.flags.LogDir, entry.Name()))
+		}
+	}
+	if current := r.Status().File; current != "" {
+		name, _, _ := strings.Cut(current, ".")
+		delete(rides, name)
+	}
+
+	names := make([]string, 0, len(rides))
+	for name := range rides {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	free, err := r.freeSpace()
+	for _, name := range names {
+		if err != nil || free >= minFree {
+			break
+		}
+		log.Printf("pruning ride %s to free disk space", name)
+		for _, path := range rides[name] {
+			if err := os.Remove(path); err != nil {
+				log.Printf("prune ride: %v", err)
+			}
+		}
+		free, err = r.freeSpace()
+	}
+	return free
+}
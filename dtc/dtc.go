@@ -0,0 +1,124 @@
+// Package dtc decodes diagnostic trouble codes as read over UDS (0x19) or OBD-II (modes 03/07)
+// into SAE J2012 codes and descriptions.
+package dtc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UDS DTC status bits
+const (
+	STATUS_PENDING   = 0x04
+	STATUS_CONFIRMED = 0x08
+)
+
+// Code is a 3 byte UDS DTC, the J2012 code and a failure type byte, and its status. OBD-II
+// codes have no failure type.
+type Code struct {
+	DTC    uint32
+	Status byte
+}
+
+// FromOBD builds a code from the two bytes of an OBD-II mode 03/07 reply
+func FromOBD(hi, lo byte, status byte) Code {
+	return Code{DTC: uint32(hi)<<16 | uint32(lo)<<8, Status: status}
+}
+
+// J2012 is the code as shown by scan tools, e.g. P0123
+func (c Code) J2012() string {
+	hi, lo := byte(c.DTC>>16), byte(c.DTC>>8)
+	system := "PCBU"[hi>>6]
+	return fmt.Sprintf("%c%d%X%02X", system, (hi>>4)&0x3, hi&0xF, lo)
+}
+
+// String is the J2012 code, followed by the failure type if there is one, e.g. P0123-1A
+func (c Code) String() string {
+	if ftb := byte(c.DTC); ftb != 0 {
+		return fmt.Sprintf("%s-%02X", c.J2012(), ftb)
+	}
+	return c.J2012()
+}
+
+func (c Code) Pending() bool { return c.Status&STATUS_PENDING != 0 }
+func (c Code) Stored() bool  { return c.Status&STATUS_CONFIRMED != 0 }
+
+// Description looks the code up in the generic SAE codes, anything else being manufacturer
+// specific
+func (c Code) Description() string {
+	code := c.J2012()
+	if d, ok := descriptions[code]; ok {
+		return d
+	}
+	if code[1] == '1' || code[1] == '3' {
+		return "Manufacturer specific"
+	}
+	switch {
+	case strings.HasPrefix(code, "P03"):
+		return "Ignition system or misfire"
+	case strings.HasPrefix(code, "P02"):
+		return "Fuel and air metering (injector circuit)"
+	case strings.HasPrefix(code, "P01"):
+		return "Fuel and air metering"
+	case strings.HasPrefix(code, "P05"):
+		return "Vehicle speed, idle control or auxiliary inputs"
+	case strings.HasPrefix(code, "P06"):
+		return "Computer output circuit"
+	}
+	return "Unknown"
+}
+
+// Generic SAE J2012 codes most likely to be seen on a single cylinder bike
+var descriptions = map[string]string{
+	"P0105": "Manifold absolute pressure circuit",
+	"P0106": "Manifold absolute pressure range/performance",
+	"P0107": "Manifold absolute pressure circuit low",
+	"P0108": "Manifold absolute pressure circuit high",
+	"P0110": "Intake air temperature circuit",
+	"P0112": "Intake air temperature circuit low",
+	"P0113": "Intake air temperature circuit high",
+	"P0115": "Engine coolant temperature circuit",
+	"P0116": "Engine coolant temperature range/performance",
+	"P0117": "Engine coolant temperature circuit low",
+	"P0118": "Engine coolant temperature circuit high",
+	"P0120": "Throttle position sensor circuit",
+	"P0121": "Throttle position sensor range/performance",
+	"P0122": "Throttle position sensor circuit low",
+	"P0123": "Throttle position sensor circuit high",
+	"P0130": "O2 sensor circuit (bank 1 sensor 1)",
+	"P0131": "O2 sensor circuit low voltage (bank 1 sensor 1)",
+	"P0132": "O2 sensor circuit high voltage (bank 1 sensor 1)",
+	"P0133": "O2 sensor slow response (bank 1 sensor 1)",
+	"P0134": "O2 sensor no activity (bank 1 sensor 1)",
+	"P0135": "O2 sensor heater circuit (bank 1 sensor 1)",
+	"P0171": "System too lean (bank 1)",
+	"P0172": "System too rich (bank 1)",
+	"P0201": "Injector circuit, cylinder 1",
+	"P0230": "Fuel pump primary circuit",
+	"P0231": "Fuel pump secondary circuit low",
+	"P0232": "Fuel pump secondary circuit high",
+	"P0300": "Random/multiple cylinder misfire",
+	"P0301": "Cylinder 1 misfire",
+	"P0335": "Crankshaft position sensor circuit",
+	"P0336": "Crankshaft position sensor range/performance",
+	"P0340": "Camshaft position sensor circuit",
+	"P0351": "Ignition coil A primary/secondary circuit",
+	"P0500": "Vehicle speed sensor",
+	"P0505": "Idle air control system",
+	"P0507": "Idle air control system RPM higher than expected",
+	"P0560": "System voltage",
+	"P0562": "System voltage low",
+	"P0563": "System voltage high",
+	"P0601": "Internal control module memory checksum error",
+	"P0603": "Internal control module keep alive memory error",
+	"P0605": "Internal control module ROM error",
+	"P0606": "Control module processor",
+	"P0627": "Fuel pump control circuit open",
+	"P0650": "Malfunction indicator lamp control circuit",
+	"P0705": "Transmission range sensor circuit (gear position)",
+	"P0850": "Park/neutral switch input circuit",
+	"P2300": "Ignition coil A primary control circuit low",
+	"P2301": "Ignition coil A primary control circuit high",
+	"U0001": "High speed CAN communication bus",
+	"U0100": "Lost communication with ECM/PCM",
+}
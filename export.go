@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"huskki/frames"
+	"huskki/hub"
+	"huskki/input"
+	"huskki/quarantine"
+	"huskki/rawlog"
+	"huskki/timeline"
+	"huskki/units"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXPORT_FORMATS are the formats export writes, also the file extensions that pick them
+var EXPORT_FORMATS = []string{"csv", "parquet", "mf4"}
+
+// runExport decodes a recorded log into a wide CSV, Parquet or MDF4 file, a row per timestamp and a
+// column per channel holding its latest value, for analysis in a spreadsheet, pandas, DuckDB or
+// CANape
+//
+//	huskki export [-profile name] [-decoders file] [-dbc file] [-units metric|imperial] [-format csv|parquet|mf4] [-o ride.csv] ride.husk
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	decoding := addDecodeFlags(fs)
+	unitSystem := fs.String("units", string(units.METRIC), "units to export in, metric or imperial")
+	format := fs.String("format", "", "csv, parquet or mf4 (default from the -o extension, otherwise csv)")
+	out := fs.String("o", "", "output path (default stdout)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("usage: huskki export [-profile name] [-decoders file] [-dbc file] [-units metric|imperial] [-format csv|parquet|mf4] [-o out.csv] ride.husk")
+	}
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(*out), ".")); slices.Contains(EXPORT_FORMATS, ext) {
+			*format = ext
+		}
+	}
+	if !slices.Contains(EXPORT_FORMATS, *format) {
+		log.Fatalf("-format: unknown format %q, expected one of %s", *format, strings.Join(EXPORT_FORMATS, ", "))
+	}
+
+	decoding.apply()
+	system, err := units.Parse(*unitSystem)
+	if err != nil {
+		log.Fatalf("-units: %v", err)
+	}
+
+	events, _, err := decodeLog(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("export %s: %v", fs.Arg(0), err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	table := newWideTable(events, system)
+	var rows int
+	switch *format {
+	case "csv":
+		rows, err = writeWideCSV(w, table)
+	case "parquet":
+		rows, err = writeWideParquet(w, table)
+	case "mf4":
+		rows, err = writeWideMDF(w, table, filepath.Base(fs.Arg(0)))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %d rows from %s", rows, fs.Arg(0))
+}
+
+// decodeFlags are how subcommands that decode recorded logs are told to decode them, as with
+// the flags of the same names when running live
+type decodeFlags struct {
+	profile, decoders, dbc *string
+}
+
+func addDecodeFlags(fs *flag.FlagSet) *decodeFlags {
+	return &decodeFlags{
+		profile:  fs.String("profile", "", "decoders and ranges for a particular bike, one of "+strings.Join(frames.Profiles(), ", ")+" or a profile YAML file"),
+		decoders: fs.String("decoders", "", "YAML file of DID decoders to add to or override the built in ones, and the -profile's"),
+		dbc:      fs.String("dbc", "", "decode signals with the messages of this .dbc file"),
+	}
+}
+
+func (d *decodeFlags) apply() {
+	if *d.profile != "" {
+		p, err := frames.LoadProfile(*d.profile)
+		if err != nil {
+			log.Fatalf("-profile: %v", err)
+		}
+		applyProfile(p)
+	}
+	if *d.decoders != "" {
+		decoders, err := frames.LoadDecoders(*d.decoders)
+		if err != nil {
+			log.Fatalf("-decoders: %v", err)
+		}
+		frames.SetDecoders(decoders)
+	}
+	if *d.dbc != "" {
+		loadDBC(*d.dbc)
+	}
+}
+
+// decodeLog runs every frame of a recorded log through the decoders, as they would be live,
+// returning the events in the order they were recorded and the number of frames
+func decodeLog(path string) ([]hub.SensorEvent, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	r, err := rawlog.NewReader(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	// Out of range values are left out, as they are from the dashboard
+	Quarantine, _ = quarantine.NewLog("")
+	eventHub := hub.NewHub()
+	_, ch, cancel := eventHub.SubscribeWith(hub.SubscribeOptions{Policy: hub.BLOCK, Timeout: time.Minute})
+	decoded := make(chan []hub.SensorEvent)
+	go func() {
+		var events []hub.SensorEvent
+		for event := range ch {
+			events = append(events, event)
+		}
+		decoded <- events
+	}()
+
+	reader, millis := frames.NewReader(r), timeline.New()
+	count := 0
+	for {
+		frame, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *frames.LineError
+		if errors.As(err, &lineErr) {
+			continue
+		}
+		if err != nil {
+			cancel()
+			return nil, 0, err
+		}
+		count++
+		frame.Millis = millis.Next(frame.Millis)
+		broadcastParsedSensorData(eventHub, uint64(frame.DID), rawCANID(input.Frame{Frame: frame}), frame.Data, frame.Millis, time.Now())
+	}
+	cancel()
+	return <-decoded, count, nil
+}
+
+// wideTable is a recording's numeric samples laid out a row per timestamp and a column per
+// channel. Dashboard channels come first, in card order.
+type wideTable struct {
+	channels []string
+	units    []string
+	samples  []hub.SensorEvent
+}
+
+func newWideTable(events []hub.SensorEvent, system units.System) *wideTable {
+	// Only numeric samples are exported, not undecoded DIDs' hex or status changes
+	t := &wideTable{}
+	unitsByChannel := map[string]string{}
+	for _, event := range events {
+		if _, ok := event.Float(); ok && event.HasTimestamp {
+			event.Value = units.Convert(system, event.Channel, event.Value)
+			t.samples = append(t.samples, event)
+			unitsByChannel[event.Channel] = units.Unit(system, event.Channel, event.Unit)
+		}
+	}
+
+	var rest []string
+	for _, card := range cards {
+		name := strings.ToLower(card.Name)
+		if _, ok := unitsByChannel[name]; ok {
+			t.channels = append(t.channels, name)
+		}
+	}
+	for channel := range unitsByChannel {
+		if !slices.Contains(t.channels, channel) {
+			rest = append(rest, channel)
+		}
+	}
+	slices.Sort(rest)
+	t.channels = append(t.channels, rest...)
+	for _, channel := range t.channels {
+		t.units = append(t.units, unitsByChannel[channel])
+	}
+	return t
+}
+
+// Rows calls fn for every timestamp with each channel's latest value, nil until a channel's
+// first sample. values is reused between calls.
+func (t *wideTable) Rows(fn func(timestamp int64, values []any) error) (int, error) {
+	column := map[string]int{}
+	for i, channel := range t.channels {
+		column[channel] = i
+	}
+	values := make([]any, len(t.channels))
+	rows := 0
+	for i, event := range t.samples {
+		values[column[event.Channel]] = event.Value
+		// Every channel updated at this timestamp goes in the same row
+		if i+1 < len(t.samples) && t.samples[i+1].Timestamp == event.Timestamp {
+			continue
+		}
+		if err := fn(event.Timestamp, values); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	return rows, nil
+}
+
+// writeWideCSV writes the table with a "name (unit)" header. It returns the rows written.
+func writeWideCSV(w io.Writer, table *wideTable) (int, error) {
+	writer := csv.NewWriter(w)
+	header := []string{"timestamp_ms"}
+	for i, channel := range table.channels {
+		if unit := table.units[i]; unit != "" {
+			header = append(header, fmt.Sprintf("%s (%s)", channel, unit))
+		} else {
+			header = append(header, channel)
+		}
+	}
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	row := make([]string, len(header))
+	rows, err := table.Rows(func(timestamp int64, values []any) error {
+		row[0] = strconv.FormatInt(timestamp, 10)
+		for i, value := range values {
+			if value != nil {
+				row[i+1] = fmt.Sprint(value)
+			}
+		}
+		return writer.Write(row)
+	})
+	if err != nil {
+		return rows, err
+	}
+	writer.Flush()
+	return rows, writer.Error()
+}
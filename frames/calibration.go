@@ -0,0 +1,47 @@
+package frames
+
+import "sync"
+
+// Calibration is a sensor's raw reading with the grip closed and fully open, learned by the
+// calibration wizard as the range differs from bike to bike
+type Calibration struct {
+	Closed int `json:"closed"`
+	Open   int `json:"open"`
+}
+
+// Percent scales a raw reading to 0..100% of travel. Sensors that read lower when open are
+// handled too.
+func (c Calibration) Percent(raw float64) int {
+	if c.Open < c.Closed {
+		return 100 - scalePct(int(raw), c.Open, c.Closed)
+	}
+	return scalePct(int(raw), c.Closed, c.Open)
+}
+
+var (
+	calibrationsMu sync.RWMutex
+	calibrations   = map[string]Calibration{}
+)
+
+// CalibratedChannel is the channel a calibrated channel's percentage is broadcast on, e.g. grip_pct
+func CalibratedChannel(channel string) string {
+	return channel + "_pct"
+}
+
+// Calibrated returns the calibration of a channel, if it's been calibrated
+func Calibrated(channel string) (Calibration, bool) {
+	calibrationsMu.RLock()
+	defer calibrationsMu.RUnlock()
+	c, ok := calibrations[channel]
+	return c, ok
+}
+
+// SetCalibrations replaces every channel's calibration
+func SetCalibrations(c map[string]Calibration) {
+	calibrationsMu.Lock()
+	defer calibrationsMu.Unlock()
+	calibrations = map[string]Calibration{}
+	for channel, cal := range c {
+		calibrations[channel] = cal
+	}
+}
@@ -0,0 +1,104 @@
+package frames
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Commands go the other way, from huskki to the logger, one per line:
+//
+//	$NAME[,arg...]*CRC
+//
+// where CRC is the CRC-16/CCITT-FALSE of everything between $ and *, as 4 hex digits. The
+// logger replies with the same framing, e.g. "$ACK,CFG*xxxx". Neither parses as a log row.
+type Command struct {
+	Name string
+	Args []string
+}
+
+const (
+	CONFIG_COMMAND = "CFG"
+	TEST_COMMAND   = "TEST"
+	// Read the ECU's trouble codes, replied to as "$DTC,XXXXXX:SS,..." (DTC and status, hex)
+	DTC_COMMAND = "DTC"
+	// Clear the ECU's trouble codes, acked once done
+	CLEAR_DTC_COMMAND = "CLR"
+	// Ask for the firmware's version, replied to as "$VER,x.y.z"
+	VERSION_COMMAND = "VER"
+	ACK_COMMAND     = "ACK"
+	NACK_COMMAND    = "NACK"
+
+	// DID of the rows sent in reply to a TEST command
+	TEST_DID = 0xFFFF
+)
+
+var ErrCommand = errors.New("invalid command, expected $NAME[,arg...]*CRC")
+
+// String encodes the command as a line, without the trailing newline
+func (c Command) String() string {
+	body := strings.Join(append([]string{c.Name}, c.Args...), ",")
+	return fmt.Sprintf("$%s*%04X", body, CRC16([]byte(body)))
+}
+
+// ParseCommand decodes a command or reply line, checking its CRC
+func ParseCommand(line string) (Command, error) {
+	line = strings.TrimSpace(line)
+	body, crc, ok := strings.Cut(strings.TrimPrefix(line, "$"), "*")
+	if !strings.HasPrefix(line, "$") || !ok || body == "" {
+		return Command{}, ErrCommand
+	}
+	want, err := strconv.ParseUint(crc, 16, 16)
+	if err != nil {
+		return Command{}, ErrCommand
+	}
+	if got := CRC16([]byte(body)); got != uint16(want) {
+		return Command{}, fmt.Errorf("command CRC mismatch: got %04X, expected %04X", got, want)
+	}
+	parts := strings.Split(body, ",")
+	return Command{Name: parts[0], Args: parts[1:]}, nil
+}
+
+// CRC16 is CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF)
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Sample is a DID the logger should read and how often
+type Sample struct {
+	DID        uint16 `json:"did"`
+	IntervalMs int    `json:"intervalMs"`
+}
+
+// ConfigCommand tells the logger exactly which DIDs to sample, e.g. "$CFG,0100:50,0009:1000*xxxx"
+func ConfigCommand(samples []Sample) Command {
+	cmd := Command{Name: CONFIG_COMMAND}
+	for _, s := range samples {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("%04X:%d", s.DID, s.IntervalMs))
+	}
+	return cmd
+}
+
+// TestCommand asks the logger to send count v2 rows of TestPattern on TEST_DID, sequence 0 up,
+// after acking, e.g. "$TEST,1000*xxxx"
+func TestCommand(count int) Command {
+	return Command{Name: TEST_COMMAND, Args: []string{strconv.Itoa(count)}}
+}
+
+// TestPattern is the data of test row seq: the sequence number, big endian, then bytes that
+// exercise every bit
+func TestPattern(seq uint16) []byte {
+	return []byte{byte(seq >> 8), byte(seq), 0x55, 0xAA, 0x00, 0xFF}
+}
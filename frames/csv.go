@@ -0,0 +1,57 @@
+package frames
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseCSV decodes a row of timestamp,DID,payload written by something other than the logger,
+// e.g. another tool's capture or a hand-crafted test vector. It's looser than Parse:
+//
+//   - the timestamp is millis, or seconds if it has a decimal point, e.g. 12.345
+//   - the DID is hex, with or without 0x, e.g. 0100 or 0x0100
+//   - the payload is hex, optionally separated by spaces, colons or dashes, e.g. 17:3F
+//
+// Fields can be quoted, and anything after the payload is ignored. A log's own rows parse the
+// same as with Parse.
+func ParseCSV(line string) (Frame, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord, r.TrimLeadingSpace = -1, true
+	fields, err := r.Read()
+	if err != nil || len(fields) < 3 {
+		return Frame{}, ErrFields
+	}
+
+	var millis int64
+	var micros int64
+	timestamp := strings.TrimSpace(fields[0])
+	if strings.Contains(timestamp, ".") {
+		seconds, err := strconv.ParseFloat(timestamp, 64)
+		if err != nil {
+			return Frame{}, ErrMillis
+		}
+		// Rounded, as e.g. 1.001 seconds is 1000.9999999999999 millis as a float
+		millis, micros = int64(math.Round(seconds*1000)), int64(math.Round(seconds*1e6))
+	} else if millis, err = strconv.ParseInt(timestamp, 10, 64); err != nil {
+		return Frame{}, ErrMillis
+	} else {
+		micros = millis * 1000
+	}
+
+	didStr := strings.TrimSpace(fields[1])
+	didStr = strings.TrimPrefix(strings.TrimPrefix(didStr, "0x"), "0X")
+	did, err := strconv.ParseUint(didStr, 16, 16)
+	if err != nil {
+		return Frame{}, ErrDID
+	}
+
+	clean := strings.NewReplacer(" ", "", ":", "", "-", "", "0x", "", "0X", "").Replace(fields[2])
+	data, err := hex.DecodeString(clean)
+	if err != nil || len(data) == 0 {
+		return Frame{}, ErrData
+	}
+	return Frame{Millis: millis, DID: uint16(did), Data: data, Micros: micros}, nil
+}
@@ -0,0 +1,238 @@
+package frames
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Decoder describes how a DID's payload turns into a channel value, see decoders.yaml
+type Decoder struct {
+	DID    uint16 `yaml:"did"`
+	Name   string `yaml:"name"`
+	Byte   int    `yaml:"byte"`
+	Length int    `yaml:"length"` // 0 for the rest of the payload
+	Endian string `yaml:"endian"` // big (default) or little
+	// Bits, if set, picks a bit field out of the bytes from Byte instead of Length whole
+	// bytes. Bit counts from the most significant bit for big endian, the least for little.
+	Bit    int     `yaml:"bit"`
+	Bits   int     `yaml:"bits"`
+	Signed bool    `yaml:"signed"`
+	Scale  float64 `yaml:"scale"` // 0 is taken as 1
+	Offset float64 `yaml:"offset"`
+	Unit   string  `yaml:"unit"`
+	// Values are broadcast as ints unless decimals is set
+	Decimals int `yaml:"decimals"`
+}
+
+// STOICHIOMETRIC_AFR is the air-fuel ratio of lambda 1 for petrol
+const STOICHIOMETRIC_AFR = 14.7
+
+// derivedDecimals are the decimals of channels computed from others rather than decoded
+var derivedDecimals = map[string]int{
+	"afr":           1,
+	"injector_duty": 1,
+}
+
+//go:embed decoders.yaml
+var defaultDecoders []byte
+
+// Decoders are used by Decode and Encode, the built in set unless added to with SetDecoders
+var Decoders = mustParseDecoders(defaultDecoders)
+
+// SetDecoders adds to or overrides the current decoders by DID, so a profile's decoders can be
+// layered on the built in ones and -decoders on top of both
+func SetDecoders(decoders []Decoder) {
+	merged := append([]Decoder{}, Decoders...)
+	for _, d := range decoders {
+		replaced := false
+		for i := range merged {
+			if merged[i].DID == d.DID {
+				merged[i], replaced = d, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, d)
+		}
+	}
+	Decoders = merged
+}
+
+// LoadDecoders reads a decoder list in the format of decoders.yaml
+func LoadDecoders(path string) ([]Decoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoders, err := ParseDecoders(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return decoders, nil
+}
+
+func ParseDecoders(data []byte) ([]Decoder, error) {
+	var decoders []Decoder
+	if err := yaml.Unmarshal(data, &decoders); err != nil {
+		return nil, err
+	}
+	if err := validateDecoders(decoders); err != nil {
+		return nil, err
+	}
+	return decoders, nil
+}
+
+func validateDecoders(decoders []Decoder) error {
+	for _, d := range decoders {
+		switch {
+		case d.Name == "":
+			return fmt.Errorf("decoder for DID 0x%04X has no name", d.DID)
+		case d.Byte < 0 || d.Length < 0 || d.Length > 8:
+			return fmt.Errorf("decoder %s: byte must be >= 0 and length 0..8", d.Name)
+		case d.Endian != "" && d.Endian != "big" && d.Endian != "little":
+			return fmt.Errorf("decoder %s: endian must be big or little", d.Name)
+		case d.Bits < 0 || d.Bit < 0 || (d.Bits > 0 && d.Bit+d.Bits > 64):
+			return fmt.Errorf("decoder %s: bit must be >= 0 and bit + bits 1..64", d.Name)
+		case d.Bits > 0 && d.Length != 0:
+			return fmt.Errorf("decoder %s: set length or bits, not both", d.Name)
+		case d.Bits == 0 && d.Bit != 0:
+			return fmt.Errorf("decoder %s: bit needs bits", d.Name)
+		}
+	}
+	return nil
+}
+
+func mustParseDecoders(data []byte) []Decoder {
+	decoders, err := ParseDecoders(data)
+	if err != nil {
+		panic(err)
+	}
+	return decoders
+}
+
+// Unit is the unit of a channel, if its decoder has one
+func Unit(channel string) string {
+	for _, d := range Decoders {
+		if d.Name == channel {
+			return d.Unit
+		}
+	}
+	return ""
+}
+
+// Round rounds a decoded value to its channel's decimals, whole numbers by default
+func Round(channel string, value float64) float64 {
+	pow := math.Pow10(decimals(channel))
+	return math.Round(value*pow) / pow
+}
+
+// Value is the rounded value as broadcast: an int, or a float64 for channels with decimals
+func Value(channel string, value float64) any {
+	if decimals(channel) > 0 {
+		return Round(channel, value)
+	}
+	return int(Round(channel, value))
+}
+
+func decimals(channel string) int {
+	for _, d := range Decoders {
+		if d.Name == channel {
+			return d.Decimals
+		}
+	}
+	return derivedDecimals[channel]
+}
+
+// RawChannel is the channel undecoded DIDs are broadcast on, as hex
+func RawChannel(did uint16) string {
+	return fmt.Sprintf("did_%04x", did)
+}
+
+func (d Decoder) scale() float64 {
+	if d.Scale == 0 {
+		return 1
+	}
+	return d.Scale
+}
+
+// bytes is how many bytes from Byte the value is read from, 0 for the rest of the payload
+func (d Decoder) bytes() int {
+	if d.Bits > 0 {
+		return (d.Bit + d.Bits + 7) / 8
+	}
+	return d.Length
+}
+
+// shift is how far the bit field sits from the least significant end of n bytes
+func (d Decoder) shift(n int) int {
+	if d.Endian == "little" {
+		return d.Bit
+	}
+	return 8*n - d.Bit - d.Bits
+}
+
+func (d Decoder) decode(data []byte) (float64, bool) {
+	end := d.Byte + d.bytes()
+	if d.bytes() == 0 {
+		end = min(len(data), d.Byte+8)
+	}
+	if end > len(data) || end <= d.Byte {
+		return 0, false
+	}
+	b := data[d.Byte:end]
+	var raw uint64
+	for i := range b {
+		if d.Endian == "little" {
+			raw |= uint64(b[i]) << (8 * i)
+		} else {
+			raw = raw<<8 | uint64(b[i])
+		}
+	}
+	bits := 8 * len(b)
+	if d.Bits > 0 {
+		raw = raw >> d.shift(len(b)) & mask(d.Bits)
+		bits = d.Bits
+	}
+	value := float64(raw)
+	if d.Signed && bits < 64 && raw&(1<<(bits-1)) != 0 {
+		value -= float64(uint64(1) << bits)
+	} else if d.Signed && bits == 64 {
+		value = float64(int64(raw))
+	}
+	return value*d.scale() + d.Offset, true
+}
+
+func mask(bits int) uint64 {
+	if bits >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<bits - 1
+}
+
+func (d Decoder) encode(value float64) []byte {
+	n := cmp.Or(d.bytes(), 2)
+	bits := cmp.Or(d.Bits, 8*n)
+	raw := math.Round((value - d.Offset) / d.scale())
+	lo, hi := 0.0, math.Pow(2, float64(bits))-1
+	if d.Signed {
+		lo, hi = -math.Pow(2, float64(bits-1)), math.Pow(2, float64(bits-1))-1
+	}
+	v := uint64(int64(max(lo, min(raw, hi))))
+	if d.Bits > 0 {
+		v = (v & mask(d.Bits)) << d.shift(n)
+	}
+
+	data := make([]byte, d.Byte+n)
+	for i := range n {
+		shift := 8 * (n - 1 - i)
+		if d.Endian == "little" {
+			shift = 8 * i
+		}
+		data[d.Byte+i] = byte(v >> shift)
+	}
+	return data
+}
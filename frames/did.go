@@ -0,0 +1,51 @@
+package frames
+
+import "math"
+
+const (
+	RPM_DID      = 0x0100
+	THROTTLE_DID = 0x0001
+	GRIP_DID     = 0x0070
+	TPS_DID      = 0x0076
+	COOLANT_DID  = 0x0009
+
+	// MARKER_DID isn't sent by the ECU, huskki logs markers added during a ride as frames of it
+	// with their label as the data, so replays show them where they were added
+	MARKER_DID = 0xFFFE
+)
+
+// Decode turns a DID payload into its channel name and value using Decoders, see Value for
+// rounding it. ok is false for unknown DIDs or payloads that are too short.
+func Decode(did uint16, data []byte) (channel string, value float64, ok bool) {
+	for _, d := range Decoders {
+		if d.DID == did {
+			value, ok := d.decode(data)
+			return d.Name, value, ok
+		}
+	}
+	return "", 0, false
+}
+
+// Encode is the inverse of Decode, turning a channel value back into the DID payload the logger
+// would have sent
+func Encode(channel string, value float64) (uint16, []byte, bool) {
+	for _, d := range Decoders {
+		if d.Name == channel {
+			return d.DID, d.encode(value), true
+		}
+	}
+	return 0, nil, false
+}
+
+func scalePct(raw, min, max int) int {
+	if max <= min {
+		return 0
+	}
+	if raw < min {
+		raw = min
+	}
+	if raw > max {
+		raw = max
+	}
+	return int(math.Round(float64(raw-min) * 100.0 / float64(max-min)))
+}
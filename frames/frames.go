@@ -0,0 +1,218 @@
+// Package frames is the logger's wire format: CSV rows of millis,DID,data_hex[,u16be], e.g.
+// "221,0x0100,00 00", or v2 rows (see V2) with microsecond timestamps, the CAN ID and a CRC.
+// Both versions can be mixed in one log, and lines starting with # are comments, e.g. the
+// metadata at the top of a recorded ride. It's importable so firmware and external tools can
+// produce and validate logs huskki will accept.
+package frames
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Frame is a single DID reading as logged
+type Frame struct {
+	Millis int64 // logger millis, as logged without any rollover handling
+	DID    uint16
+	Data   []byte
+
+	// Only carried by v2 rows, otherwise Micros is Millis*1000 and CANID is 0
+	Micros int64
+	CANID  uint32
+}
+
+const (
+	V2_PREFIX      = "v2,"
+	COMMENT_PREFIX = "#"
+)
+
+// String encodes the frame as a v1 log row, without the trailing newline
+func (f Frame) String() string {
+	return fmt.Sprintf("%d,0x%04X,% X", f.Millis, f.DID, f.Data)
+}
+
+// V2 encodes the frame as a v2 log row, without the trailing newline:
+//
+//	v2,micros,canID,DID,data_hex*CRC
+//
+// e.g. "v2,221000,7E8,0x0100,00 00*xxxx". The CAN ID is hex, up to 29 bits, and CRC is the
+// CRC-16/CCITT-FALSE of everything before the *, as for commands.
+func (f Frame) V2() string {
+	micros := f.Micros
+	if micros == 0 {
+		micros = f.Millis * 1000
+	}
+	body := fmt.Sprintf("%s%d,%X,0x%04X,% X", V2_PREFIX, micros, f.CANID, f.DID, f.Data)
+	return fmt.Sprintf("%s*%04X", body, CRC16([]byte(body)))
+}
+
+var (
+	ErrFields = errors.New("expected millis,DID,data")
+	ErrMillis = errors.New("invalid millis")
+	ErrDID    = errors.New("invalid DID, expected 0xNNNN")
+	ErrData   = errors.New("invalid data, expected hex bytes")
+	ErrCANID  = errors.New("invalid CAN ID, expected up to 29 bits of hex")
+	ErrCRC    = errors.New("missing or mismatched CRC")
+)
+
+// Parse decodes a single log row, of either version
+func Parse(line string) (Frame, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, V2_PREFIX) {
+		return parseV2(line)
+	}
+	parts := strings.SplitN(line, ",", 4)
+	if len(parts) < 3 {
+		return Frame{}, ErrFields
+	}
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Frame{}, ErrMillis
+	}
+	did, data, err := parseDIDData(parts[1], parts[2])
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Millis: millis, DID: did, Data: data, Micros: millis * 1000}, nil
+}
+
+func parseV2(line string) (Frame, error) {
+	body, crc, ok := strings.Cut(line, "*")
+	if !ok {
+		return Frame{}, ErrCRC
+	}
+	want, err := strconv.ParseUint(crc, 16, 16)
+	if err != nil || CRC16([]byte(body)) != uint16(want) {
+		return Frame{}, ErrCRC
+	}
+	parts := strings.Split(strings.TrimPrefix(body, V2_PREFIX), ",")
+	if len(parts) != 4 {
+		return Frame{}, ErrFields
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Frame{}, ErrMillis
+	}
+	canID, err := strconv.ParseUint(parts[1], 16, 29)
+	if err != nil {
+		return Frame{}, ErrCANID
+	}
+	did, data, err := parseDIDData(parts[2], parts[3])
+	if err != nil {
+		return Frame{}, err
+	}
+	return Frame{Millis: micros / 1000, DID: did, Data: data, Micros: micros, CANID: uint32(canID)}, nil
+}
+
+func parseDIDData(didStr, dataStr string) (uint16, []byte, error) {
+	if !strings.HasPrefix(didStr, "0x") {
+		return 0, nil, ErrDID
+	}
+	did, err := strconv.ParseUint(didStr[2:], 16, 16)
+	if err != nil {
+		return 0, nil, ErrDID
+	}
+	clean := strings.ReplaceAll(dataStr, " ", "")
+	if len(clean)%2 == 1 {
+		return 0, nil, ErrData
+	}
+	data, err := hex.DecodeString(clean)
+	if err != nil || len(data) == 0 {
+		return 0, nil, ErrData
+	}
+	return uint16(did), data, nil
+}
+
+// LineError is a row that failed to parse
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+func (e *LineError) Unwrap() error { return e.Err }
+
+// Reader reads frames from a log. Rows that don't parse are returned as a *LineError, after
+// which reading can continue; blank lines and comments are skipped.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Read returns the next frame, or io.EOF at the end of the log
+func (r *Reader) Read() (Frame, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" || strings.HasPrefix(text, COMMENT_PREFIX) {
+			continue
+		}
+		frame, err := Parse(text)
+		if err != nil {
+			return Frame{}, &LineError{Line: r.line, Text: text, Err: err}
+		}
+		return frame, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Frame{}, err
+	}
+	return Frame{}, io.EOF
+}
+
+// Writer writes frames as log rows
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(f Frame) error {
+	_, err := fmt.Fprintln(w.w, f.String())
+	return err
+}
+
+// Flush writes any buffered rows to the underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Validate reads a whole log, returning the number of valid frames and every row that didn't parse
+func Validate(r io.Reader) (int, []*LineError, error) {
+	reader := NewReader(r)
+	var (
+		valid   int
+		invalid []*LineError
+	)
+	for {
+		_, err := reader.Read()
+		var lineErr *LineError
+		switch {
+		case err == nil:
+			valid++
+		case errors.As(err, &lineErr):
+			invalid = append(invalid, lineErr)
+		case errors.Is(err, io.EOF):
+			return valid, invalid, nil
+		default:
+			return valid, invalid, err
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// -update rewrites the golden files from the fixtures, after a deliberate change to the encoding
+var update = flag.Bool("update", false, "rewrite testdata/*.golden")
+
+// The fixtures are the start of rides from logs/: DIDLOG11 in the logger's old format with
+// trailing u16be and label columns, DIDLOG13 as it logs now
+var fixtures = []string{"didlog11", "didlog13"}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name+".csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readFrames reads a log's frames, and the rows that didn't parse, checking Validate agrees
+func readFrames(t *testing.T, data []byte) ([]Frame, []*LineError) {
+	t.Helper()
+	var (
+		frames  []Frame
+		invalid []*LineError
+	)
+	reader := NewReader(bytes.NewReader(data))
+	for {
+		frame, err := reader.Read()
+		var lineErr *LineError
+		switch {
+		case err == nil:
+			frames = append(frames, frame)
+			continue
+		case errors.As(err, &lineErr):
+			invalid = append(invalid, lineErr)
+			continue
+		case !errors.Is(err, io.EOF):
+			t.Fatal(err)
+		}
+		break
+	}
+
+	valid, rejected, err := Validate(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid != len(frames) || !reflect.DeepEqual(rejected, invalid) {
+		t.Fatalf("Validate counted %d valid and %d invalid rows, read %d and %d", valid, len(rejected), len(frames), len(invalid))
+	}
+	return frames, invalid
+}
+
+func writeFrames(t *testing.T, frames []Frame, encode func(Frame) string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		buf.WriteString(encode(frame) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// TestGolden checks the fixtures encode to their golden v1 rows, and that those parse back to
+// the same frames. Real logs have the odd row split across lines, which must be rejected
+// rather than misread.
+func TestGolden(t *testing.T) {
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			frames, invalid := readFrames(t, readFixture(t, name))
+			// The rows that were rejected lead the golden file as comments, which are skipped
+			// when it's read back
+			var buf bytes.Buffer
+			for _, e := range invalid {
+				buf.WriteString(COMMENT_PREFIX + " " + e.Error() + "\n")
+			}
+			w := NewWriter(&buf)
+			for _, frame := range frames {
+				if err := w.Write(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("encoded rows differ from %s, run with -update if that's intended", golden)
+			}
+			if got, _ := readFrames(t, want); !reflect.DeepEqual(got, frames) {
+				t.Errorf("%s doesn't parse back to the fixture's frames", golden)
+			}
+		})
+	}
+}
+
+// TestCurrentFormat checks every valid row of a log in the logger's current format encodes
+// back to itself
+func TestCurrentFormat(t *testing.T) {
+	data := readFixture(t, "didlog13")
+	frames, _ := readFrames(t, data)
+	var want bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if _, err := Parse(line); err == nil {
+			want.WriteString(line)
+		}
+	}
+	if got := writeFrames(t, frames, Frame.String); !bytes.Equal(got, want.Bytes()) {
+		t.Error("didlog13 didn't encode back to itself")
+	}
+}
+
+func TestV2RoundTrip(t *testing.T) {
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			frames, _ := readFrames(t, readFixture(t, name))
+			for i := range frames {
+				frames[i].CANID = 0x7E8
+			}
+			if got, _ := readFrames(t, writeFrames(t, frames, Frame.V2)); !reflect.DeepEqual(got, frames) {
+				t.Error("v2 rows don't parse back to the same frames")
+			}
+		})
+	}
+}
+
+// TestDecodeRoundTrip checks every decoded reading in the fixtures encodes back to a payload
+// that decodes to the same value
+func TestDecodeRoundTrip(t *testing.T) {
+	for _, name := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			frames, _ := readFrames(t, readFixture(t, name))
+			for _, frame := range frames {
+				channel, value, ok := Decode(frame.DID, frame.Data)
+				if !ok {
+					continue
+				}
+				did, data, ok := Encode(channel, value)
+				if !ok || did != frame.DID {
+					t.Fatalf("%s: encoded to 0x%04X, %v", channel, did, ok)
+				}
+				if _, got, _ := Decode(did, data); got != value {
+					t.Errorf("%s: % X decodes to %v, re-encoded as % X decodes to %v", channel, frame.Data, value, data, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	v2 := Frame{Millis: 221, DID: RPM_DID, Data: []byte{0, 0}, CANID: 0x7E8}.V2()
+	for _, tt := range []struct {
+		line string
+		want error
+	}{
+		{"221,0x0100", ErrFields},
+		{"x,0x0100,00 00", ErrMillis},
+		{"221,0100,00 00", ErrDID},
+		{"221,0x0100,0", ErrData},
+		{"221,0x0100,zz", ErrData},
+		{strings.Replace(v2, "0x0100", "0x0101", 1), ErrCRC},
+		{strings.Split(v2, "*")[0], ErrCRC},
+	} {
+		if _, err := Parse(tt.line); !errors.Is(err, tt.want) {
+			t.Errorf("Parse(%q) = %v, want %v", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsBadRows(t *testing.T) {
+	log := "# ride\n221,0x0100,00 00\nnot a row\n\n226,0x0009,00 87\n"
+	valid, invalid, err := Validate(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid != 2 || len(invalid) != 1 || invalid[0].Line != 3 {
+		t.Errorf("got %d valid, %v invalid", valid, invalid)
+	}
+}
+
+func TestParseCSVSeconds(t *testing.T) {
+	for _, tt := range []struct {
+		line   string
+		millis int64
+		micros int64
+	}{
+		{"1.001,0100,17 3F", 1001, 1001000},
+		{"12.345,0x0100,17:3F", 12345, 12345000},
+		{"1.0005,0100,173F", 1001, 1000500},
+		{"221,0x0100,17 3F", 221, 221000},
+		{"4294967295,0x0100,17 3F", 4294967295, 4294967295000},
+	} {
+		frame, err := ParseCSV(tt.line)
+		if err != nil {
+			t.Fatalf("ParseCSV(%q): %v", tt.line, err)
+		}
+		if frame.Millis != tt.millis || frame.Micros != tt.micros {
+			t.Errorf("ParseCSV(%q) at %dms, %dµs, want %dms, %dµs", tt.line, frame.Millis, frame.Micros, tt.millis, tt.micros)
+		}
+	}
+}
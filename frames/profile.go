@@ -0,0 +1,70 @@
+package frames
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile bundles the decoders and plausible ranges for one bike. Decoders are added to or
+// override the built in ones by DID, and ranges override the defaults by channel.
+type Profile struct {
+	Name        string                `yaml:"-"`
+	Description string                `yaml:"description"`
+	Decoders    []Decoder             `yaml:"decoders"`
+	Ranges      map[string][2]float64 `yaml:"ranges"`
+}
+
+//go:embed profiles/*.yaml
+var profiles embed.FS
+
+// Profiles lists the names of the built in profiles
+func Profiles() []string {
+	entries, err := profiles.ReadDir("profiles")
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile loads a built in profile by name, or a profile file in the same format by path
+func LoadProfile(name string) (Profile, error) {
+	data, err := profiles.ReadFile(path.Join("profiles", name+".yaml"))
+	if err != nil {
+		if data, err = os.ReadFile(name); err != nil {
+			return Profile{}, fmt.Errorf("unknown profile %q, expected one of %s or a file", name, strings.Join(Profiles(), ", "))
+		}
+	}
+	profile, err := ParseProfile(data)
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %s: %w", name, err)
+	}
+	profile.Name = strings.TrimSuffix(path.Base(name), ".yaml")
+	return profile, nil
+}
+
+func ParseProfile(data []byte) (Profile, error) {
+	var profile Profile
+	if err := yaml.Unmarshal(data, &profile); err != nil {
+		return Profile{}, err
+	}
+	if err := validateDecoders(profile.Decoders); err != nil {
+		return Profile{}, err
+	}
+	for channel, r := range profile.Ranges {
+		if r[0] > r[1] {
+			return Profile{}, fmt.Errorf("range of %s: min is above max", channel)
+		}
+	}
+	return profile, nil
+}
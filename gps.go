@@ -0,0 +1,75 @@
+package main
+
+import (
+	"huskki/gps"
+	"huskki/hub"
+	"huskki/input"
+	"log"
+	"math"
+	"time"
+)
+
+const GPS_SOURCE = "gps"
+
+// Cards added to the dashboard when a GPS is configured
+var gpsCards = []cardProps{
+	{"Groundspeed", 0, "km/h"},
+	{"Heading", 0, "°"},
+	{"Lat", 0, "°"},
+	{"Lon", 0, "°"},
+}
+
+// runGPS reads fixes from a receiver, reconnecting with backoff, and broadcasts them onto the
+// logger's timeline alongside the ECU channels
+func runGPS(addr string, baud int, eventHub *hub.EventHub) {
+	backoff := input.MIN_RECONNECT_BACKOFF
+	for {
+		conn, err := gps.Open(addr, baud)
+		if err != nil {
+			log.Printf("gps unavailable, retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, input.MAX_RECONNECT_BACKOFF)
+			continue
+		}
+		log.Printf("Connected to GPS %s", addr)
+		backoff = input.MIN_RECONNECT_BACKOFF
+
+		reader := gps.NewReader(conn)
+		for {
+			fix, err := reader.Read()
+			if err != nil {
+				log.Printf("gps lost: %v", err)
+				break
+			}
+			broadcastFix(eventHub, fix, time.Now())
+		}
+		conn.Close()
+		time.Sleep(backoff)
+	}
+}
+
+func broadcastFix(eventHub *hub.EventHub, fix gps.Fix, received time.Time) {
+	if !fix.Time.IsZero() {
+		Clock.set(fix.Time, received, GPS_SOURCE)
+	}
+	timestamp := LoggerClock.Now(received)
+	broadcast := func(channel string, value any, unit string) {
+		event := hub.Sample(channel, value, unit, timestamp, received)
+		event.Source = GPS_SOURCE
+		eventHub.Broadcast(event)
+	}
+	broadcast("lat", gps.Round(fix.Lat), "°")
+	broadcast("lon", gps.Round(fix.Lon), "°")
+	if fix.SpeedKmh != nil {
+		broadcast("groundspeed", int(math.Round(*fix.SpeedKmh)), "km/h")
+	}
+	if fix.Heading != nil {
+		broadcast("heading", int(math.Round(*fix.Heading)), "°")
+	}
+	if fix.Satellites != nil {
+		broadcast("satellites", *fix.Satellites, "")
+	}
+	if fix.AltitudeM != nil {
+		broadcast("altitude", int(math.Round(*fix.AltitudeM)), "m")
+	}
+}
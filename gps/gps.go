@@ -0,0 +1,169 @@
+// Package gps reads position from a GPS receiver speaking NMEA 0183, over serial or TCP.
+package gps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	DEFAULT_BAUD_RATE = 9600
+	KNOTS_TO_KMH      = 1.852
+)
+
+// Fix is what a sentence tells us. Only the fields the sentence type carries are set:
+// GGA has position, satellites and altitude, RMC has position, speed, heading and the time.
+type Fix struct {
+	Type       string
+	Lat, Lon   float64
+	SpeedKmh   *float64
+	Heading    *float64
+	Satellites *int
+	AltitudeM  *float64
+	// Time is the UTC time of the fix, zero unless the sentence has both the date and time
+	Time time.Time
+}
+
+// Open connects to a receiver: tcp://host:port for a network GPS, otherwise a serial device
+func Open(addr string, baud int) (io.ReadCloser, error) {
+	if host, ok := strings.CutPrefix(addr, "tcp://"); ok {
+		return net.Dial("tcp", host)
+	}
+	return serial.Open(addr, &serial.Mode{BaudRate: baud})
+}
+
+// Reader returns fixes from a stream of sentences, skipping anything unsupported, without a
+// fix, or failing its checksum
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+func (r *Reader) Read() (Fix, error) {
+	for r.scanner.Scan() {
+		if fix, ok := Parse(r.scanner.Text()); ok {
+			return fix, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Fix{}, err
+	}
+	return Fix{}, io.EOF
+}
+
+// Parse decodes a GGA or RMC sentence from any talker (GP, GN, GL...), e.g.
+// "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+func Parse(sentence string) (Fix, bool) {
+	sentence = strings.TrimSpace(sentence)
+	body, checksum, ok := strings.Cut(strings.TrimPrefix(sentence, "$"), "*")
+	if !strings.HasPrefix(sentence, "$") || !ok || !validChecksum(body, checksum) {
+		return Fix{}, false
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return Fix{}, false
+	}
+
+	switch fields[0][2:] {
+	case "GGA":
+		// time, lat, N/S, lon, E/W, quality, satellites, hdop, altitude, M, ...
+		if len(fields) < 10 || fields[6] == "" || fields[6] == "0" {
+			return Fix{}, false
+		}
+		lat, lon, ok := position(fields[2], fields[3], fields[4], fields[5])
+		if !ok {
+			return Fix{}, false
+		}
+		fix := Fix{Type: "GGA", Lat: lat, Lon: lon}
+		if sats, err := strconv.Atoi(fields[7]); err == nil {
+			fix.Satellites = &sats
+		}
+		if alt, err := strconv.ParseFloat(fields[9], 64); err == nil {
+			fix.AltitudeM = &alt
+		}
+		return fix, true
+
+	case "RMC":
+		// time, status, lat, N/S, lon, E/W, speed (knots), course, date, ...
+		if len(fields) < 10 || fields[2] != "A" {
+			return Fix{}, false
+		}
+		lat, lon, ok := position(fields[3], fields[4], fields[5], fields[6])
+		if !ok {
+			return Fix{}, false
+		}
+		fix := Fix{Type: "RMC", Lat: lat, Lon: lon}
+		if knots, err := strconv.ParseFloat(fields[7], 64); err == nil {
+			kmh := knots * KNOTS_TO_KMH
+			fix.SpeedKmh = &kmh
+		}
+		if course, err := strconv.ParseFloat(fields[8], 64); err == nil {
+			fix.Heading = &course
+		}
+		// ddmmyy and hhmmss, the seconds maybe fractional
+		if t, err := time.Parse("020106150405", fields[9]+fields[1]); err == nil {
+			fix.Time = t
+		}
+		return fix, true
+	}
+	return Fix{}, false
+}
+
+// position converts NMEA ddmm.mmmm/dddmm.mmmm coordinates to signed decimal degrees
+func position(lat, ns, lon, ew string) (float64, float64, bool) {
+	la, err1 := degrees(lat, 2)
+	lo, err2 := degrees(lon, 3)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	if ns == "S" {
+		la = -la
+	}
+	if ew == "W" {
+		lo = -lo
+	}
+	return la, lo, true
+}
+
+func degrees(value string, degreeDigits int) (float64, error) {
+	if len(value) < degreeDigits+2 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	deg, err := strconv.Atoi(value[:degreeDigits])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseFloat(value[degreeDigits:], 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(deg) + minutes/60, nil
+}
+
+func validChecksum(body, checksum string) bool {
+	want, err := strconv.ParseUint(checksum, 16, 8)
+	if err != nil {
+		return false
+	}
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return sum == byte(want)
+}
+
+// Round trims a coordinate to about 10cm, more than a consumer GPS can resolve
+func Round(deg float64) float64 {
+	return math.Round(deg*1e6) / 1e6
+}
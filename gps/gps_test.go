@@ -0,0 +1,26 @@
+package gps
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	for _, tt := range []struct {
+		sentence string
+		want     time.Time
+	}{
+		{"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A", time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)},
+		{"$GPRMC,123519.50,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*41", time.Date(1994, 3, 23, 12, 35, 19, 5e8, time.UTC)},
+		// GGA has the time but not the date
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", time.Time{}},
+	} {
+		fix, ok := Parse(tt.sentence)
+		if !ok {
+			t.Fatalf("Parse(%q) failed", tt.sentence)
+		}
+		if !fix.Time.Equal(tt.want) {
+			t.Errorf("Parse(%q) at %s, want %s", tt.sentence, fix.Time, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"huskki/sink"
+	"huskki/units"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_HISTORY_WINDOW = 10 * time.Minute
+	DEFAULT_HISTORY_STEP   = time.Second
+	// MAX_HISTORY_POINTS keeps a query from asking for more points than it's worth charting
+	MAX_HISTORY_POINTS = 10000
+)
+
+type historyPoint struct {
+	Time  time.Time `json:"time"`
+	Value any       `json:"value"`
+	Min   any       `json:"min"`
+	Max   any       `json:"max"`
+}
+
+type historyResponse struct {
+	Channel string         `json:"channel"`
+	Unit    string         `json:"unit,omitempty"`
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Step    string         `json:"step"`
+	Source  string         `json:"source"`
+	Points  []historyPoint `json:"points"`
+}
+
+// HistoryHandler returns a channel's values over a window of time, averaged into steps, e.g.
+// GET /api/history?channel=rpm&from=1h&step=10s for the last hour. from and to are RFC 3339
+// times, unix millis or how long ago, defaulting to the last DEFAULT_HISTORY_WINDOW. Points
+// come from the -sqlite database if there is one, otherwise only what the hub retains
+// (-history) is available.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channel := strings.ToLower(strings.TrimSpace(query.Get("channel")))
+	if channel == "" {
+		http.Error(w, "expected ?channel=", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	to, err := parseHistoryTime(query.Get("to"), now, now)
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseHistoryTime(query.Get("from"), to.Add(-DEFAULT_HISTORY_WINDOW), now)
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	step := DEFAULT_HISTORY_STEP
+	if s := query.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step < time.Millisecond {
+			http.Error(w, "step: expected a duration of at least 1ms, e.g. 10s", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from)/step > MAX_HISTORY_POINTS {
+		http.Error(w, fmt.Sprintf("more than %d points, use a longer step", MAX_HISTORY_POINTS), http.StatusBadRequest)
+		return
+	}
+
+	response := historyResponse{Channel: channel, From: from, To: to, Step: step.String(), Points: []historyPoint{}}
+	var buckets []sink.Bucket
+	if Database != nil {
+		response.Source = "sqlite"
+		if buckets, err = Database.Aggregate(channel, from, to, step); err != nil {
+			fmt.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		response.Source = "memory"
+		buckets = retainedBuckets(channel, from, to, step)
+	}
+
+	system := resolveUnits(w, r)
+	response.Unit = units.Unit(system, channel, frames.Unit(channel))
+	for _, b := range buckets {
+		response.Points = append(response.Points, historyPoint{
+			Time:  b.Time,
+			Value: units.Convert(system, channel, frames.Round(channel, b.Mean)),
+			Min:   units.Convert(system, channel, b.Min),
+			Max:   units.Convert(system, channel, b.Max),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// parseHistoryTime parses an RFC 3339 time, unix millis or a duration before now, returning
+// fallback if value is empty
+func parseHistoryTime(value string, fallback, now time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	if ago, err := time.ParseDuration(strings.TrimPrefix(value, "-")); err == nil {
+		return now.Add(-ago), nil
+	}
+	return time.Time{}, fmt.Errorf("expected an RFC 3339 time, unix millis or how long ago, e.g. 1h, not %q", value)
+}
+
+// retainedBuckets downsamples the hub's retained history of a channel as the SQLite database
+// would its samples, by when they were received
+func retainedBuckets(channel string, from, to time.Time, step time.Duration) []sink.Bucket {
+	var buckets []sink.Bucket
+	for _, event := range EventHub.History(-1) {
+		value, ok := event.Float()
+		if event.Channel != channel || !ok || event.Received.Before(from) || event.Received.After(to) {
+			continue
+		}
+		start := from.Add(event.Received.Sub(from) / step * step)
+		n := len(buckets)
+		if n == 0 || !buckets[n-1].Time.Equal(start) {
+			buckets = append(buckets, sink.Bucket{Time: start, Min: value, Max: value})
+			n++
+		}
+		b := &buckets[n-1]
+		b.Mean += (value - b.Mean) / float64(b.Count+1)
+		b.Min, b.Max, b.Count = min(b.Min, value), max(b.Max, value), b.Count+1
+	}
+	return buckets
+}
@@ -0,0 +1,121 @@
+package hub
+
+import "time"
+
+// Keys of the metadata in a SensorEvent's Map, alongside its channel
+const (
+	UNIT      = "unit"
+	TIMESTAMP = "timestamp"
+	RECEIVED  = "received"
+	SOURCE    = "source"
+)
+
+// EventHub is the hub of sensor events the dashboard, sinks and watchers share
+type EventHub = Hub[SensorEvent]
+
+func NewHub() *EventHub {
+	return New[SensorEvent]()
+}
+
+// SensorEvent is an update to one channel
+type SensorEvent struct {
+	Channel string
+	// Value is an int, or float64 for channels with decimals, for decoded values. Undecoded
+	// DIDs carry their payload as a hex string and status channels (e.g. link) a bool.
+	Value any
+	Unit  string
+	// Timestamp is the logger's millis for the frame, if HasTimestamp. Status changes aren't on
+	// the logger's timeline and have none.
+	Timestamp    int64
+	HasTimestamp bool
+	// Received is the wall-clock time the frame was received at, used for latency measurement
+	Received time.Time
+	// Source names the input for events that didn't come from the ECU, e.g. "gps"
+	Source string
+}
+
+// Sample is a sensor value at the logger's millis
+func Sample(channel string, value any, unit string, timestamp int64, received time.Time) SensorEvent {
+	return SensorEvent{Channel: channel, Value: value, Unit: unit, Timestamp: timestamp, HasTimestamp: true, Received: received}
+}
+
+// Status is a change of state that isn't on the logger's timeline, e.g. the link going down
+func Status(channel string, value any) SensorEvent {
+	return SensorEvent{Channel: channel, Value: value}
+}
+
+// Int returns the value of an event carrying an int
+func (e SensorEvent) Int() (int, bool) {
+	v, ok := e.Value.(int)
+	return v, ok
+}
+
+// Float returns the value of an event carrying a number, int or float64
+func (e SensorEvent) Float() (float64, bool) {
+	switch v := e.Value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (e SensorEvent) Topic() string {
+	return e.Channel
+}
+
+func (e SensorEvent) Time() (int64, bool) {
+	return e.Timestamp, e.HasTimestamp
+}
+
+// Retained drops Received, the snapshot sent to new subscribers isn't received now so
+// shouldn't claim to be
+func (e SensorEvent) Retained() SensorEvent {
+	e.Received = time.Time{}
+	return e
+}
+
+// Map is the event as an untyped map of its channel to its value, plus any metadata under
+// UNIT, TIMESTAMP, RECEIVED and SOURCE, for code that handles events generically
+func (e SensorEvent) Map() map[string]any {
+	m := map[string]any{e.Channel: e.Value}
+	if e.Unit != "" {
+		m[UNIT] = e.Unit
+	}
+	if e.HasTimestamp {
+		m[TIMESTAMP] = e.Timestamp
+	}
+	if !e.Received.IsZero() {
+		m[RECEIVED] = e.Received
+	}
+	if e.Source != "" {
+		m[SOURCE] = e.Source
+	}
+	return m
+}
+
+// FromMap splits an untyped map of channels to values, with optional metadata as in Map, into
+// an event per channel. The timestamp may be any integer, or a float64 as decoded from JSON.
+func FromMap(m map[string]any) []SensorEvent {
+	var events []SensorEvent
+	for channel, value := range m {
+		if channel == UNIT || channel == TIMESTAMP || channel == RECEIVED || channel == SOURCE {
+			continue
+		}
+		event := SensorEvent{Channel: channel, Value: value}
+		switch ts := m[TIMESTAMP].(type) {
+		case int64:
+			event.Timestamp, event.HasTimestamp = ts, true
+		case int:
+			event.Timestamp, event.HasTimestamp = int64(ts), true
+		case float64:
+			event.Timestamp, event.HasTimestamp = int64(ts), true
+		}
+		event.Unit, _ = m[UNIT].(string)
+		event.Received, _ = m[RECEIVED].(time.Time)
+		event.Source, _ = m[SOURCE].(string)
+		events = append(events, event)
+	}
+	return events
+}
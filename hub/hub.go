@@ -1,57 +1,476 @@
+// Package hub fans out timestamped payloads, by default the logger's SensorEvents, to
+// subscribers, keeping the latest of each topic and a short history to backfill from.
 package hub
 
-import "sync"
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
 
-type EventHub struct {
-	mu   sync.Mutex
-	subs map[int]chan map[string]any
-	next int
-	last map[string]any
+const (
+	// DEFAULT_RETENTION is how much of each channel's history is kept, in logger time, to fill
+	// in the charts of a newly opened or reconnecting dashboard
+	DEFAULT_RETENTION = 60 * time.Second
+	// HISTORY_SIZE bounds how many events are kept per channel, whatever the retention
+	HISTORY_SIZE = 20000
+	// SUBSCRIBER_BUFFER is how many events a subscriber can fall behind by before they're dropped
+	SUBSCRIBER_BUFFER = 64
+	// DEFAULT_BLOCK_TIMEOUT is how long a BLOCK subscriber can hold up a broadcast for
+	DEFAULT_BLOCK_TIMEOUT = time.Second
+)
+
+// Policy is what happens to an event when a subscriber's buffer is full
+type Policy int
+
+const (
+	// DROP_NEWEST drops the event being broadcast, so the subscriber sees a gap after the
+	// events it was behind on
+	DROP_NEWEST Policy = iota
+	// DROP_OLDEST drops the oldest event in the buffer to make room, so the subscriber
+	// catches up on the latest
+	DROP_OLDEST
+	// BLOCK holds up the broadcast, and the next, until there's room or the timeout passes.
+	// Other subscribers are delivered to first, and the hub can still be read while it waits.
+	// A BLOCK subscriber mustn't broadcast from its receive loop.
+	BLOCK
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DROP_OLDEST:
+		return "drop-oldest"
+	case BLOCK:
+		return "block"
+	}
+	return "drop-newest"
+}
+
+// SubscribeOptions sets how a subscriber copes with falling behind
+type SubscribeOptions struct {
+	// Buffer is how many events it can fall behind by, SUBSCRIBER_BUFFER if 0
+	Buffer int
+	Policy Policy
+	// Timeout is how long BLOCK waits for room, DEFAULT_BLOCK_TIMEOUT if 0
+	Timeout time.Duration
+	// MaxRate, if set, limits the subscriber to this many events per second of each topic, with
+	// bursts of up to a second's worth, so a busy topic can't starve the others. Events without
+	// a timestamp, e.g. status changes, aren't limited.
+	MaxRate float64
+}
+
+// Payload is what a Hub carries. Payloads are routed to subscribers, and the latest of each
+// kept, by topic. Those with a timestamp are also kept as history.
+type Payload interface {
+	Topic() string
+	// Time is the payload's timestamp in ms, if it has one
+	Time() (int64, bool)
+}
+
+// Retainer is implemented by payloads that need changing before they're kept as the latest of
+// their topic, e.g. to drop fields that only mean something at the time of the broadcast
+type Retainer[T any] interface {
+	Retained() T
+}
+
+// ChannelStatus describes how recently and how often a channel has been updated
+type ChannelStatus struct {
+	LastUpdate time.Time
+	Age        time.Duration
+	Rate       float64       // updates per second
+	Interval   time.Duration // moving average of time between updates, 0 until there have been two
+}
+
+type channelStat struct {
+	last     time.Time
+	interval time.Duration // moving average of time between updates
+}
+
+type subscriber[T Payload] struct {
+	ch     chan T
+	done   chan struct{}   // closed on unsubscribe, to stop a BLOCK broadcast waiting on ch
+	send   sync.Mutex      // held while waiting on ch, so it isn't closed under the broadcast
+	topics map[string]bool // nil means everything
+	name   string
+	opts   SubscribeOptions
+
+	delivered uint64
+	dropped   uint64
+	limited   uint64
+
+	// token bucket per topic for MaxRate
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// SubscriberStats counts what has been delivered to a subscriber, and dropped because it
+// wasn't keeping up
+type SubscriberStats struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Topics    []string `json:"topics,omitempty"`
+	Policy    string   `json:"policy"`
+	Delivered uint64   `json:"delivered"`
+	Dropped   uint64   `json:"dropped"`
+	Limited   uint64   `json:"limited"`
+	Queued    int      `json:"queued"`
 }
 
-func NewHub() *EventHub {
-	return &EventHub{subs: map[int]chan map[string]any{}, last: map[string]any{}}
+// Stats are counters of the hub's deliveries since it started
+type Stats struct {
+	Broadcasts  uint64            `json:"broadcasts"`
+	Delivered   uint64            `json:"delivered"`
+	Dropped     uint64            `json:"dropped"`
+	Limited     uint64            `json:"limited"`
+	Subscribers []SubscriberStats `json:"subscribers"`
+}
+
+// Hub fans payloads out to subscribers, keeping the latest of each topic and a history of
+// those with timestamps
+type Hub[T Payload] struct {
+	// broadcast keeps broadcasts in order while one waits on a BLOCK subscriber, without
+	// holding mu and so everything else up
+	broadcast sync.Mutex
+	mu        sync.Mutex
+	subs      map[int]*subscriber[T]
+	next      int
+	last      map[string]T
+
+	channels map[string]*channelStat
+
+	// timestamped payloads by topic, oldest first, going back retention
+	history       map[string][]T
+	retention     time.Duration
+	historyPaused bool
+
+	broadcasts uint64
+	// totals including subscribers that have since gone
+	delivered uint64
+	dropped   uint64
+	limited   uint64
+}
+
+func New[T Payload]() *Hub[T] {
+	return &Hub[T]{
+		subs:      map[int]*subscriber[T]{},
+		last:      map[string]T{},
+		channels:  map[string]*channelStat{},
+		history:   map[string][]T{},
+		retention: DEFAULT_RETENTION,
+	}
 }
 
-func (h *EventHub) Subscribe() (int, <-chan map[string]any, func()) {
+// SetRetention changes how far back each channel's history goes, from DEFAULT_RETENTION
+func (h *Hub[T]) SetRetention(retention time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retention = retention
+}
+
+// Subscribe returns a channel of events. If topics are given, only events for those channels
+// are delivered. The latest event of every matching channel is sent straight away. Events are
+// dropped, newest first, once the subscriber is SUBSCRIBER_BUFFER behind.
+func (h *Hub[T]) Subscribe(topics ...string) (int, <-chan T, func()) {
+	return h.SubscribeWith(SubscribeOptions{}, topics...)
+}
+
+// SubscribeWith is Subscribe with a choice of buffer size and what happens once it's full
+func (h *Hub[T]) SubscribeWith(opts SubscribeOptions, topics ...string) (int, <-chan T, func()) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = SUBSCRIBER_BUFFER
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DEFAULT_BLOCK_TIMEOUT
+	}
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	id := h.next
 	h.next++
-	ch := make(chan map[string]any, 16)
-	if len(h.last) > 0 {
-		ch <- h.copy(h.last)
+	sub := &subscriber[T]{opts: opts, done: make(chan struct{}), buckets: map[string]*bucket{}}
+	if len(topics) > 0 {
+		sub.topics = map[string]bool{}
+		for _, t := range topics {
+			sub.topics[t] = true
+		}
+	}
+	var snapshot []T
+	for _, event := range h.last {
+		if sub.wants(event) {
+			snapshot = append(snapshot, event)
+		}
+	}
+	sub.ch = make(chan T, opts.Buffer+len(snapshot))
+	for _, event := range snapshot {
+		sub.ch <- event
 	}
-	h.subs[id] = ch
+	h.subs[id] = sub
 	cancel := func() {
 		h.mu.Lock()
-		defer h.mu.Unlock()
-		if c, ok := h.subs[id]; ok {
-			close(c)
+		s, ok := h.subs[id]
+		if ok {
+			h.delivered += s.delivered
+			h.dropped += s.dropped
+			h.limited += s.limited
 			delete(h.subs, id)
 		}
+		h.mu.Unlock()
+		if ok {
+			close(s.done)
+			s.send.Lock()
+			close(s.ch)
+			s.send.Unlock()
+		}
 	}
-	return id, ch, cancel
+	return id, sub.ch, cancel
 }
 
-func (h *EventHub) Broadcast(sig map[string]any) {
+func (h *Hub[T]) Broadcast(event T) {
+	h.broadcast.Lock()
+	defer h.broadcast.Unlock()
 	h.mu.Lock()
-	for k, v := range sig {
-		h.last[k] = v
+	last := event
+	if r, ok := any(event).(Retainer[T]); ok {
+		last = r.Retained()
 	}
-	for _, ch := range h.subs {
-		select {
-		case ch <- h.copy(sig):
-		default:
+	topic := event.Topic()
+	h.last[topic] = last
+	h.broadcasts++
+	if ts, ok := event.Time(); ok {
+		if !h.historyPaused {
+			h.record(topic, ts, event)
+		}
+		h.touch(topic, time.Now())
+	}
+	var blocked []*subscriber[T]
+	for _, sub := range h.subs {
+		if sub.wants(event) && sub.allow(event) && !sub.deliver(event) {
+			blocked = append(blocked, sub)
 		}
 	}
 	h.mu.Unlock()
+
+	for _, sub := range blocked {
+		delivered := sub.wait(event)
+		h.mu.Lock()
+		if delivered {
+			sub.delivered++
+		} else {
+			sub.dropped++
+		}
+		h.mu.Unlock()
+	}
+}
+
+// SetName labels a subscriber in Stats, e.g. with the client it's streaming to
+func (h *Hub[T]) SetName(id int, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sub, ok := h.subs[id]; ok {
+		sub.name = name
+	}
+}
+
+// Stats reports how many events have been broadcast, and delivered to, dropped by or rate
+// limited for each current subscriber. The totals include subscribers that have since
+// unsubscribed.
+func (h *Hub[T]) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := Stats{Broadcasts: h.broadcasts, Delivered: h.delivered, Dropped: h.dropped, Limited: h.limited, Subscribers: []SubscriberStats{}}
+	for id, sub := range h.subs {
+		s := SubscriberStats{ID: id, Name: sub.name, Policy: sub.opts.Policy.String(), Delivered: sub.delivered, Dropped: sub.dropped, Limited: sub.limited, Queued: len(sub.ch)}
+		for topic := range sub.topics {
+			s.Topics = append(s.Topics, topic)
+		}
+		slices.Sort(s.Topics)
+		stats.Delivered += sub.delivered
+		stats.Dropped += sub.dropped
+		stats.Limited += sub.limited
+		stats.Subscribers = append(stats.Subscribers, s)
+	}
+	slices.SortFunc(stats.Subscribers, func(a, b SubscriberStats) int { return cmp.Compare(a.ID, b.ID) })
+	return stats
+}
+
+// Snapshot returns a copy of the latest event of every topic
+func (h *Hub[T]) Snapshot() map[string]T {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.last)
+}
+
+// Restore sets the latest payload of topics that haven't had one yet, e.g. from before a
+// restart. Nothing is broadcast or kept as history.
+func (h *Hub[T]) Restore(events ...T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		if _, ok := h.last[event.Topic()]; !ok {
+			h.last[event.Topic()] = event
+		}
+	}
+}
+
+// PauseHistory stops (or resumes) retaining events for backfill, e.g. while nobody is watching
+func (h *Hub[T]) PauseHistory(paused bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.historyPaused = paused
 }
 
-func (h *EventHub) copy(m map[string]any) map[string]any {
-	out := make(map[string]any, len(m))
-	for k, v := range m {
-		out[k] = v
+// History returns the retained events of every topic with a timestamp after since, oldest
+// first. A since of -1 returns everything retained.
+func (h *Hub[T]) History(since int64) []T {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []T
+	for _, events := range h.history {
+		i, _ := slices.BinarySearchFunc(events, since+1, func(e T, t int64) int { return cmp.Compare(timeOf(e), t) })
+		out = append(out, events[i:]...)
 	}
+	slices.SortStableFunc(out, func(a, b T) int { return cmp.Compare(timeOf(a), timeOf(b)) })
 	return out
 }
+
+// ChannelStatus reports the update age and rate of every timestamped topic seen so far
+func (h *Hub[T]) ChannelStatus() map[string]ChannelStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]ChannelStatus, len(h.channels))
+	for name, c := range h.channels {
+		age := now.Sub(c.last)
+		status := ChannelStatus{LastUpdate: c.last, Age: age, Interval: c.interval}
+		// A channel that has gone quiet should show its rate falling, not the last known rate
+		if interval := max(c.interval, age); interval > 0 {
+			status.Rate = float64(time.Second) / float64(interval)
+		}
+		out[name] = status
+	}
+	return out
+}
+
+func (h *Hub[T]) touch(channel string, now time.Time) {
+	c, ok := h.channels[channel]
+	if !ok {
+		h.channels[channel] = &channelStat{last: now}
+		return
+	}
+	interval := now.Sub(c.last)
+	if c.interval == 0 {
+		c.interval = interval
+	} else {
+		c.interval = (c.interval*4 + interval) / 5
+	}
+	c.last = now
+}
+
+func (h *Hub[T]) record(topic string, ts int64, event T) {
+	events := h.history[topic]
+	// Timestamps going backwards mean the logger or replay restarted, the old history no
+	// longer lines up
+	if n := len(events); n > 0 && ts < timeOf(events[n-1]) {
+		events = nil
+	}
+	events = append(events, event)
+	oldest := ts - h.retention.Milliseconds()
+	drop := 0
+	for drop < len(events) && (timeOf(events[drop]) < oldest || len(events)-drop > HISTORY_SIZE) {
+		drop++
+	}
+	h.history[topic] = events[drop:]
+}
+
+// timeOf is the timestamp of a payload known to have one
+func timeOf[T Payload](event T) int64 {
+	ts, _ := event.Time()
+	return ts
+}
+
+// wants reports whether the event is for one of the subscriber's topics
+func (s *subscriber[T]) wants(event T) bool {
+	return s.topics == nil || s.topics[event.Topic()]
+}
+
+// allow reports whether the event is within the subscriber's MaxRate for its topic
+func (s *subscriber[T]) allow(event T) bool {
+	if s.opts.MaxRate <= 0 {
+		return true
+	}
+	if _, ok := event.Time(); !ok {
+		return true
+	}
+	now := time.Now()
+	b, ok := s.buckets[event.Topic()]
+	if !ok {
+		b = &bucket{tokens: s.opts.MaxRate, filled: now}
+		s.buckets[event.Topic()] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.filled).Seconds()*s.opts.MaxRate, max(s.opts.MaxRate, 1))
+	b.filled = now
+	if b.tokens < 1 {
+		s.limited++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// deliver sends the event, or applies the subscriber's policy if it's fallen behind. It
+// returns false for a BLOCK subscriber with no room, to wait on once the hub is unlocked.
+func (s *subscriber[T]) deliver(event T) bool {
+	select {
+	case s.ch <- event:
+		s.delivered++
+		return true
+	default:
+	}
+
+	switch s.opts.Policy {
+	case DROP_OLDEST:
+		select {
+		case <-s.ch:
+			s.dropped++
+		default:
+		}
+		select {
+		case s.ch <- event:
+			s.delivered++
+		default:
+			s.dropped++
+		}
+	case BLOCK:
+		return false
+	default:
+		s.dropped++
+	}
+	return true
+}
+
+// wait sends the event to a BLOCK subscriber once there's room, reporting false if the timeout
+// passed or it unsubscribed first
+func (s *subscriber[T]) wait(event T) bool {
+	s.send.Lock()
+	defer s.send.Unlock()
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	timeout := time.NewTimer(s.opts.Timeout)
+	defer timeout.Stop()
+	select {
+	case s.ch <- event:
+		return true
+	case <-s.done:
+		return false
+	case <-timeout.C:
+		return false
+	}
+}
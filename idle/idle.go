@@ -0,0 +1,84 @@
+// Package idle works out when nothing needs huskki's attention, so background work can be
+// wound down on battery powered installs.
+package idle
+
+import (
+	"huskki/hub"
+	"sync"
+)
+
+// Monitor considers huskki idle when no dashboard clients are connected and the engine isn't
+// running, i.e. the ignition is off or the rpm is zero.
+type Monitor struct {
+	mu       sync.Mutex
+	clients  int
+	ignition bool
+	rpm      int
+	idle     bool
+	hooks    []func(idle bool)
+}
+
+// NewMonitor starts out idle, until a client connects or the engine starts
+func NewMonitor() *Monitor {
+	return &Monitor{idle: true}
+}
+
+// OnChange registers a function to be called whenever huskki goes idle or wakes up
+func (m *Monitor) OnChange(f func(idle bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, f)
+}
+
+func (m *Monitor) Idle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.idle
+}
+
+// Connect registers a dashboard client for as long as it's connected. Call the returned
+// function when it disconnects.
+func (m *Monitor) Connect() func() {
+	m.update(func() { m.clients++ })
+	var once sync.Once
+	return func() {
+		once.Do(func() { m.update(func() { m.clients-- }) })
+	}
+}
+
+// Start follows the engine state on the hub. The returned function stops monitoring.
+func (m *Monitor) Start(h *hub.EventHub) func() {
+	id, ch, cancel := h.Subscribe("rpm", "ignition")
+	h.SetName(id, "idle")
+	go func() {
+		for event := range ch {
+			m.update(func() {
+				switch event.Channel {
+				case "rpm":
+					m.rpm, _ = event.Int()
+				case "ignition":
+					m.ignition, _ = event.Value.(bool)
+				}
+			})
+		}
+	}()
+	return cancel
+}
+
+// update applies a change to the monitored state and notifies hooks if that changes idleness
+func (m *Monitor) update(change func()) {
+	m.mu.Lock()
+	change()
+	idle := m.clients == 0 && (!m.ignition || m.rpm == 0)
+	if idle == m.idle {
+		m.mu.Unlock()
+		return
+	}
+	m.idle = idle
+	hooks := append([]func(bool){}, m.hooks...)
+	m.mu.Unlock()
+
+	for _, f := range hooks {
+		f(idle)
+	}
+}
@@ -0,0 +1,135 @@
+package ignition
+
+import (
+	"huskki/hub"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_FRAME_TIMEOUT = 5 * time.Second
+	// Below this the ECU supply has collapsed, i.e. the key has been turned off
+	IGNITION_OFF_VOLTAGE = 10.0
+	CHECK_INTERVAL       = time.Second
+)
+
+// Detector watches the hub for ignition cycles. The ignition is considered off when frames
+// stop arriving for the timeout, or when the engine is stopped and the supply voltage drops,
+// and on again when frames resume.
+type Detector struct {
+	timeout time.Duration
+
+	mu        sync.Mutex
+	on        bool
+	lastFrame time.Time
+	rpm       int
+	voltage   float64
+	hooks     []func(on bool)
+	interval  chan time.Duration
+}
+
+func NewDetector(timeout time.Duration) *Detector {
+	if timeout <= 0 {
+		timeout = DEFAULT_FRAME_TIMEOUT
+	}
+	return &Detector{timeout: timeout, voltage: -1, interval: make(chan time.Duration, 1)}
+}
+
+// OnChange registers a function to be called whenever the ignition turns on or off
+func (d *Detector) OnChange(f func(on bool)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = append(d.hooks, f)
+}
+
+func (d *Detector) On() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.on
+}
+
+// Start subscribes the detector to the hub, broadcasting an "ignition" event on each change.
+// The returned function stops detection.
+func (d *Detector) Start(h *hub.EventHub) func() {
+	id, ch, cancel := h.Subscribe()
+	h.SetName(id, "ignition")
+	done := make(chan struct{})
+
+	d.OnChange(func(on bool) {
+		h.Broadcast(hub.Status("ignition", on))
+	})
+
+	go func() {
+		ticker := time.NewTicker(CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				d.record(event, time.Now())
+			case now := <-ticker.C:
+				d.check(now)
+			case interval := <-d.interval:
+				ticker.Reset(interval)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		close(done)
+	}
+}
+
+// SetCheckInterval changes how often the frame timeout is checked, from CHECK_INTERVAL
+func (d *Detector) SetCheckInterval(interval time.Duration) {
+	// only the latest setting matters
+	select {
+	case <-d.interval:
+	default:
+	}
+	d.interval <- interval
+}
+
+func (d *Detector) record(event hub.SensorEvent, now time.Time) {
+	// Only sensor frames carry a timestamp, anything else (including our own events) is ignored,
+	// as are other sources such as GPS that keep going with the ignition off
+	if !event.HasTimestamp || event.Source != "" {
+		return
+	}
+
+	d.mu.Lock()
+	d.lastFrame = now
+	switch event.Channel {
+	case "rpm":
+		d.rpm, _ = event.Int()
+	case "voltage":
+		d.voltage, _ = event.Float()
+	}
+	d.mu.Unlock()
+
+	d.check(now)
+}
+
+func (d *Detector) check(now time.Time) {
+	d.mu.Lock()
+	on := !d.lastFrame.IsZero() && now.Sub(d.lastFrame) < d.timeout
+	if d.rpm == 0 && d.voltage >= 0 && d.voltage < IGNITION_OFF_VOLTAGE {
+		on = false
+	}
+	if on == d.on {
+		d.mu.Unlock()
+		return
+	}
+	d.on = on
+	hooks := append([]func(bool){}, d.hooks...)
+	d.mu.Unlock()
+
+	for _, f := range hooks {
+		f(on)
+	}
+}
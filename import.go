@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"huskki/frames"
+	"huskki/importer"
+	"log"
+	"os"
+	"strings"
+)
+
+// runImport converts a Torque Pro or Car Scanner CSV export into a huskki log that can be replayed
+//
+//	huskki import [-format auto|torque|carscanner] -o ride.csv export.csv
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "auto", "export format: auto, torque or carscanner")
+	out := fs.String("o", "", "output log path (default stdout)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("usage: huskki import [-format auto|torque|carscanner] [-o out.csv] export.csv")
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+
+	if *format == "auto" {
+		header, err := reader.Peek(4096)
+		if err != nil && len(header) == 0 {
+			log.Fatal(err)
+		}
+		firstLine, _, _ := strings.Cut(string(header), "\n")
+		if *format, err = importer.Detect(firstLine); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	samples, err := importer.Read(*format, reader)
+	if err != nil {
+		log.Fatalf("import %s: %v", fs.Arg(0), err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	writer := frames.NewWriter(w)
+	defer writer.Flush()
+
+	written := 0
+	for _, s := range samples {
+		did, data, ok := frames.Encode(s.Channel, s.Value)
+		if !ok {
+			continue
+		}
+		if err := writer.Write(frames.Frame{Millis: s.Millis, DID: did, Data: data}); err != nil {
+			log.Fatal(err)
+		}
+		written++
+	}
+	log.Printf("imported %d of %d samples from %s (%s)", written, len(samples), fs.Arg(0), *format)
+}
@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"huskki/obd"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_TORQUE      = "torque"
+	FORMAT_CAR_SCANNER = "carscanner"
+)
+
+// Sample is a single decoded value from a third-party export, timed relative to its first row
+type Sample struct {
+	Millis  int64
+	Channel string
+	Value   float64
+}
+
+// channelFor maps a column or PID name used by an OBD app onto a huskki channel
+func channelFor(name string) (string, bool) {
+	pid, ok := obd.ByName(name)
+	return pid.Channel, ok
+}
+
+// Detect guesses the export format from its first line
+func Detect(header string) (string, error) {
+	lower := strings.ToLower(header)
+	switch {
+	case strings.Contains(lower, "device time"):
+		return FORMAT_TORQUE, nil
+	case strings.Contains(lower, "seconds") && strings.Contains(lower, "pid"):
+		return FORMAT_CAR_SCANNER, nil
+	}
+	return "", fmt.Errorf("unrecognised export header: %q", header)
+}
+
+func Read(format string, r io.Reader) ([]Sample, error) {
+	switch format {
+	case FORMAT_TORQUE:
+		return ReadTorque(r)
+	case FORMAT_CAR_SCANNER:
+		return ReadCarScanner(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// Torque Pro writes one row per log tick, with a column per PID and "-" for missing values
+var torqueTimeLayouts = []string{
+	"02-Jan-2006 15:04:05.000",
+	"02-Jan-2006 15:04:05",
+	"Mon Jan 02 15:04:05 MST 2006",
+}
+
+func ReadTorque(r io.Reader) ([]Sample, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	timeCol := -1
+	type column struct {
+		index   int
+		channel string
+	}
+	var columns []column
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), "device time") {
+			timeCol = i
+		} else if channel, ok := channelFor(name); ok {
+			columns = append(columns, column{i, channel})
+		}
+	}
+	if timeCol < 0 {
+		return nil, fmt.Errorf("no Device Time column")
+	}
+
+	var samples []Sample
+	var start time.Time
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if timeCol >= len(record) {
+			continue
+		}
+		t, err := parseTorqueTime(record[timeCol])
+		if err != nil {
+			continue
+		}
+		if start.IsZero() {
+			start = t
+		}
+		millis := t.Sub(start).Milliseconds()
+		// Several PIDs can map to one channel, e.g. Throttle Position and Absolute Throttle
+		// Position both to tps, so each row takes the first column with a value
+		read := map[string]bool{}
+		for _, col := range columns {
+			if col.index >= len(record) || read[col.channel] {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[col.index]), 64)
+			if err != nil {
+				continue
+			}
+			read[col.channel] = true
+			samples = append(samples, Sample{Millis: millis, Channel: col.channel, Value: v})
+		}
+	}
+	sortSamples(samples)
+	return samples, nil
+}
+
+func parseTorqueTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range torqueTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", s)
+}
+
+// Car Scanner writes one row per value: "SECONDS";"PID";"VALUE";"UNITS"
+func ReadCarScanner(r io.Reader) ([]Sample, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = -1
+
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	var samples []Sample
+	first := -1.0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		if err != nil {
+			continue
+		}
+		channel, ok := channelFor(record[1])
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil {
+			continue
+		}
+		if first < 0 {
+			first = seconds
+		}
+		samples = append(samples, Sample{Millis: int64(math.Round((seconds - first) * 1000)), Channel: channel, Value: v})
+	}
+	sortSamples(samples)
+	return samples, nil
+}
+
+func sortSamples(samples []Sample) {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Millis < samples[j].Millis })
+}
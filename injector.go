@@ -0,0 +1,49 @@
+package main
+
+import (
+	"huskki/frames"
+	"huskki/hub"
+	"log"
+	"time"
+)
+
+const (
+	// INJECTOR_DUTY_HIGH_CHANNEL carries whether the injector duty cycle is over the warning threshold
+	INJECTOR_DUTY_HIGH_CHANNEL = "injector_duty_high"
+	// Past ~85% there's little headroom left before the injector goes static
+	DEFAULT_INJECTOR_DUTY_WARN = 85.0
+)
+
+// watchInjector broadcasts the injector duty cycle, computed from the pulse width and RPM, and
+// INJECTOR_DUTY_HIGH_CHANNEL when it crosses warn. The returned function stops it.
+func watchInjector(h *hub.EventHub, warn float64) func() {
+	id, ch, cancel := h.Subscribe("injector", "rpm")
+	h.SetName(id, "injector")
+	go func() {
+		rpm := 0
+		var high threshold
+		for event := range ch {
+			if event.Channel == "rpm" {
+				rpm, _ = event.Int()
+				continue
+			}
+			pulse, ok := event.Float()
+			if !ok || rpm <= 0 {
+				continue
+			}
+
+			// One injection per cycle, i.e. every two revolutions of a four stroke
+			cycleMs := 120000 / float64(rpm)
+			duty := pulse / cycleMs * 100
+			h.Broadcast(hub.Sample("injector_duty", frames.Value("injector_duty", duty), "%", event.Timestamp, event.Received))
+
+			if high.observe(duty > warn, duty <= warn, time.Now()) {
+				if high.firing {
+					log.Printf("injector duty %.1f%% over %.0f%% at %d RPM", duty, warn, rpm)
+				}
+				h.Broadcast(hub.Status(INJECTOR_DUTY_HIGH_CHANNEL, high.firing))
+			}
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"huskki/hub"
+	"huskki/input"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LINK_CHANNEL carries whether a live input source is currently connected
+const LINK_CHANNEL = "link"
+
+// What's on the other end of -port
+const (
+	PROTOCOL_LOGGER  = "logger"  // the Arduino logger's CSV rows
+	PROTOCOL_KWP2000 = "kwp2000" // an ECU on K-line, through a KKL adapter
+)
+
+// newInputSource picks where frames are read from based on the command line
+func newInputSource(flags *Flags) input.InputSource {
+	onLink := func(up bool) {
+		EventHub.Broadcast(hub.Status(LINK_CHANNEL, up))
+	}
+	if flags.UDSPoll != "" && flags.CAN == "" && flags.Protocol != PROTOCOL_KWP2000 {
+		log.Fatal("-uds-poll needs a -can interface or -protocol kwp2000 to poll over")
+	}
+	switch {
+	case flags.ReplayFile != "":
+		start, err := input.ParseLogTime(flags.ReplayStart)
+		if err != nil {
+			log.Fatalf("-replay-start: %v", err)
+		}
+		end, err := input.ParseLogTime(flags.ReplayEnd)
+		if err != nil {
+			log.Fatalf("-replay-end: %v", err)
+		}
+		paths, err := input.ReplayPaths(flags.ReplayFile)
+		if err != nil {
+			log.Fatalf("-replay: %v", err)
+		}
+		dids, err := input.ParseDIDs(flags.ReplayDIDs)
+		if err != nil {
+			log.Fatalf("-replay-did: %v", err)
+		}
+		Replay = &input.File{Paths: paths, Realtime: true, Start: start, End: end, DIDs: dids, Loop: flags.ReplayLoop}
+		rewinds := 0
+		Replay.OnRewind = func() {
+			rewinds++
+			event := hub.Status(REPLAY_REWIND_CHANNEL, rewinds)
+			event.Received = time.Now()
+			EventHub.Broadcast(event)
+		}
+		return Replay
+	case flags.Simulate:
+		return &input.Simulator{}
+	case flags.Candump != "":
+		ids, err := input.ParseCANIDs(flags.CANIDs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return &input.Candump{Path: flags.Candump, IDs: ids, Realtime: flags.Candump != "-"}
+	case flags.Connect != "":
+		return &input.Reconnecting{Source: &input.Dial{Addr: flags.Connect}, OnLink: onLink}
+	case flags.ListenTCP != "":
+		return &input.Listener{Addr: flags.ListenTCP, OnLink: onLink}
+	case flags.ListenUDP != "":
+		return &input.Datagram{Addr: flags.ListenUDP}
+	case flags.MQTT != "":
+		return &input.MQTT{URL: flags.MQTT, OnLink: onLink}
+	case flags.BTAddr != "":
+		bt := &input.RFCOMM{Addr: flags.BTAddr, Channel: flags.BTChannel, OnBoot: pushDeviceConfig}
+		Device, Diagnostics, Firmware = bt, bt, bt
+		return &input.Reconnecting{Source: bt, OnLink: onLink}
+	case flags.ELM327 != "":
+		elm := &input.ELM327{Port: flags.ELM327, Baud: flags.ELM327Baud}
+		Diagnostics = elm
+		return &input.Reconnecting{Source: elm, OnLink: onLink}
+	case flags.CAN != "":
+		ids, err := input.ParseCANIDs(flags.CANIDs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		can := &input.SocketCAN{Interface: flags.CAN, IDs: ids, RequestID: uint32(flags.UDSRequestID)}
+		IOControl = can
+		if flags.UDSPoll != "" {
+			dids, err := input.ParsePollList(flags.UDSPoll)
+			if err != nil {
+				log.Fatal(err)
+			}
+			poller := &input.UDSPoller{Sender: can, RequestID: uint32(flags.UDSRequestID), DIDs: dids}
+			poller.Start()
+		}
+		return &input.Reconnecting{Source: can, OnLink: onLink}
+	}
+	if flags.Protocol == PROTOCOL_KWP2000 {
+		dids, err := input.ParsePollList(flags.UDSPoll)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kwp := &input.KWP2000{Port: flags.Port, ECU: byte(flags.KWPECU), DIDs: dids}
+		return &input.Reconnecting{Source: kwp, OnLink: onLink}
+	}
+	if flags.Protocol != PROTOCOL_LOGGER {
+		log.Fatalf("unknown -protocol %q, expected %s or %s", flags.Protocol, PROTOCOL_LOGGER, PROTOCOL_KWP2000)
+	}
+	if flags.Port == "-" {
+		return &input.Stdin{}
+	}
+	serial := &input.Serial{Port: flags.Port, Baud: flags.Baud, OnBoot: pushDeviceConfig}
+	Device, Diagnostics, Firmware = serial, serial, serial
+	return &input.Reconnecting{Source: serial, OnLink: onLink}
+}
+
+// parsePreferPorts reads -prefer-ports, either inline or from the @file given
+func parsePreferPorts(value string) ([]input.PortMatch, error) {
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		return input.LoadPortMatches(path)
+	}
+	return input.ParsePortMatches(value)
+}
+
+// FramesRead counts the frames read from the input source
+var FramesRead atomic.Uint64
+
+// readFrames decodes and broadcasts every frame from the source until it's exhausted
+func readFrames(source input.InputSource, eventHub *hub.EventHub) {
+	for {
+		frame, err := source.ReadFrame()
+		if err == io.EOF || err == input.ErrClosed {
+			return
+		}
+		if err != nil {
+			log.Printf("read frame: %v", err)
+			return
+		}
+		FramesRead.Add(1)
+		Recording.Write(frame.Frame)
+		LoggerClock.observe(frame.Millis, frame.Received)
+		Discovery.Observe(frame.DID, frame.Data, frame.Received)
+		broadcastParsedSensorData(eventHub, uint64(frame.DID), rawCANID(frame), frame.Data, frame.Millis, frame.Received)
+	}
+}
+
+// rawCANID is the CAN ID of frames passed through without a DID, for -dbc to decode
+func rawCANID(frame input.Frame) uint32 {
+	if frame.DID == 0 && input.RawCANIDs[frame.CANID] {
+		return frame.CANID
+	}
+	return 0
+}
+
+// loggerClock estimates the logger's millis between frames, so events from other sources
+// (e.g. GPS) can be placed on the same timeline
+type loggerClock struct {
+	mu     sync.Mutex
+	millis int64
+	at     time.Time
+}
+
+// LoggerClock counts from startup until the first frame arrives
+var LoggerClock = &loggerClock{at: time.Now()}
+
+func (c *loggerClock) observe(millis int64, received time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.millis, c.at = millis, received
+}
+
+// Now is the logger's millis at t
+func (c *loggerClock) Now(t time.Time) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.millis + t.Sub(c.at).Milliseconds()
+}
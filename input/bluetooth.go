@@ -0,0 +1,26 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_RFCOMM_CHANNEL = 1
+
+// parseBluetoothAddr parses an address such as AA:BB:CC:DD:EE:FF, most significant byte first
+func parseBluetoothAddr(s string) ([6]byte, error) {
+	var addr [6]byte
+	parts := strings.Split(s, ":")
+	if len(parts) != len(addr) {
+		return addr, fmt.Errorf("invalid bluetooth address %q, expected AA:BB:CC:DD:EE:FF", s)
+	}
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || len(part) != 2 {
+			return addr, fmt.Errorf("invalid bluetooth address %q, expected AA:BB:CC:DD:EE:FF", s)
+		}
+		addr[i] = byte(b)
+	}
+	return addr, nil
+}
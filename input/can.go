@@ -0,0 +1,65 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 11-bit OBD/UDS diagnostic response IDs
+	UDS_RESPONSE_ID_MIN = 0x7E8
+	UDS_RESPONSE_ID_MAX = 0x7EF
+	// positive response to ReadDataByIdentifier (0x22)
+	UDS_READ_DID_RESPONSE = 0x62
+)
+
+// RawCANIDs are passed through as they are with DID 0, for a DBC to decode, unless they're
+// mapped to a DID or are UDS responses
+var RawCANIDs = map[uint32]bool{}
+
+// canToDID maps a CAN frame onto the DID decode path. IDs listed in ids carry the payload of
+// that DID directly; otherwise single frame ReadDataByIdentifier responses from the ECU are
+// unwrapped to their DID and data.
+func canToDID(id uint32, data []byte, ids map[uint32]uint16) (uint16, []byte, bool) {
+	if did, ok := ids[id]; ok {
+		return did, data, len(data) > 0
+	}
+	if RawCANIDs[id] && (id < UDS_RESPONSE_ID_MIN || id > UDS_RESPONSE_ID_MAX) {
+		return 0, data, len(data) > 0
+	}
+	if id < UDS_RESPONSE_ID_MIN || id > UDS_RESPONSE_ID_MAX || len(data) < 4 {
+		return 0, nil, false
+	}
+	// ISO-TP single frame: high nibble 0, low nibble payload length
+	length := int(data[0] & 0x0F)
+	if data[0]>>4 != 0 || length < 4 || length >= len(data) || data[1] != UDS_READ_DID_RESPONSE {
+		return 0, nil, false
+	}
+	return uint16(data[2])<<8 | uint16(data[3]), data[4 : 1+length], true
+}
+
+// ParseCANIDs parses a comma separated list of canID=DID mappings, e.g. "0x280=0x0100,0x288=0x0009"
+func ParseCANIDs(s string) (map[uint32]uint16, error) {
+	ids := map[uint32]uint16{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		canID, did, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid CAN mapping %q, expected canID=DID", pair)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(canID), 0, 29)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAN ID %q: %w", canID, err)
+		}
+		d, err := strconv.ParseUint(strings.TrimSpace(did), 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DID %q: %w", did, err)
+		}
+		ids[uint32(id)] = uint16(d)
+	}
+	return ids, nil
+}
@@ -0,0 +1,134 @@
+package input
+
+import (
+	"bufio"
+	"encoding/hex"
+	"huskki/frames"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Candump reads CAN frames captured by the Linux can-utils candump, either a log written with
+// candump -l / -L ("(1436509052.249713) can0 7E8#0462010012345678"), or its default output
+// ("can0  7E8   [8]  04 62 01 00 12 34 56 78", optionally with -t a timestamps). Path "-"
+// reads a live stream from stdin. CAN IDs map to DIDs as for SocketCAN, see canToDID.
+// With Realtime set, logged frames are released at the pace they were captured.
+type Candump struct {
+	Path     string
+	IDs      map[uint32]uint16
+	Realtime bool
+
+	reader  io.ReadCloser
+	scanner *bufio.Scanner
+	opened  time.Time
+	first   int64 // micros of the first timestamped frame
+	start   time.Time
+}
+
+func (c *Candump) Open() error {
+	c.reader = os.Stdin
+	if c.Path != "-" {
+		file, err := os.Open(c.Path)
+		if err != nil {
+			return err
+		}
+		c.reader = file
+	}
+	c.scanner = bufio.NewScanner(c.reader)
+	c.opened, c.first = time.Now(), -1
+	return nil
+}
+
+func (c *Candump) ReadFrame() (Frame, error) {
+	for c.scanner.Scan() {
+		received := time.Now()
+		micros, timestamped, id, data, ok := parseCandump(c.scanner.Text())
+		if !ok {
+			continue
+		}
+		did, payload, ok := canToDID(id, data, c.IDs)
+		if !ok {
+			continue
+		}
+
+		if timestamped {
+			if c.first < 0 {
+				c.first, c.start = micros, received
+			}
+			micros -= c.first
+			if c.Realtime {
+				if wait := time.Duration(micros)*time.Microsecond - time.Since(c.start); wait > 0 {
+					time.Sleep(wait)
+				}
+				received = time.Now()
+			}
+		} else {
+			micros = received.Sub(c.opened).Microseconds()
+		}
+		frame := frames.Frame{Millis: micros / 1000, DID: did, Data: payload, Micros: micros, CANID: id}
+		return Frame{Frame: frame, Received: received}, nil
+	}
+	if err := c.scanner.Err(); err != nil {
+		return Frame{}, err
+	}
+	return Frame{}, io.EOF
+}
+
+func (c *Candump) Close() error {
+	if c.reader == nil || c.reader == os.Stdin {
+		return nil
+	}
+	return c.reader.Close()
+}
+
+// parseCandump decodes a line of candump output in either format, skipping remote, error and
+// malformed frames. micros is the capture time, if the line has one.
+func parseCandump(line string) (micros int64, timestamped bool, id uint32, data []byte, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "(") && strings.HasSuffix(fields[0], ")") {
+		seconds, frac, _ := strings.Cut(strings.Trim(fields[0], "()"), ".")
+		s, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			return 0, false, 0, nil, false
+		}
+		us, _ := strconv.ParseInt((frac + "000000")[:6], 10, 64)
+		micros, timestamped = s*1_000_000+us, true
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return 0, false, 0, nil, false
+	}
+
+	// log format: iface ID#data, or ID##Fdata for CAN FD
+	if idStr, dataStr, found := strings.Cut(fields[1], "#"); found {
+		if strings.HasPrefix(dataStr, "R") {
+			return 0, false, 0, nil, false
+		}
+		if fd, isFD := strings.CutPrefix(dataStr, "#"); isFD && len(fd) > 0 {
+			dataStr = fd[1:]
+		}
+		id, ok = parseCandumpID(idStr)
+		data, err := hex.DecodeString(dataStr)
+		return micros, timestamped, id, data, ok && err == nil && len(data) > 0
+	}
+
+	// default format: iface ID [len] bytes...
+	if len(fields) < 4 || !strings.HasPrefix(fields[2], "[") {
+		return 0, false, 0, nil, false
+	}
+	id, ok = parseCandumpID(fields[1])
+	data, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	return micros, timestamped, id, data, ok && err == nil && len(data) > 0
+}
+
+// parseCandumpID parses a hex CAN ID, 3 digits for standard and 8 for extended frames
+func parseCandumpID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || id > 0x1FFFFFFF {
+		return 0, false
+	}
+	return uint32(id), true
+}
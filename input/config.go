@@ -0,0 +1,24 @@
+package input
+
+import (
+	"huskki/frames"
+	"time"
+)
+
+// How long the logger has to take a new sampling config, it reads commands between polls
+const CONFIG_REPLY_TIMEOUT = 5 * time.Second
+
+// Configurer is a source whose logger can be told which DIDs to sample, and how often
+type Configurer interface {
+	Configure(samples []frames.Sample) error
+}
+
+func (s *Serial) Configure(samples []frames.Sample) error {
+	return configureLogger(&s.replies, s.Send, samples)
+}
+
+// configureLogger sends the config and waits for the logger to ACK it, a NACK is an error
+func configureLogger(r *replies, send func(frames.Command) error, samples []frames.Sample) error {
+	_, err := r.await(send, frames.ConfigCommand(samples), CONFIG_REPLY_TIMEOUT)
+	return err
+}
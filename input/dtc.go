@@ -0,0 +1,116 @@
+package input
+
+import (
+	"encoding/hex"
+	"fmt"
+	"huskki/dtc"
+	"huskki/frames"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long the logger has to read or clear codes, it talks to the ECU in between
+const DTC_REPLY_TIMEOUT = 5 * time.Second
+
+// DTCReader is a source that can read and clear the ECU's diagnostic trouble codes
+type DTCReader interface {
+	ReadDTCs() ([]dtc.Code, error)
+	ClearDTCs() error
+}
+
+func (s *Serial) ReadDTCs() ([]dtc.Code, error) {
+	return readLoggerDTCs(&s.replies, s.Send)
+}
+
+func (s *Serial) ClearDTCs() error {
+	return clearLoggerDTCs(&s.replies, s.Send)
+}
+
+// readLoggerDTCs asks the logger to read the codes over UDS 0x19, parsing its "XXXXXX:SS" replies
+func readLoggerDTCs(r *replies, send func(frames.Command) error) ([]dtc.Code, error) {
+	reply, err := r.await(send, frames.Command{Name: frames.DTC_COMMAND}, DTC_REPLY_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	var codes []dtc.Code
+	for _, arg := range reply.Args {
+		code, status, ok := strings.Cut(arg, ":")
+		c, err := strconv.ParseUint(code, 16, 24)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("invalid DTC %q in reply", arg)
+		}
+		st, err := strconv.ParseUint(status, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTC status %q in reply", arg)
+		}
+		codes = append(codes, dtc.Code{DTC: uint32(c), Status: byte(st)})
+	}
+	return codes, nil
+}
+
+func clearLoggerDTCs(r *replies, send func(frames.Command) error) error {
+	_, err := r.await(send, frames.Command{Name: frames.CLEAR_DTC_COMMAND}, DTC_REPLY_TIMEOUT)
+	return err
+}
+
+// OBD-II trouble code modes
+const (
+	OBD_STORED_DTCS  = 0x03
+	OBD_CLEAR_DTCS   = 0x04
+	OBD_PENDING_DTCS = 0x07
+)
+
+// ReadDTCs reads the stored (mode 03) and pending (mode 07) codes
+func (e *ELM327) ReadDTCs() ([]dtc.Code, error) {
+	var codes []dtc.Code
+	for _, mode := range []struct {
+		mode   byte
+		status byte
+	}{{OBD_STORED_DTCS, dtc.STATUS_CONFIRMED}, {OBD_PENDING_DTCS, dtc.STATUS_PENDING}} {
+		resp, err := e.command(fmt.Sprintf("%02X", mode.mode))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, parseELM327DTCs(resp, mode.mode, mode.status)...)
+	}
+	return codes, nil
+}
+
+func (e *ELM327) ClearDTCs() error {
+	resp, err := e.command(fmt.Sprintf("%02X", OBD_CLEAR_DTCS))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ReplaceAll(resp, " ", ""), fmt.Sprintf("%02X", 0x40|OBD_CLEAR_DTCS)) {
+		return fmt.Errorf("clear codes: %s", strings.TrimSpace(resp))
+	}
+	return nil
+}
+
+// parseELM327DTCs reads the two byte codes out of a mode 03/07 reply, e.g. "43 01 33 00 00", or
+// on CAN "43 01 01 33" with a count after the mode. Multi-frame CAN replies are prefixed "0:".
+func parseELM327DTCs(resp string, mode, status byte) []dtc.Code {
+	want := fmt.Sprintf("%02X", 0x40|mode)
+	var codes []dtc.Code
+	for _, line := range strings.FieldsFunc(resp, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		line = strings.ToUpper(strings.ReplaceAll(line, " ", ""))
+		if _, after, ok := strings.Cut(line, ":"); ok {
+			line = after
+		}
+		line = strings.TrimPrefix(line, want)
+		data, err := hex.DecodeString(line)
+		if err != nil {
+			continue
+		}
+		if len(data)%2 == 1 {
+			data = data[1:] // CAN count byte
+		}
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] != 0 || data[i+1] != 0 {
+				codes = append(codes, dtc.FromOBD(data[i], data[i+1], status))
+			}
+		}
+	}
+	return codes
+}
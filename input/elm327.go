@@ -0,0 +1,146 @@
+package input
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"huskki/obd"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	ELM327_BAUD_RATE = 38400
+	// how long to wait for the adapter's prompt before giving up on a command
+	ELM327_TIMEOUT = 5 * time.Second
+)
+
+// sent on connect: reset, no echo, no linefeeds, no spaces, no headers, auto-detect protocol
+var elm327Init = []string{"ATZ", "ATE0", "ATL0", "ATS0", "ATH0", "ATSP0"}
+
+var errELM327Timeout = errors.New("timed out waiting for ELM327")
+
+// ELM327 polls standard OBD-II PIDs through an ELM327 dongle, over USB serial or Bluetooth bound
+// to a serial device (e.g. /dev/rfcomm0), and re-encodes the responses as the logger's DIDs so
+// they decode to the same channels. Millis are counted from when the source was first opened.
+type ELM327 struct {
+	Port string
+	Baud int
+
+	mu    sync.Mutex // one command at a time, polling and reading codes share the adapter
+	port  serial.Port
+	start time.Time
+	next  int
+}
+
+func (e *ELM327) Open() error {
+	port, err := serial.Open(e.Port, &serial.Mode{BaudRate: e.Baud})
+	if err != nil {
+		return fmt.Errorf("open elm327 %s: %w", e.Port, err)
+	}
+	if err := port.SetReadTimeout(100 * time.Millisecond); err != nil {
+		port.Close()
+		return err
+	}
+	e.port = port
+	for _, cmd := range elm327Init {
+		if _, err := e.command(cmd); err != nil {
+			port.Close()
+			return fmt.Errorf("elm327 %s: %w", cmd, err)
+		}
+	}
+	log.Printf("Connected to ELM327 on %s @ %d", e.Port, e.Baud)
+
+	if e.start.IsZero() {
+		e.start = time.Now()
+	}
+	return nil
+}
+
+// ReadFrame polls the next PID in turn, skipping any the vehicle doesn't answer
+func (e *ELM327) ReadFrame() (Frame, error) {
+	for {
+		pid := obd.PIDs[e.next%len(obd.PIDs)]
+		e.next++
+
+		resp, err := e.command(fmt.Sprintf("%02X%02X", obd.MODE_CURRENT_DATA, pid.PID))
+		if err != nil {
+			return Frame{}, err
+		}
+		received := time.Now()
+		data, ok := parseELM327Response(resp, pid)
+		if !ok {
+			continue
+		}
+		did, payload, ok := frames.Encode(pid.Channel, pid.Decode(data))
+		if !ok {
+			continue
+		}
+		return Frame{
+			Frame:    frames.Frame{Millis: received.Sub(e.start).Milliseconds(), DID: did, Data: payload},
+			Received: received,
+		}, nil
+	}
+}
+
+func (e *ELM327) Close() error {
+	if e.port == nil {
+		return nil
+	}
+	return e.port.Close()
+}
+
+// command sends cmd and returns everything the adapter replies before its '>' prompt
+func (e *ELM327) command(cmd string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.port == nil {
+		return "", ErrClosed
+	}
+	if _, err := e.port.Write([]byte(cmd + "\r")); err != nil {
+		return "", err
+	}
+	var resp []byte
+	buf := make([]byte, 128)
+	deadline := time.Now().Add(ELM327_TIMEOUT)
+	for time.Now().Before(deadline) {
+		n, err := e.port.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		resp = append(resp, buf[:n]...)
+		if i := bytes.IndexByte(resp, '>'); i >= 0 {
+			return string(resp[:i]), nil
+		}
+	}
+	return "", errELM327Timeout
+}
+
+// parseELM327Response finds the mode 01 reply for pid, e.g. "410C1AF8", among the adapter's
+// output. Replies like NO DATA or SEARCHING... are skipped over.
+func parseELM327Response(resp string, pid obd.PID) ([]byte, bool) {
+	want := fmt.Sprintf("%02X%02X", 0x40|obd.MODE_CURRENT_DATA, pid.PID)
+	for _, line := range strings.FieldsFunc(resp, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		line = strings.ToUpper(strings.ReplaceAll(line, " ", ""))
+		i := strings.Index(line, want)
+		if i < 0 {
+			continue
+		}
+		hexData := line[i+len(want):]
+		if len(hexData) < pid.Bytes*2 {
+			continue
+		}
+		data, err := hex.DecodeString(hexData[:pid.Bytes*2])
+		if err != nil {
+			continue
+		}
+		return data, true
+	}
+	return nil, false
+}
@@ -0,0 +1,591 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"huskki/rawlog"
+	"huskki/timeline"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MIN_REPLAY_SPEED = 0.1
+	MAX_REPLAY_SPEED = 64
+)
+
+// STEP_TIMEOUT is how long Step waits for the next frame, e.g. while seeking through a large log
+const STEP_TIMEOUT = 10 * time.Second
+
+var (
+	ErrReplayEnded = errors.New("replay has ended")
+	// errSeeked interrupts waiting for a frame's time when the replay is moved elsewhere
+	errSeeked = errors.New("replay seeked")
+)
+
+// LogTime is a point in a log, either the logger's millis or a duration after its first frame
+type LogTime struct {
+	Millis   int64
+	Since    time.Duration
+	Absolute bool
+}
+
+// ParseLogTime parses the logger's millis, e.g. "2580000", or a duration after the first
+// frame, e.g. "43m". An empty string is no time, nil.
+func ParseLogTime(s string) (*LogTime, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if millis, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &LogTime{Millis: millis, Absolute: true}, nil
+	}
+	since, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log time %q, expected the logger's millis or a duration like 43m", s)
+	}
+	return &LogTime{Since: since}, nil
+}
+
+// offset is how long after the first frame, at first millis, the time is
+func (t LogTime) offset(first int64) time.Duration {
+	if t.Absolute {
+		return time.Duration(t.Millis-first) * time.Millisecond
+	}
+	return t.Since
+}
+
+// File reads frames from recorded logs, which can be gzip or zstd compressed. Several logs, e.g. a ride split across
+// files, are read back-to-back as one timeline. With Realtime set, frames are released at the pace they were recorded,
+// relative to the first frame, and the replay can be paused, seeked and sped up. A realtime replay holds at the end of
+// the log rather than ending, so it can be seeked back.
+type File struct {
+	Paths    []string
+	Realtime bool
+	// Start and End, if set, limit the frames read to a window of the log
+	Start *LogTime
+	End   *LogTime
+	// DIDs, if set, are the only ones read, the rest are skipped
+	DIDs map[uint16]bool
+	// Loop starts a realtime replay over from Start at the end, rather than holding there
+	Loop bool
+	// OnRewind is called whenever the replay goes back, looping or seeking to earlier in the
+	// log, so what was read from later on can be cleared away
+	OnRewind func()
+
+	log     io.ReadCloser
+	lines   *lineReader
+	first   int64
+	started time.Time
+	closed  chan struct{}
+
+	mu sync.Mutex
+	// The replay's clock: the log's millis was anchorMillis at anchorAt, advancing at speed unless paused
+	anchorMillis int64
+	anchorAt     time.Time
+	speed        float64
+	paused       bool
+	ended        bool
+	// position is the millis of the last frame released, seekTo where to go next if seeking
+	position  int64
+	seekTo    *time.Duration
+	skipUntil int64
+	// step, if set, releases the next frame now and is sent it
+	step chan Frame
+	// released is whether a frame has been read since the replay last looped
+	released bool
+	duration time.Duration
+	// changed is closed and replaced whenever the controls change, waking a waiting ReadFrame
+	changed chan struct{}
+}
+
+// ReplayStatus is where a replay is up to. Times are since the log's first frame.
+type ReplayStatus struct {
+	Paths    []string      `json:"paths"`
+	Playing  bool          `json:"playing"`
+	Ended    bool          `json:"ended"`
+	Speed    float64       `json:"speed"`
+	Position time.Duration `json:"position"`
+	// Duration is 0 until the log has been measured
+	Duration time.Duration `json:"duration"`
+	// First is the logger's millis at the first frame, -1 until it's been read, and Started
+	// when the first frame was recorded, if the log says
+	First   int64     `json:"first"`
+	Started time.Time `json:"started,omitzero"`
+}
+
+func (f *File) Open() error {
+	if len(f.Paths) == 0 {
+		return errors.New("no logs to replay")
+	}
+	log, err := openLogs(f.Paths)
+	if err != nil {
+		return err
+	}
+	f.log, f.lines, f.first = log, newReplayLines(log), -1
+	f.started = readStarted(f.Paths[0])
+	f.closed, f.changed, f.speed = make(chan struct{}), make(chan struct{}), 1
+	if f.Realtime {
+		go f.measure()
+	}
+	return nil
+}
+
+func (f *File) ReadFrame() (Frame, error) {
+	for {
+		if err := f.seek(); err != nil {
+			return Frame{}, err
+		}
+		frame, err := f.lines.next()
+		if err == nil && f.first < 0 {
+			f.mu.Lock()
+			f.first, f.anchorMillis, f.anchorAt, f.position = frame.Millis, frame.Millis, time.Now(), frame.Millis
+			f.mu.Unlock()
+			if f.Start != nil {
+				f.skipUntil = f.first + f.Start.offset(f.first).Milliseconds()
+				if f.Realtime {
+					f.Seek(f.Start.offset(f.first))
+					continue
+				}
+			}
+		}
+		if err == nil && f.End != nil && frame.Millis > f.first+f.End.offset(f.first).Milliseconds() {
+			err = io.EOF
+		}
+		if err == io.EOF && f.Realtime && f.Loop && f.released {
+			// A window or log with no frames would loop forever without releasing any
+			f.released = false
+			f.Seek(f.startOffset())
+			continue
+		}
+		if err == io.EOF && f.Realtime {
+			if err := f.holdAtEnd(); err != nil {
+				return Frame{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return frame, err
+		}
+		if frame.Millis < f.skipUntil || len(f.DIDs) > 0 && !f.DIDs[frame.DID] {
+			continue
+		}
+		if !f.Realtime {
+			return frame, nil
+		}
+
+		step, err := f.wait(frame.Millis)
+		if err == errSeeked {
+			continue
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+		frame.Received, f.released = time.Now(), true
+		if step != nil {
+			step <- frame
+		}
+		return frame, nil
+	}
+}
+
+func (f *File) Close() error {
+	if f.log == nil {
+		return nil
+	}
+	select {
+	case <-f.closed:
+		return nil
+	default:
+		close(f.closed)
+	}
+	return f.log.Close()
+}
+
+// Play resumes the replay, from the start (or Start) if it had ended
+func (f *File) Play() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ended {
+		start := f.startOffset()
+		f.seekTo = &start
+	}
+	if f.paused {
+		f.anchorMillis, f.anchorAt, f.paused = f.now(), time.Now(), false
+	}
+	f.notify()
+}
+
+func (f *File) Pause() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.paused {
+		f.anchorMillis, f.anchorAt, f.paused = f.now(), time.Now(), true
+	}
+	f.notify()
+}
+
+// SetSpeed replays at speed times as fast as recorded
+func (f *File) SetSpeed(speed float64) error {
+	if speed < MIN_REPLAY_SPEED || speed > MAX_REPLAY_SPEED {
+		return fmt.Errorf("replay speed %v outside %v..%v", speed, MIN_REPLAY_SPEED, MAX_REPLAY_SPEED)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.anchorMillis, f.anchorAt, f.speed = f.now(), time.Now(), speed
+	f.notify()
+	return nil
+}
+
+// Seek moves the replay to offset after the log's first frame
+func (f *File) Seek(offset time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	offset = max(offset, 0)
+	f.seekTo = &offset
+	f.notify()
+}
+
+// startOffset is where the replay starts, after the log's first frame
+func (f *File) startOffset() time.Duration {
+	if f.Start == nil {
+		return 0
+	}
+	return f.Start.offset(f.first)
+}
+
+// Step pauses the replay and releases just the next frame, returning it once it's been read
+func (f *File) Step() (Frame, error) {
+	f.mu.Lock()
+	if f.ended && f.seekTo == nil {
+		f.mu.Unlock()
+		return Frame{}, ErrReplayEnded
+	}
+	if !f.paused {
+		f.anchorMillis, f.anchorAt, f.paused = f.now(), time.Now(), true
+	}
+	if f.step != nil {
+		f.mu.Unlock()
+		return Frame{}, errors.New("already stepping")
+	}
+	step := make(chan Frame, 1)
+	f.step = step
+	f.notify()
+	f.mu.Unlock()
+
+	select {
+	case frame, ok := <-step:
+		if !ok {
+			return Frame{}, ErrReplayEnded
+		}
+		return frame, nil
+	case <-f.closed:
+		return Frame{}, ErrClosed
+	case <-time.After(STEP_TIMEOUT):
+		f.mu.Lock()
+		if f.step == step {
+			f.step = nil
+		}
+		f.mu.Unlock()
+		return Frame{}, errors.New("timed out waiting for the next frame")
+	}
+}
+
+func (f *File) Status() ReplayStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := ReplayStatus{Paths: f.Paths, Playing: !f.paused, Ended: f.ended, Speed: f.speed, Duration: f.duration, First: f.first, Started: f.started}
+	if f.first >= 0 {
+		s.Position = time.Duration(f.position-f.first) * time.Millisecond
+	}
+	return s
+}
+
+// now is the replay clock's millis, called with mu held
+func (f *File) now() int64 {
+	if f.paused {
+		return f.anchorMillis
+	}
+	return f.anchorMillis + int64(float64(time.Since(f.anchorAt).Milliseconds())*f.speed)
+}
+
+// notify wakes ReadFrame to pick up a change, called with mu held
+func (f *File) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// wait blocks until the replay clock reaches millis, or the frame is stepped to, in which
+// case the step waiting for it is returned
+func (f *File) wait(millis int64) (chan<- Frame, error) {
+	for {
+		f.mu.Lock()
+		if f.seekTo != nil {
+			f.mu.Unlock()
+			return nil, errSeeked
+		}
+		if step := f.step; step != nil {
+			f.step, f.position, f.anchorMillis = nil, millis, millis
+			f.mu.Unlock()
+			return step, nil
+		}
+		var due <-chan time.Time
+		if !f.paused {
+			remaining := time.Duration(float64(millis-f.now())/f.speed) * time.Millisecond
+			if remaining <= 0 {
+				f.position = millis
+				f.mu.Unlock()
+				return nil, nil
+			}
+			due = time.After(remaining)
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-due:
+		case <-changed:
+		case <-f.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// holdAtEnd pauses at the end of the log until the replay is seeked or played again
+func (f *File) holdAtEnd() error {
+	f.mu.Lock()
+	f.ended, f.paused = true, true
+	f.anchorMillis = f.position
+	if f.step != nil {
+		// Nothing left to step to
+		close(f.step)
+		f.step = nil
+	}
+	for f.seekTo == nil {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-f.closed:
+			return ErrClosed
+		}
+		f.mu.Lock()
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+// seek reopens the log at the frame being seeked to, if there is one
+func (f *File) seek() error {
+	f.mu.Lock()
+	to := f.seekTo
+	f.mu.Unlock()
+	if to == nil {
+		return nil
+	}
+	if f.first < 0 {
+		// Nothing read yet, the start of the log isn't known
+		frame, err := f.lines.next()
+		if err != nil {
+			return err
+		}
+		f.mu.Lock()
+		f.first = frame.Millis
+		f.mu.Unlock()
+	}
+
+	target := f.first + to.Milliseconds()
+	var r io.ReadCloser
+	var err error
+	if len(f.Paths) == 1 {
+		r, err = rawlog.OpenAt(f.Paths[0], target)
+	} else {
+		// Later logs' millis are offset onto the timeline of the first, so their indexes
+		// don't apply. Read from the start, skipping to the target.
+		r, err = openLogs(f.Paths)
+	}
+	if err != nil {
+		return err
+	}
+	f.log.Close()
+	f.log, f.lines, f.skipUntil = r, newReplayLines(r), target
+
+	f.mu.Lock()
+	rewound := target < f.position
+	f.seekTo, f.ended = nil, false
+	f.anchorMillis, f.anchorAt, f.position = target, time.Now(), target
+	f.mu.Unlock()
+	if rewound && f.OnRewind != nil {
+		f.OnRewind()
+	}
+	return nil
+}
+
+// measure finds how long the logs are, so the dashboard can show how far through them the replay is.
+// The first ride's start is corrected too if its clock was set during it, which is noted later on.
+func (f *File) measure() {
+	r, err := openLogs(f.Paths)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	scanner, millis := bufio.NewScanner(r), timeline.New()
+	first, last := int64(-1), int64(0)
+	var started time.Time
+	starts := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, frames.COMMENT_PREFIX+" start: ") {
+			starts++
+		}
+		if value, ok := strings.CutPrefix(line, frames.COMMENT_PREFIX+" clock: "); ok && starts <= 1 {
+			started, _ = time.Parse(time.RFC3339, value)
+		}
+		frame, err := parseReplayLine(line)
+		if err != nil {
+			continue
+		}
+		at := millis.Next(frame.Millis)
+		if first < 0 {
+			first = at
+		}
+		last = max(last, at)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("measure replay: %v", err)
+		return
+	}
+	f.mu.Lock()
+	f.duration = time.Duration(last-max(first, 0)) * time.Millisecond
+	if !started.IsZero() {
+		f.started = started
+	}
+	f.mu.Unlock()
+}
+
+// readStarted reads when the log at path was started from its start note, if it has one
+func readStarted(path string) time.Time {
+	r, err := openLogs([]string{path})
+	if err != nil {
+		return time.Time{}
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, frames.COMMENT_PREFIX) {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, frames.COMMENT_PREFIX+" start: "); ok {
+			started, _ := time.Parse(time.RFC3339, value)
+			return started
+		}
+	}
+	return time.Time{}
+}
+
+// logs reads several logs one after the other
+type logs struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// openLogs opens the logs at paths to be read as one. A logger restarting between them is
+// handled by the timeline, as if it had restarted partway through one log.
+func openLogs(paths []string) (io.ReadCloser, error) {
+	l := &logs{}
+	var readers []io.Reader
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		r, err := rawlog.NewReader(file)
+		if err != nil {
+			file.Close()
+			l.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		l.closers = append(l.closers, r, file)
+		// A log cut off mid-row shouldn't run into the next one's first
+		readers = append(readers, r, strings.NewReader("\n"))
+	}
+	l.Reader = io.MultiReader(readers...)
+	return l, nil
+}
+
+func (l *logs) Close() error {
+	var errs []error
+	for _, c := range l.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ParseDIDs parses a comma separated list of DIDs, e.g. "0x0100,0x0009"
+func ParseDIDs(s string) (map[uint16]bool, error) {
+	dids := map[uint16]bool{}
+	for _, did := range strings.Split(s, ",") {
+		did = strings.TrimSpace(did)
+		if did == "" {
+			continue
+		}
+		d, err := strconv.ParseUint(did, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DID %q: %w", did, err)
+		}
+		dids[uint16(d)] = true
+	}
+	return dids, nil
+}
+
+// ReplayPaths expands -replay's comma separated list of logs and globs, e.g.
+// "logs/2024-06-01*.husk.zst", into the logs to replay in order. A glob's matches are sorted,
+// which for rides named by when they started is the order they were recorded.
+func ReplayPaths(value string) ([]string, error) {
+	var paths []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.ContainsAny(item, "*?[") {
+			paths = append(paths, item)
+			continue
+		}
+		matches, err := filepath.Glob(item)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no logs match %s", item)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// newReplayLines reads the frames of a log, which can also be CSV written by another tool
+func newReplayLines(r io.Reader) *lineReader {
+	lines := newLineReader(r, timeline.New())
+	lines.parse = parseReplayLine
+	return lines
+}
+
+// parseReplayLine parses the logger's own rows, falling back to other tools' timestamp,DID,payload rows
+func parseReplayLine(line string) (frames.Frame, error) {
+	if frame, err := frames.Parse(line); err == nil {
+		return frame, nil
+	}
+	return frames.ParseCSV(line)
+}
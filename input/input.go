@@ -0,0 +1,25 @@
+package input
+
+import (
+	"errors"
+	"huskki/frames"
+	"time"
+)
+
+// ErrClosed is returned by ReadFrame once a source has been closed
+var ErrClosed = errors.New("input source closed")
+
+// Frame is a single DID reading from the logger. Millis is on a continuous timeline.
+type Frame struct {
+	frames.Frame
+	Received time.Time
+}
+
+// InputSource is anything frames can be read from: a serial port, a recorded log, a network
+// stream, a simulator...
+type InputSource interface {
+	Open() error
+	// ReadFrame blocks until the next frame is available, returning io.EOF once the source is exhausted
+	ReadFrame() (Frame, error)
+	Close() error
+}
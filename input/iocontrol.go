@@ -0,0 +1,210 @@
+package input
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	UDS_SESSION_CONTROL   = 0x10
+	UDS_DEFAULT_SESSION   = 0x01
+	UDS_EXTENDED_SESSION  = 0x03
+	UDS_IO_CONTROL        = 0x2F
+	UDS_TESTER_PRESENT    = 0x3E
+	UDS_NEGATIVE_RESPONSE = 0x7F
+	// Negative response code for the ECU still working on a request, to keep waiting
+	UDS_RESPONSE_PENDING = 0x78
+	// Added to a service ID for its positive response
+	UDS_POSITIVE_OFFSET = 0x40
+	// InputOutputControlByIdentifier parameters: hand the output back, or hold it at a state
+	IO_RETURN_CONTROL    = 0x00
+	IO_SHORT_TERM_ADJUST = 0x03
+	UDS_RESPONSE_TIMEOUT = 2 * time.Second
+	// How often the session is kept open while an output is held, well within the ECU's
+	// S3 timeout of 5s after which it drops back to the default session and takes the output back
+	TESTER_PRESENT_INTERVAL = 2 * time.Second
+	// The ECU's responses come from the request ID + 8, e.g. 0x7E8 for 0x7E0
+	UDS_RESPONSE_ID_OFFSET = 8
+)
+
+// Reasons the ECU gives for refusing a request, from ISO 14229
+var negativeResponses = map[byte]string{
+	0x11: "service not supported",
+	0x12: "sub-function not supported",
+	0x13: "invalid length",
+	0x22: "conditions not correct",
+	0x31: "request out of range",
+	0x33: "security access denied",
+	0x7E: "not supported in the active session",
+	0x7F: "service not supported in the active session",
+}
+
+// IOController is a source that can take over the ECU's outputs, e.g. the cooling fan, with
+// UDS InputOutputControlByIdentifier
+type IOController interface {
+	// HoldOutput sets the output at did to state until it's released. If huskki goes away the
+	// ECU takes it back by itself once the diagnostic session lapses.
+	HoldOutput(did uint16, state []byte) error
+	// ReleaseOutput hands the output back to the ECU
+	ReleaseOutput(did uint16) error
+}
+
+// udsClient makes single frame UDS requests to the ECU and waits for its responses, which the
+// source hands it as they're read. Responses to ReadDataByIdentifier aren't taken, so polled
+// DIDs carry on being decoded as frames.
+type udsClient struct {
+	send      func(id uint32, data []byte) error
+	requestID uint32
+
+	request sync.Mutex // one request awaiting a response at a time
+	mu      sync.Mutex
+	waiting chan []byte
+	held    map[uint16]bool
+	stop    chan struct{} // closed to stop keeping the session open
+}
+
+func newUDSClient(send func(id uint32, data []byte) error, requestID uint32) *udsClient {
+	return &udsClient{send: send, requestID: requestID, held: map[uint16]bool{}}
+}
+
+// deliver hands over a frame from the bus, reporting whether it was the response awaited
+func (c *udsClient) deliver(id uint32, data []byte) bool {
+	if id != c.requestID+UDS_RESPONSE_ID_OFFSET || len(data) < 2 || data[0]>>4 != 0 {
+		return false
+	}
+	length := int(data[0] & 0x0F)
+	if length == 0 || length >= len(data) || data[1] == UDS_READ_DID_RESPONSE {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.waiting == nil {
+		return false
+	}
+	select {
+	case c.waiting <- append([]byte(nil), data[1:1+length]...):
+	default:
+	}
+	return true
+}
+
+// do sends a request and waits for the ECU's positive response, a negative one is an error
+func (c *udsClient) do(request []byte) ([]byte, error) {
+	c.request.Lock()
+	defer c.request.Unlock()
+
+	waiting := make(chan []byte, 4)
+	c.mu.Lock()
+	c.waiting = waiting
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.waiting = nil
+		c.mu.Unlock()
+	}()
+
+	if err := c.sendSingle(request); err != nil {
+		return nil, err
+	}
+	service := request[0]
+	deadline := time.NewTimer(UDS_RESPONSE_TIMEOUT)
+	defer deadline.Stop()
+	for {
+		select {
+		case response := <-waiting:
+			switch {
+			case response[0] == service+UDS_POSITIVE_OFFSET:
+				return response, nil
+			case response[0] != UDS_NEGATIVE_RESPONSE || len(response) < 3 || response[1] != service:
+				continue
+			case response[2] == UDS_RESPONSE_PENDING:
+				deadline.Reset(UDS_RESPONSE_TIMEOUT)
+				continue
+			}
+			reason, ok := negativeResponses[response[2]]
+			if !ok {
+				reason = "unknown reason"
+			}
+			return response, fmt.Errorf("ECU refused service 0x%02X: %s (0x%02X)", service, reason, response[2])
+		case <-deadline.C:
+			return nil, fmt.Errorf("no response to service 0x%02X from the ECU", service)
+		}
+	}
+}
+
+// sendSingle sends a request as an ISO-TP single frame, padded to 8 bytes
+func (c *udsClient) sendSingle(request []byte) error {
+	if len(request) > 7 {
+		return fmt.Errorf("UDS request too long for a single frame: %d bytes", len(request))
+	}
+	frame := make([]byte, 8)
+	frame[0] = byte(len(request))
+	copy(frame[1:], request)
+	return c.send(c.requestID, frame)
+}
+
+func (c *udsClient) HoldOutput(did uint16, state []byte) error {
+	if len(state) > 3 {
+		return fmt.Errorf("output state too long: %d bytes, at most 3", len(state))
+	}
+	if _, err := c.do([]byte{UDS_SESSION_CONTROL, UDS_EXTENDED_SESSION}); err != nil {
+		return fmt.Errorf("extended session: %w", err)
+	}
+	request := append([]byte{UDS_IO_CONTROL, byte(did >> 8), byte(did), IO_SHORT_TERM_ADJUST}, state...)
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("control output 0x%04X: %w", did, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held[did] = true
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+		go c.keepSession(c.stop)
+	}
+	return nil
+}
+
+func (c *udsClient) ReleaseOutput(did uint16) error {
+	_, err := c.do([]byte{UDS_IO_CONTROL, byte(did >> 8), byte(did), IO_RETURN_CONTROL})
+
+	c.mu.Lock()
+	delete(c.held, did)
+	last := len(c.held) == 0 && c.stop != nil
+	if last {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.mu.Unlock()
+
+	// Nothing's held any more, so back to the default session. The ECU would drop back by
+	// itself shortly, and takes every output back as it does.
+	if last {
+		if _, serr := c.do([]byte{UDS_SESSION_CONTROL, UDS_DEFAULT_SESSION}); err == nil && serr != nil {
+			err = fmt.Errorf("default session: %w", serr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("release output 0x%04X: %w", did, err)
+	}
+	return nil
+}
+
+// keepSession sends TesterPresent, without asking for a response, until stop is closed
+func (c *udsClient) keepSession(stop <-chan struct{}) {
+	ticker := time.NewTicker(TESTER_PRESENT_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// 0x80 suppresses the positive response
+			if err := c.sendSingle([]byte{UDS_TESTER_PRESENT, 0x80}); err != nil {
+				log.Printf("tester present: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package input
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fakeECU answers UDS requests as an ECU would, refusing IO control unless refuse is 0
+type fakeECU struct {
+	client   *udsClient
+	requests [][]byte
+	refuse   byte
+}
+
+func (e *fakeECU) send(id uint32, frame []byte) error {
+	request := frame[1 : 1+frame[0]]
+	e.requests = append(e.requests, request)
+	response := []byte{request[0] + UDS_POSITIVE_OFFSET}
+	switch {
+	case request[0] == UDS_TESTER_PRESENT:
+		return nil
+	case request[0] == UDS_IO_CONTROL && e.refuse != 0:
+		// still working on it, then refused
+		e.respond(id, []byte{UDS_NEGATIVE_RESPONSE, request[0], UDS_RESPONSE_PENDING})
+		response = []byte{UDS_NEGATIVE_RESPONSE, request[0], e.refuse}
+	}
+	e.respond(id, append(response, request[1:]...))
+	return nil
+}
+
+func (e *fakeECU) respond(id uint32, response []byte) {
+	frame := make([]byte, 8)
+	frame[0] = byte(min(len(response), 7))
+	copy(frame[1:], response)
+	// Responses arrive from the bus while the request is awaited
+	go e.client.deliver(id+UDS_RESPONSE_ID_OFFSET, frame)
+}
+
+func TestHoldOutput(t *testing.T) {
+	ecu := &fakeECU{}
+	ecu.client = newUDSClient(ecu.send, UDS_REQUEST_ID)
+	if err := ecu.client.HoldOutput(0x0D31, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ecu.client.ReleaseOutput(0x0D31); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{
+		{UDS_SESSION_CONTROL, UDS_EXTENDED_SESSION},
+		{UDS_IO_CONTROL, 0x0D, 0x31, IO_SHORT_TERM_ADJUST, 1},
+		{UDS_IO_CONTROL, 0x0D, 0x31, IO_RETURN_CONTROL},
+		{UDS_SESSION_CONTROL, UDS_DEFAULT_SESSION},
+	}
+	if len(ecu.requests) != len(want) {
+		t.Fatalf("sent % X, want % X", ecu.requests, want)
+	}
+	for i := range want {
+		if !bytes.Equal(ecu.requests[i], want[i]) {
+			t.Errorf("request %d was % X, want % X", i, ecu.requests[i], want[i])
+		}
+	}
+}
+
+func TestHoldOutputRefused(t *testing.T) {
+	ecu := &fakeECU{refuse: 0x33}
+	ecu.client = newUDSClient(ecu.send, UDS_REQUEST_ID)
+	err := ecu.client.HoldOutput(0x0D31, []byte{1})
+	if err == nil || !strings.Contains(err.Error(), "security access denied") {
+		t.Errorf("HoldOutput refused with %v, want security access denied", err)
+	}
+}
+
+// Responses to polled DIDs are decoded as frames, not taken as the response awaited
+func TestDeliverLeavesReadResponses(t *testing.T) {
+	client := newUDSClient(nil, UDS_REQUEST_ID)
+	client.waiting = make(chan []byte, 1)
+	if client.deliver(UDS_REQUEST_ID+UDS_RESPONSE_ID_OFFSET, []byte{5, UDS_READ_DID_RESPONSE, 0x01, 0x00, 0x0B, 0xB8, 0, 0}) {
+		t.Error("a ReadDataByIdentifier response was taken")
+	}
+}
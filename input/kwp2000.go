@@ -0,0 +1,200 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"log"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	KWP2000_BAUD_RATE = 10400
+	DEFAULT_KWP_ECU   = 0x11
+	KWP_TESTER        = 0xF1
+
+	KWP_START_COMMUNICATION = 0x81
+	KWP_TESTER_PRESENT      = 0x3E
+	KWP_READ_DID            = 0x22
+	KWP_NEGATIVE_RESPONSE   = 0x7F
+	KWP_POSITIVE_OFFSET     = 0x40
+
+	// fast init: the line is held low then high for 25ms each before StartCommunication
+	KWP_INIT_PULSE = 25 * time.Millisecond
+	// how long to wait for a response (P2max plus some slack for USB adapters)
+	KWP_TIMEOUT = time.Second
+	// the ECU drops the session after 5s (P3max) without a request
+	KWP_KEEPALIVE = 2 * time.Second
+)
+
+var errKWPTimeout = errors.New("timed out waiting for the ECU")
+
+// KWP2000 talks to older, pre-CAN ECUs over K-line through a KKL (e.g. FTDI) adapter, using
+// fast init and ReadDataByCommonIdentifier. Each DID is requested at its own interval. Port
+// may be "auto" as for Serial.
+// Millis are counted from when the source was first opened.
+type KWP2000 struct {
+	Port string
+	ECU  byte
+	DIDs []PollDID
+
+	port  serial.Port
+	start time.Time
+	due   []time.Time
+}
+
+func (k *KWP2000) Open() error {
+	if len(k.DIDs) == 0 {
+		return errors.New("kwp2000: no DIDs to poll")
+	}
+	name := k.Port
+	if name == "auto" {
+		var err error
+		if name, _, err = autoSelectPort(); err != nil {
+			return fmt.Errorf("auto-select: %w", err)
+		}
+	}
+	port, err := serial.Open(name, &serial.Mode{BaudRate: KWP2000_BAUD_RATE})
+	if err != nil {
+		return fmt.Errorf("open k-line %s: %w", name, err)
+	}
+	if err := port.SetReadTimeout(50 * time.Millisecond); err != nil {
+		port.Close()
+		return err
+	}
+	k.port = port
+
+	if err := port.Break(KWP_INIT_PULSE); err != nil {
+		port.Close()
+		return fmt.Errorf("kwp2000 fast init: %w", err)
+	}
+	time.Sleep(KWP_INIT_PULSE)
+	resp, err := k.request(KWP_START_COMMUNICATION)
+	if err != nil {
+		port.Close()
+		return fmt.Errorf("kwp2000 start communication: %w", err)
+	}
+	if resp[0] != KWP_START_COMMUNICATION+KWP_POSITIVE_OFFSET {
+		port.Close()
+		return fmt.Errorf("kwp2000 start communication refused: % X", resp)
+	}
+	log.Printf("Connected to ECU 0x%02X over K-line on %s", k.ECU, name)
+
+	if k.start.IsZero() {
+		k.start = time.Now()
+	}
+	k.due = make([]time.Time, len(k.DIDs))
+	return nil
+}
+
+// ReadFrame requests whichever DID is next due, keeping the session alive while waiting
+func (k *KWP2000) ReadFrame() (Frame, error) {
+	for {
+		next := 0
+		for i := range k.due {
+			if k.due[i].Before(k.due[next]) {
+				next = i
+			}
+		}
+		if wait := time.Until(k.due[next]); wait > KWP_KEEPALIVE {
+			time.Sleep(KWP_KEEPALIVE)
+			if _, err := k.request(KWP_TESTER_PRESENT, 0x01); err != nil {
+				return Frame{}, err
+			}
+			continue
+		} else if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		poll := k.DIDs[next]
+		k.due[next] = time.Now().Add(poll.Interval)
+		resp, err := k.request(KWP_READ_DID, byte(poll.DID>>8), byte(poll.DID))
+		if err != nil {
+			return Frame{}, err
+		}
+		received := time.Now()
+		if len(resp) < 4 || resp[0] != KWP_READ_DID+KWP_POSITIVE_OFFSET || uint16(resp[1])<<8|uint16(resp[2]) != poll.DID {
+			// negative responses (e.g. the DID isn't supported) are skipped
+			continue
+		}
+		return Frame{
+			Frame:    frames.Frame{Millis: received.Sub(k.start).Milliseconds(), DID: poll.DID, Data: resp[3:]},
+			Received: received,
+		}, nil
+	}
+}
+
+func (k *KWP2000) Close() error {
+	if k.port == nil {
+		return nil
+	}
+	return k.port.Close()
+}
+
+// request sends a service request to the ECU and returns the response's data bytes
+func (k *KWP2000) request(data ...byte) ([]byte, error) {
+	msg := append([]byte{0x80 | byte(len(data)), k.ECU, KWP_TESTER}, data...)
+	msg = append(msg, kwpChecksum(msg))
+	if _, err := k.port.Write(msg); err != nil {
+		return nil, err
+	}
+	// K-line is a single wire, so the adapter echoes everything we send
+	if _, err := k.readFull(len(msg)); err != nil {
+		return nil, err
+	}
+
+	for {
+		header, err := k.readFull(3)
+		if err != nil {
+			return nil, err
+		}
+		length := int(header[0] & 0x3F)
+		if length == 0 {
+			b, err := k.readFull(1)
+			if err != nil {
+				return nil, err
+			}
+			header, length = append(header, b[0]), int(b[0])
+		}
+		body, err := k.readFull(length + 1)
+		if err != nil {
+			return nil, err
+		}
+		if kwpChecksum(append(header, body[:length]...)) != body[length] {
+			return nil, errors.New("kwp2000: bad checksum")
+		}
+		resp := body[:length]
+		// 0x78 is "response pending", the real response follows
+		if len(resp) >= 3 && resp[0] == KWP_NEGATIVE_RESPONSE && resp[2] == 0x78 {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func (k *KWP2000) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read := 0
+	for deadline := time.Now().Add(KWP_TIMEOUT); read < n; {
+		if time.Now().After(deadline) {
+			return nil, errKWPTimeout
+		}
+		m, err := k.port.Read(buf[read:])
+		if err != nil {
+			return nil, err
+		}
+		read += m
+	}
+	return buf, nil
+}
+
+// kwpChecksum is the 8-bit sum of the message
+func kwpChecksum(msg []byte) byte {
+	var sum byte
+	for _, b := range msg {
+		sum += b
+	}
+	return sum
+}
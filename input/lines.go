@@ -0,0 +1,145 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"huskki/timeline"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CRCFailures counts the rows line sources have skipped for a missing or mismatched CRC
+var CRCFailures atomic.Uint64
+
+// lineReader reads the logger's CSV rows from a stream, skipping anything that isn't a frame
+type lineReader struct {
+	scanner *bufio.Scanner
+	millis  *timeline.Timeline
+	replies *replies                           // optional, for sources that send commands
+	parse   func(string) (frames.Frame, error) // frames.Parse if nil
+	// onBoot, if set, is called in its own goroutine on the first frame read and whenever the
+	// logger resets, i.e. once it's up and listening for commands
+	onBoot func()
+	booted bool
+}
+
+func newLineReader(r io.Reader, millis *timeline.Timeline) *lineReader {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+	return &lineReader{scanner: scanner, millis: millis}
+}
+
+func (l *lineReader) next() (Frame, error) {
+	for l.scanner.Scan() {
+		received := time.Now()
+		line := strings.TrimSpace(l.scanner.Text())
+
+		// Replies to commands we've sent
+		if strings.HasPrefix(line, "$") {
+			if cmd, err := frames.ParseCommand(line); err != nil {
+				log.Printf("logger reply: %v", err)
+			} else {
+				log.Printf("logger replied %s %s", cmd.Name, strings.Join(cmd.Args, ","))
+				if l.replies != nil {
+					l.replies.deliver(cmd)
+				}
+			}
+			continue
+		}
+
+		parse := l.parse
+		if parse == nil {
+			parse = frames.Parse
+		}
+		frame, err := parse(line)
+		if err != nil {
+			if errors.Is(err, frames.ErrCRC) {
+				CRCFailures.Add(1)
+			}
+			continue
+		}
+		resets := l.millis.Resets()
+		frame = onTimeline(l.millis, frame)
+		if l.onBoot != nil && (!l.booted || l.millis.Resets() != resets) {
+			l.booted = true
+			go l.onBoot()
+		}
+		return Frame{Frame: frame, Received: received}, nil
+	}
+	if err := l.scanner.Err(); err != nil {
+		return Frame{}, err
+	}
+	return Frame{}, io.EOF
+}
+
+// onTimeline moves frame onto the continuous timeline, its micros along with its millis so a
+// v2 row written from it agrees with the millis it's indexed at
+func onTimeline(millis *timeline.Timeline, frame frames.Frame) frames.Frame {
+	at := millis.Next(frame.Millis)
+	if frame.Micros != 0 {
+		frame.Micros += (at - frame.Millis) * 1000
+	}
+	frame.Millis = at
+	return frame
+}
+
+// replies hands the logger's replies over to a command waiting on one
+type replies struct {
+	request sync.Mutex // one command awaiting a reply at a time
+	mu      sync.Mutex
+	waiting chan frames.Command
+}
+
+func (r *replies) deliver(cmd frames.Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiting != nil {
+		select {
+		case r.waiting <- cmd:
+		default:
+		}
+	}
+}
+
+// await sends cmd and waits for the logger's reply to it: a command of the same name, or an
+// ACK/NACK naming it. A NACK is returned as an error.
+func (r *replies) await(send func(frames.Command) error, cmd frames.Command, timeout time.Duration) (frames.Command, error) {
+	r.request.Lock()
+	defer r.request.Unlock()
+
+	waiting := make(chan frames.Command, 4)
+	r.mu.Lock()
+	r.waiting = waiting
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.waiting = nil
+		r.mu.Unlock()
+	}()
+
+	if err := send(cmd); err != nil {
+		return frames.Command{}, err
+	}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case reply := <-waiting:
+			acked := len(reply.Args) > 0 && reply.Args[0] == cmd.Name
+			switch {
+			case reply.Name == frames.NACK_COMMAND && acked:
+				return reply, fmt.Errorf("logger refused %s: %s", cmd.Name, strings.Join(reply.Args[1:], ","))
+			case reply.Name == cmd.Name, reply.Name == frames.ACK_COMMAND && acked:
+				return reply, nil
+			}
+		case <-deadline:
+			return frames.Command{}, fmt.Errorf("no reply to %s from the logger", cmd.Name)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"huskki/frames"
+	"huskki/rawlog"
+	"huskki/timeline"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordAcrossReset reads rows across a logger reset into a raw log, and checks the log
+// can be opened at a millis after the reset, for v1 rows and v2 rows with finer timestamps
+func TestRecordAcrossReset(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		encode func(frames.Frame) string
+	}{
+		{"v1", frames.Frame.String},
+		{"v2", func(f frames.Frame) string {
+			f.Micros, f.CANID = f.Millis*1000+250, 0x7E8
+			return f.V2()
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// 50 and 60 are after the logger reset, so carry on from 6000
+			var rows strings.Builder
+			for _, millis := range []int64{5000, 6000, 50, 60} {
+				rows.WriteString(tt.encode(frames.Frame{Millis: millis, DID: frames.RPM_DID, Data: []byte{0, 0}}) + "\n")
+			}
+
+			path := filepath.Join(t.TempDir(), "ride.husk")
+			log, err := rawlog.Open(path, rawlog.NONE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := log.IndexEvery(1); err != nil {
+				t.Fatal(err)
+			}
+			reader := newLineReader(strings.NewReader(rows.String()), timeline.New())
+			for {
+				frame, err := reader.next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				log.Write(frame.Frame)
+			}
+			if err := log.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := rawlog.OpenAt(path, 6005)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			var got []int64
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				if frame, err := frames.Parse(scanner.Text()); err == nil {
+					got = append(got, frame.Millis)
+				}
+			}
+			if len(got) != 2 || got[0] != 6000 || got[1] != 6010 {
+				t.Errorf("OpenAt(6005) read frames at %v, want [6000 6010]", got)
+			}
+		})
+	}
+}
+
+func TestOnBoot(t *testing.T) {
+	rows := "5000,0x0100,00 00\n6000,0x0100,00 00\n50,0x0100,00 00\n60,0x0100,00 00\n"
+	reader := newLineReader(strings.NewReader(rows), timeline.New())
+	booted := make(chan struct{}, 4)
+	reader.onBoot = func() { booted <- struct{}{} }
+	for {
+		if _, err := reader.next(); err != nil {
+			break
+		}
+	}
+	// Once for the first frame, once for the reset
+	for range 2 {
+		select {
+		case <-booted:
+		case <-time.After(time.Second):
+			t.Fatal("onBoot wasn't called on the first frame and the reset")
+		}
+	}
+	select {
+	case <-booted:
+		t.Error("onBoot called more than twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
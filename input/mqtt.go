@@ -0,0 +1,165 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"huskki/timeline"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	MQTT_CONNECT_TIMEOUT = 10 * time.Second
+	// messages buffered between the client and ReadFrame before they're dropped
+	MQTT_QUEUE_SIZE = 256
+)
+
+// MQTT subscribes to a broker topic, for a logger on the bike publishing over cellular. The
+// URL is mqtt[s]://[user:pass@]host[:port]/topic. Each message is either log rows, as the
+// logger writes them to serial, or a decoded JSON object of channel values, e.g.
+// {"rpm": 4000, "coolant": 85, "timestamp": 1234}, which is re-encoded as DIDs. The client
+// reconnects by itself; OnLink, if set, is called as the broker connection comes and goes.
+type MQTT struct {
+	URL    string
+	OnLink func(up bool)
+
+	client   mqtt.Client
+	topic    string
+	messages chan []byte
+	done     chan struct{}
+	pending  []Frame
+	millis   *timeline.Timeline
+	start    time.Time
+}
+
+func (m *MQTT) Open() error {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return fmt.Errorf("mqtt url: %w", err)
+	}
+	m.topic = strings.TrimPrefix(u.Path, "/")
+	if m.topic == "" {
+		return fmt.Errorf("mqtt url %s has no topic", m.URL)
+	}
+	scheme := "tcp"
+	if u.Scheme == "mqtts" || u.Scheme == "ssl" {
+		scheme = "ssl"
+	}
+	m.messages = make(chan []byte, MQTT_QUEUE_SIZE)
+	m.done = make(chan struct{})
+	m.millis, m.start = timeline.New(), time.Now()
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(fmt.Sprintf("%s://%s", scheme, u.Host)).
+		SetClientID(fmt.Sprintf("huskki-%d", time.Now().UnixNano())).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// (re)subscribe on every connect, the session isn't persisted
+			token := c.Subscribe(m.topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+				select {
+				case m.messages <- msg.Payload():
+				default:
+				}
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Printf("mqtt subscribe %s: %v", m.topic, token.Error())
+				return
+			}
+			log.Printf("Subscribed to %s on %s", m.topic, u.Host)
+			m.setLink(true)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt connection lost: %v", err)
+			m.setLink(false)
+		})
+	if u.User != nil {
+		opts.SetUsername(u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			opts.SetPassword(password)
+		}
+	}
+
+	m.client = mqtt.NewClient(opts)
+	m.setLink(false)
+	// with ConnectRetry the client keeps trying in the background if the broker is unreachable
+	if token := m.client.Connect(); token.WaitTimeout(MQTT_CONNECT_TIMEOUT) && token.Error() != nil {
+		return fmt.Errorf("mqtt connect %s: %w", u.Host, token.Error())
+	}
+	return nil
+}
+
+func (m *MQTT) ReadFrame() (Frame, error) {
+	for len(m.pending) == 0 {
+		select {
+		case <-m.done:
+			return Frame{}, ErrClosed
+		case payload := <-m.messages:
+			m.pending = m.decode(payload, time.Now())
+		}
+	}
+	frame := m.pending[0]
+	m.pending = m.pending[1:]
+	return frame, nil
+}
+
+func (m *MQTT) Close() error {
+	if m.client == nil {
+		return nil
+	}
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+	m.client.Disconnect(250)
+	return nil
+}
+
+// decode turns a message into frames, whether it carries log rows or JSON
+func (m *MQTT) decode(payload []byte, received time.Time) []Frame {
+	var out []Frame
+	if trimmed := bytes.TrimSpace(payload); len(trimmed) > 0 && trimmed[0] == '{' {
+		var values map[string]float64
+		if err := json.Unmarshal(trimmed, &values); err != nil {
+			log.Printf("mqtt: %v", err)
+			return nil
+		}
+		millis := received.Sub(m.start).Milliseconds()
+		if ts, ok := values["timestamp"]; ok {
+			millis = int64(ts)
+		}
+		millis = m.millis.Next(millis)
+		for channel, value := range values {
+			did, data, ok := frames.Encode(channel, value)
+			if !ok {
+				continue
+			}
+			out = append(out, Frame{Frame: frames.Frame{Millis: millis, DID: did, Data: data}, Received: received})
+		}
+		return out
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(payload))
+	for scanner.Scan() {
+		frame, err := frames.Parse(scanner.Text())
+		if err != nil {
+			continue
+		}
+		out = append(out, Frame{Frame: onTimeline(m.millis, frame), Received: received})
+	}
+	return out
+}
+
+func (m *MQTT) setLink(up bool) {
+	if m.OnLink != nil {
+		m.OnLink(up)
+	}
+}
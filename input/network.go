@@ -0,0 +1,143 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"huskki/timeline"
+	"log"
+	"net"
+)
+
+// Dial reads frames from a bridge (e.g. an ESP32 forwarding the logger's serial stream over
+// WiFi) by connecting out to it over TCP. Wrap it in Reconnecting to survive dropouts.
+type Dial struct {
+	Addr string
+
+	conn   net.Conn
+	lines  *lineReader
+	millis *timeline.Timeline
+}
+
+func (d *Dial) Open() error {
+	conn, err := net.Dial("tcp", d.Addr)
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", d.Addr, err)
+	}
+	log.Printf("Connected to %s", d.Addr)
+
+	if d.millis == nil {
+		d.millis = timeline.New()
+	}
+	d.conn, d.lines = conn, newLineReader(conn, d.millis)
+	return nil
+}
+
+func (d *Dial) ReadFrame() (Frame, error) {
+	return d.lines.next()
+}
+
+func (d *Dial) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+// Listener accepts a bridge connecting in over TCP, one at a time. When the bridge disconnects
+// it waits for the next one, so it doesn't need wrapping in Reconnecting. OnLink, if set, is
+// called whenever a bridge connects or disconnects.
+type Listener struct {
+	Addr   string
+	OnLink func(up bool)
+
+	listener net.Listener
+	conn     net.Conn
+	lines    *lineReader
+	millis   *timeline.Timeline
+}
+
+func (l *Listener) Open() error {
+	listener, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", l.Addr, err)
+	}
+	log.Printf("Waiting for logger on tcp %s", listener.Addr())
+	l.listener, l.millis = listener, timeline.New()
+	l.setLink(false)
+	return nil
+}
+
+func (l *Listener) ReadFrame() (Frame, error) {
+	for {
+		if l.conn == nil {
+			conn, err := l.listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return Frame{}, ErrClosed
+			}
+			if err != nil {
+				return Frame{}, err
+			}
+			log.Printf("Logger connected from %s", conn.RemoteAddr())
+			l.conn, l.lines = conn, newLineReader(conn, l.millis)
+			l.setLink(true)
+		}
+
+		frame, err := l.lines.next()
+		if err == nil {
+			return frame, nil
+		}
+		log.Printf("logger %s disconnected: %v", l.conn.RemoteAddr(), err)
+		l.conn.Close()
+		l.conn = nil
+		l.setLink(false)
+	}
+}
+
+func (l *Listener) Close() error {
+	if l.listener == nil {
+		return nil
+	}
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	return l.listener.Close()
+}
+
+func (l *Listener) setLink(up bool) {
+	if l.OnLink != nil {
+		l.OnLink(up)
+	}
+}
+
+// Datagram reads frames sent to a UDP port. Rows may be split across or batched into datagrams.
+type Datagram struct {
+	Addr string
+
+	conn  net.PacketConn
+	lines *lineReader
+}
+
+func (d *Datagram) Open() error {
+	conn, err := net.ListenPacket("udp", d.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", d.Addr, err)
+	}
+	log.Printf("Listening for logger on udp %s", conn.LocalAddr())
+	d.conn, d.lines = conn, newLineReader(conn.(net.Conn), timeline.New())
+	return nil
+}
+
+func (d *Datagram) ReadFrame() (Frame, error) {
+	frame, err := d.lines.next()
+	if errors.Is(err, net.ErrClosed) {
+		return Frame{}, ErrClosed
+	}
+	return frame, err
+}
+
+func (d *Datagram) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
@@ -0,0 +1,149 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// PortMatch picks out a USB serial device for auto-select. Empty fields match anything;
+// Product matches case-insensitively anywhere in the OS's description of the port.
+type PortMatch struct {
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string
+}
+
+// PreferredPorts are tried in order when auto-selecting a port, defaulting to Arduino & clones
+// common VIDs
+var PreferredPorts = []PortMatch{
+	{VID: "2341"}, // Arduino
+	{VID: "2A03"}, // Arduino (older)
+	{VID: "1A86"}, // CH340
+	{VID: "10C4"}, // CP210x
+	{VID: "0403"}, // FTDI
+}
+
+func (m PortMatch) matches(p *enumerator.PortDetails) bool {
+	if !p.IsUSB {
+		return false
+	}
+	if m.VID != "" && !strings.EqualFold(m.VID, p.VID) {
+		return false
+	}
+	if m.PID != "" && !strings.EqualFold(m.PID, p.PID) {
+		return false
+	}
+	if m.SerialNumber != "" && m.SerialNumber != p.SerialNumber {
+		return false
+	}
+	if m.Product != "" && !strings.Contains(strings.ToLower(p.Product), strings.ToLower(m.Product)) {
+		return false
+	}
+	return true
+}
+
+// ParsePortMatches parses a comma separated list of port matches, most preferred first. Each is
+// a VID, VID:PID, or key=value terms joined by '+', keys being vid, pid, serial and product,
+// e.g. "16C0:0483,serial=A1B2C3,vid=2E8A+product=pico"
+func ParsePortMatches(s string) ([]PortMatch, error) {
+	var matches []PortMatch
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		m, err := parsePortMatch(rule)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// LoadPortMatches reads port matches from a file, one per line in the ParsePortMatches syntax.
+// Blank lines and lines starting with # are skipped.
+func LoadPortMatches(path string) ([]PortMatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var matches []PortMatch
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parsePortMatch(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		matches = append(matches, m)
+	}
+	return matches, scanner.Err()
+}
+
+func parsePortMatch(rule string) (PortMatch, error) {
+	var m PortMatch
+	if !strings.Contains(rule, "=") {
+		m.VID, m.PID, _ = strings.Cut(rule, ":")
+		return m, nil
+	}
+	for _, term := range strings.Split(rule, "+") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok || value == "" {
+			return m, fmt.Errorf("invalid port match %q, expected key=value", term)
+		}
+		switch strings.ToLower(key) {
+		case "vid":
+			m.VID = value
+		case "pid":
+			m.PID = value
+		case "serial":
+			m.SerialNumber = value
+		case "product":
+			m.Product = value
+		default:
+			return m, fmt.Errorf("invalid port match key %q, expected vid, pid, serial or product", key)
+		}
+	}
+	return m, nil
+}
+
+// autoSelectPort picks the most Arduino-like port, reporting whether it matched PreferredPorts
+func autoSelectPort() (string, bool, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return "", false, fmt.Errorf("enumerate ports: %w", err)
+	}
+	for _, m := range PreferredPorts {
+		for _, p := range ports {
+			if m.matches(p) {
+				return p.Name, true, nil
+			}
+		}
+	}
+	for _, p := range ports {
+		if p.IsUSB {
+			return p.Name, false, nil
+		}
+	}
+	if len(ports) > 0 {
+		return ports[0].Name, false, nil
+	}
+	return "", false, fmt.Errorf("no serial ports found")
+}
+
+// preferredPort returns a connected device matching PreferredPorts, if there is one
+func preferredPort() (string, bool) {
+	name, preferred, err := autoSelectPort()
+	return name, err == nil && preferred
+}
@@ -0,0 +1,101 @@
+package input
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	MIN_RECONNECT_BACKOFF = 500 * time.Millisecond
+	MAX_RECONNECT_BACKOFF = 30 * time.Second
+)
+
+// Reconnecting wraps a live source (serial, network...) so that a lost connection is reopened
+// with exponential backoff rather than ending the stream. OnLink, if set, is called with the
+// connection state whenever it changes.
+type Reconnecting struct {
+	Source InputSource
+	OnLink func(up bool)
+
+	connected bool
+	done      chan struct{}
+}
+
+// Waiter is a source that can tell when reopening is worth trying, e.g. a device being plugged
+// in, rather than only retrying on a timer
+type Waiter interface {
+	// WaitReady returns once the source looks ready to open, or after timeout at most
+	WaitReady(done <-chan struct{}, timeout time.Duration)
+}
+
+// Open makes a first attempt to connect. Failing that, ReadFrame keeps retrying.
+func (r *Reconnecting) Open() error {
+	r.done = make(chan struct{})
+	if err := r.Source.Open(); err != nil {
+		log.Printf("input unavailable, will retry: %v", err)
+		r.setLink(false)
+		return nil
+	}
+	r.connected = true
+	r.setLink(true)
+	return nil
+}
+
+func (r *Reconnecting) ReadFrame() (Frame, error) {
+	for {
+		if !r.connected && !r.reconnect() {
+			return Frame{}, ErrClosed
+		}
+		frame, err := r.Source.ReadFrame()
+		if err == nil {
+			return frame, nil
+		}
+		log.Printf("input lost: %v", err)
+		r.Source.Close()
+		r.connected = false
+		r.setLink(false)
+	}
+}
+
+func (r *Reconnecting) Close() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	return r.Source.Close()
+}
+
+// reconnect retries opening the source until it succeeds, or returns false if closed first
+func (r *Reconnecting) reconnect() bool {
+	backoff := MIN_RECONNECT_BACKOFF
+	for {
+		if waiter, ok := r.Source.(Waiter); ok {
+			waiter.WaitReady(r.done, backoff)
+		} else {
+			select {
+			case <-r.done:
+			case <-time.After(backoff):
+			}
+		}
+		select {
+		case <-r.done:
+			return false
+		default:
+		}
+		if err := r.Source.Open(); err != nil {
+			log.Printf("reconnect failed, retrying in %s: %v", min(backoff*2, MAX_RECONNECT_BACKOFF), err)
+			backoff = min(backoff*2, MAX_RECONNECT_BACKOFF)
+			continue
+		}
+		r.connected = true
+		r.setLink(true)
+		return true
+	}
+}
+
+func (r *Reconnecting) setLink(up bool) {
+	if r.OnLink != nil {
+		r.OnLink(up)
+	}
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package input
+
+import (
+	"fmt"
+	"huskki/dtc"
+	"huskki/frames"
+	"huskki/timeline"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// RFCOMM reads frames from a Bluetooth serial (SPP) dongle by its address, e.g.
+// AA:BB:CC:DD:EE:FF, without binding it to an /dev/rfcomm device first. The dongle must
+// already be paired.
+type RFCOMM struct {
+	Addr    string
+	Channel int
+	// OnBoot, if set, is called once the logger is up after each connect and after it resets
+	OnBoot func()
+
+	mu      sync.Mutex // guards file for Send
+	file    *os.File
+	lines   *lineReader
+	millis  *timeline.Timeline
+	replies replies
+}
+
+func (b *RFCOMM) Open() error {
+	addr, err := parseBluetoothAddr(b.Addr)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM, unix.BTPROTO_RFCOMM)
+	if err != nil {
+		return fmt.Errorf("rfcomm socket: %w", err)
+	}
+	// the address is little-endian on the wire
+	var sa unix.SockaddrRFCOMM
+	for i := range addr {
+		sa.Addr[i] = addr[len(addr)-1-i]
+	}
+	sa.Channel = uint8(b.Channel)
+	if err := unix.Connect(fd, &sa); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("connect %s: %w", b.Addr, err)
+	}
+	// non-blocking so reads go through the poller and Close unblocks them
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	log.Printf("Connected to %s channel %d", b.Addr, b.Channel)
+
+	if b.millis == nil {
+		b.millis = timeline.New()
+	}
+	file := os.NewFile(uintptr(fd), b.Addr)
+	b.mu.Lock()
+	b.file, b.lines = file, newLineReader(file, b.millis)
+	b.lines.replies, b.lines.onBoot = &b.replies, b.OnBoot
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *RFCOMM) ReadFrame() (Frame, error) {
+	return b.lines.next()
+}
+
+// Send writes a command to the logger
+func (b *RFCOMM) Send(cmd frames.Command) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return ErrClosed
+	}
+	_, err := b.file.Write([]byte(cmd.String() + "\n"))
+	return err
+}
+
+func (b *RFCOMM) ReadDTCs() ([]dtc.Code, error) {
+	return readLoggerDTCs(&b.replies, b.Send)
+}
+
+func (b *RFCOMM) ClearDTCs() error {
+	return clearLoggerDTCs(&b.replies, b.Send)
+}
+
+func (b *RFCOMM) Configure(samples []frames.Sample) error {
+	return configureLogger(&b.replies, b.Send, samples)
+}
+
+func (b *RFCOMM) FirmwareVersion() (string, error) {
+	return readLoggerVersion(&b.replies, b.Send)
+}
+
+func (b *RFCOMM) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
@@ -0,0 +1,49 @@
+//go:build !linux
+
+package input
+
+import (
+	"errors"
+	"huskki/dtc"
+	"huskki/frames"
+)
+
+// RFCOMM is only available on Linux. Elsewhere, a paired dongle shows up as a serial port
+// that can be passed to -port.
+type RFCOMM struct {
+	Addr    string
+	Channel int
+	OnBoot  func()
+}
+
+func (b *RFCOMM) Open() error {
+	return errors.New("bluetooth addresses are only supported on Linux, use the paired device's serial port with -port")
+}
+
+func (b *RFCOMM) ReadFrame() (Frame, error) {
+	return Frame{}, ErrClosed
+}
+
+func (b *RFCOMM) Send(cmd frames.Command) error {
+	return ErrClosed
+}
+
+func (b *RFCOMM) ReadDTCs() ([]dtc.Code, error) {
+	return nil, ErrClosed
+}
+
+func (b *RFCOMM) ClearDTCs() error {
+	return ErrClosed
+}
+
+func (b *RFCOMM) Configure([]frames.Sample) error {
+	return ErrClosed
+}
+
+func (b *RFCOMM) FirmwareVersion() (string, error) {
+	return "", ErrClosed
+}
+
+func (b *RFCOMM) Close() error {
+	return nil
+}
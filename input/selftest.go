@@ -0,0 +1,179 @@
+package input
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	SELFTEST_FRAMES = 1000
+	// The logger resets when the port opens and tries to unlock the ECU before it reads
+	// commands, so the TEST command is resent until it's acked
+	SELFTEST_ACK_TIMEOUT = 15 * time.Second
+	SELFTEST_RESEND      = time.Second
+	// How long the pattern can stall before the test gives up on the rest
+	SELFTEST_IDLE_TIMEOUT = 2 * time.Second
+)
+
+// SelfTestReport is the outcome of asking the logger for a known pattern
+type SelfTestReport struct {
+	Port string
+	Baud int
+
+	Latency    time.Duration // from the TEST command to its ack
+	Expected   int
+	Received   int
+	Missing    int
+	OutOfOrder int
+	Malformed  int // lines that aren't rows or replies
+	BadCRC     int
+	Corrupt    int // rows with a valid CRC but the wrong pattern
+	Duration   time.Duration
+	Bytes      int
+}
+
+func (r SelfTestReport) Passed() bool {
+	return r.Received == r.Expected && r.Missing == 0 && r.OutOfOrder == 0 &&
+		r.Malformed == 0 && r.BadCRC == 0 && r.Corrupt == 0
+}
+
+// FramesPerSecond and BytesPerSecond are the throughput of the pattern, first row to last
+func (r SelfTestReport) FramesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Duration.Seconds()
+}
+
+func (r SelfTestReport) BytesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// SelfTest opens the logger's port and has it send count rows of frames.TestPattern, checking
+// their framing, CRCs and sequence. Port may be "auto", Baud 0 uses DEFAULT_BAUD_RATE.
+func SelfTest(name string, baud, count int) (SelfTestReport, error) {
+	if name == "auto" {
+		var err error
+		if name, _, err = autoSelectPort(); err != nil {
+			return SelfTestReport{}, fmt.Errorf("auto-select: %w", err)
+		}
+	}
+	report := SelfTestReport{Port: name, Baud: cmp.Or(baud, DEFAULT_BAUD_RATE), Expected: count}
+	port, err := serial.Open(name, &serial.Mode{BaudRate: report.Baud})
+	if err != nil {
+		return report, fmt.Errorf("open serial %s: %w", name, err)
+	}
+	defer port.Close()
+	if err := port.SetReadTimeout(100 * time.Millisecond); err != nil {
+		return report, err
+	}
+
+	command := []byte(frames.TestCommand(count).String() + "\n")
+	var sent, first, last time.Time
+	acked := false
+	next := 0
+	var pending []byte
+	buf := make([]byte, 256)
+	for deadline := time.Now().Add(SELFTEST_ACK_TIMEOUT); ; {
+		now := time.Now()
+		if !acked && now.After(deadline) {
+			return report, errors.New("logger didn't ack the TEST command, is the firmware up to date?")
+		}
+		if acked && now.Sub(cmp.Or(last, sent)) > SELFTEST_IDLE_TIMEOUT {
+			break
+		}
+		if !acked && now.Sub(sent) >= SELFTEST_RESEND {
+			if _, err := port.Write(command); err != nil {
+				return report, err
+			}
+			sent = now
+		}
+
+		n, err := port.Read(buf)
+		if err != nil {
+			return report, err
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			line := strings.TrimSpace(string(pending[:i]))
+			pending = pending[i+1:]
+			if line == "" {
+				continue
+			}
+
+			if strings.HasPrefix(line, "$") {
+				cmd, err := frames.ParseCommand(line)
+				switch {
+				case err != nil:
+					if acked {
+						report.Malformed++
+					}
+				case cmd.Name == frames.ACK_COMMAND && len(cmd.Args) > 0 && cmd.Args[0] == frames.TEST_COMMAND && !acked:
+					acked, report.Latency = true, time.Since(sent)
+				case cmd.Name == frames.NACK_COMMAND:
+					return report, fmt.Errorf("logger refused the TEST command: %s", strings.Join(cmd.Args, ","))
+				}
+				continue
+			}
+			// Anything before the ack may be bootloader noise or the tail of a row
+			if !acked {
+				continue
+			}
+
+			frame, err := frames.Parse(line)
+			switch {
+			case errors.Is(err, frames.ErrCRC):
+				report.BadCRC++
+				continue
+			case err != nil:
+				report.Malformed++
+				continue
+			case frame.DID != frames.TEST_DID:
+				// regular logging carries on around the test
+				continue
+			}
+			if first.IsZero() {
+				first = time.Now()
+			}
+			last = time.Now()
+			report.Received++
+			report.Bytes += len(line) + 1
+
+			if len(frame.Data) < 2 {
+				report.Corrupt++
+				continue
+			}
+			seq := int(frame.Data[0])<<8 | int(frame.Data[1])
+			if !bytes.Equal(frame.Data, frames.TestPattern(uint16(seq))) {
+				report.Corrupt++
+			}
+			switch {
+			case seq < next:
+				report.OutOfOrder++
+			case seq > next:
+				report.Missing += seq - next
+			}
+			next = max(next, seq+1)
+		}
+		if acked && report.Received >= count {
+			break
+		}
+	}
+	report.Missing += max(0, count-next)
+	report.Duration = last.Sub(first)
+	return report, nil
+}
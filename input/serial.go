@@ -0,0 +1,208 @@
+package input
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"huskki/frames"
+	"huskki/timeline"
+	"log"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	DEFAULT_BAUD_RATE = 115200
+	// How long to listen at each rate when detecting the baud rate. Opening the port resets
+	// most Arduinos, so this has to cover the bootloader too.
+	BAUD_PROBE_TIMEOUT = 2 * time.Second
+	// Valid frames needed to settle on a rate
+	BAUD_PROBE_FRAMES = 3
+	// How often the USB devices are checked for the logger being plugged in
+	HOTPLUG_INTERVAL = time.Second
+)
+
+// Rates probed when no baud rate is given, most likely first
+var probeBaudRates = []int{DEFAULT_BAUD_RATE, 57600, 38400, 19200, 9600, 230400, 250000, 460800, 500000, 921600, 1000000}
+
+// Serial reads frames from the Arduino logger over a serial port. Port may be "auto" to pick
+// the most Arduino-like USB serial device; an Arduino plugged in later is attached as soon as
+// it appears, switching over from any other device picked in the meantime. With Baud 0,
+// common rates are probed until one yields valid frames, and that rate is kept for reconnects.
+type Serial struct {
+	Port string
+	Baud int
+	// OnBoot, if set, is called once the logger is up after each connect, which resets most
+	// Arduinos, and after it resets by itself, e.g. to send it its config again
+	OnBoot func()
+
+	detected int
+	replies  replies
+	unplug   chan struct{} // closed to stop watching for a preferred device
+	mu       sync.Mutex    // guards port for Send
+	port     serial.Port
+	lines    *lineReader
+	// kept across reopens, the logger's millis restart if it was power cycled
+	millis *timeline.Timeline
+}
+
+func (s *Serial) Open() error {
+	name, preferred := s.Port, true
+	if name == "auto" {
+		var err error
+		if name, preferred, err = autoSelectPort(); err != nil {
+			return fmt.Errorf("auto-select: %w", err)
+		}
+	}
+	baud := s.Baud
+	if baud == 0 {
+		baud = s.detected
+	}
+	port, err := serial.Open(name, &serial.Mode{BaudRate: cmp.Or(baud, probeBaudRates[0])})
+	if err != nil {
+		return fmt.Errorf("open serial %s: %w", name, err)
+	}
+	if baud == 0 {
+		if baud, err = detectBaud(port); err != nil {
+			port.Close()
+			return fmt.Errorf("detect baud rate on %s: %w", name, err)
+		}
+		s.detected = baud
+	}
+	log.Printf("Connected to %s @ %d", name, baud)
+
+	if s.millis == nil {
+		s.millis = timeline.New()
+	}
+	s.mu.Lock()
+	s.port, s.lines = port, newLineReader(port, s.millis)
+	s.lines.replies, s.lines.onBoot = &s.replies, s.OnBoot
+	s.unplug = make(chan struct{})
+	s.mu.Unlock()
+	if !preferred {
+		go s.switchToPreferred(port, s.unplug)
+	}
+	return nil
+}
+
+// WaitReady returns once a preferred device is plugged in, or after timeout. Ports other than
+// "auto" just wait out the timeout.
+func (s *Serial) WaitReady(done <-chan struct{}, timeout time.Duration) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(HOTPLUG_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+			if s.Port != "auto" {
+				continue
+			}
+			if _, ok := preferredPort(); ok {
+				return
+			}
+		}
+	}
+}
+
+// switchToPreferred closes a fallback port once a preferred device appears, so that the
+// reconnect picks it up instead
+func (s *Serial) switchToPreferred(port serial.Port, stop <-chan struct{}) {
+	ticker := time.NewTicker(HOTPLUG_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if name, ok := preferredPort(); ok {
+				log.Printf("%s plugged in, switching to it", name)
+				port.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *Serial) ReadFrame() (Frame, error) {
+	return s.lines.next()
+}
+
+// Send writes a command to the logger
+func (s *Serial) Send(cmd frames.Command) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port == nil {
+		return ErrClosed
+	}
+	_, err := s.port.Write([]byte(cmd.String() + "\n"))
+	return err
+}
+
+func (s *Serial) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unplug != nil {
+		close(s.unplug)
+		s.unplug = nil
+	}
+	if s.port == nil {
+		return nil
+	}
+	return s.port.Close()
+}
+
+// detectBaud tries each probe rate in turn, returning the first that yields valid frames
+func detectBaud(port serial.Port) (int, error) {
+	defer port.SetReadTimeout(serial.NoTimeout)
+	if err := port.SetReadTimeout(100 * time.Millisecond); err != nil {
+		return 0, err
+	}
+	for _, rate := range probeBaudRates {
+		if err := port.SetMode(&serial.Mode{BaudRate: rate}); err != nil {
+			return 0, err
+		}
+		port.ResetInputBuffer()
+		ok, err := probeFrames(port)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("no valid frames at any of %v", probeBaudRates)
+}
+
+// probeFrames reports whether BAUD_PROBE_FRAMES valid rows arrive within BAUD_PROBE_TIMEOUT
+func probeFrames(port serial.Port) (bool, error) {
+	var pending []byte
+	buf := make([]byte, 256)
+	valid := 0
+	for deadline := time.Now().Add(BAUD_PROBE_TIMEOUT); time.Now().Before(deadline); {
+		n, err := port.Read(buf)
+		if err != nil {
+			return false, err
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			if _, err := frames.Parse(string(pending[:i])); err == nil {
+				valid++
+			}
+			pending = pending[i+1:]
+		}
+		if valid >= BAUD_PROBE_FRAMES {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,155 @@
+package input
+
+import (
+	"huskki/frames"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	SIMULATE_INTERVAL         = 20 * time.Millisecond
+	SIMULATE_COOLANT_INTERVAL = time.Second
+
+	SIM_IDLE_RPM    = 1500
+	SIM_REDLINE_RPM = 10000
+	SIM_TOP_SPEED   = 140.0
+	SIM_AMBIENT_C   = 20.0
+	SIM_RUNNING_C   = 88.0
+	// time constant of the warm-up curve
+	SIM_WARMUP_TAU = 3 * time.Minute
+)
+
+// Simulator synthesizes a bike idling, blipping and revving out while it warms up, so the
+// dashboard can be worked on without a bike or a recorded log
+type Simulator struct {
+	mu      sync.Mutex
+	closed  bool
+	start   time.Time
+	next    time.Time
+	pending []Frame
+
+	grip        float64 // twist, 0..1
+	target      float64
+	hold        time.Duration
+	rpm         float64
+	speed       float64
+	coolant     float64
+	lastCoolant time.Time
+}
+
+func (s *Simulator) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = false
+	if s.start.IsZero() {
+		s.start, s.next = time.Now(), time.Now()
+		s.rpm, s.coolant = SIM_IDLE_RPM, SIM_AMBIENT_C
+	}
+	return nil
+}
+
+func (s *Simulator) ReadFrame() (Frame, error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return Frame{}, ErrClosed
+		}
+		if len(s.pending) > 0 {
+			frame := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+			return frame, nil
+		}
+		wait := time.Until(s.next)
+		s.mu.Unlock()
+
+		time.Sleep(wait)
+
+		s.mu.Lock()
+		s.step(s.next)
+		s.next = s.next.Add(SIMULATE_INTERVAL)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// step advances the model by one interval and queues the resulting frames
+func (s *Simulator) step(now time.Time) {
+	dt := SIMULATE_INTERVAL.Seconds()
+
+	// Pick a new twist every so often: mostly idle, some blips, the odd pull to the redline
+	s.hold -= SIMULATE_INTERVAL
+	if s.hold <= 0 {
+		switch r := rand.Float64(); {
+		case r < 0.4:
+			s.target, s.hold = 0, randDuration(2*time.Second, 6*time.Second)
+		case r < 0.8:
+			s.target, s.hold = 0.2+rand.Float64()*0.3, randDuration(300*time.Millisecond, time.Second)
+		default:
+			s.target, s.hold = 0.8+rand.Float64()*0.2, randDuration(2*time.Second, 4*time.Second)
+		}
+	}
+	// A hand can only twist so fast, snapping shut is quicker
+	rate := 3.0
+	if s.target < s.grip {
+		rate = 6.0
+	}
+	s.grip += math.Max(-rate*dt, math.Min(rate*dt, s.target-s.grip))
+
+	// RPM lags the throttle, and falls off the redline
+	want := SIM_IDLE_RPM + s.grip*(SIM_REDLINE_RPM-SIM_IDLE_RPM)
+	s.rpm += (want - s.rpm) * math.Min(1, 4*dt)
+	s.rpm = math.Min(s.rpm+rand.NormFloat64()*15, SIM_REDLINE_RPM)
+
+	// The bike gathers speed more slowly than the engine revs, and coasts down
+	s.speed += (s.grip*SIM_TOP_SPEED - s.speed) * dt / 4
+	s.speed = math.Max(0, s.speed)
+
+	// Warms up towards running temperature, faster when it's working hard
+	tau := SIM_WARMUP_TAU.Seconds() / (1 + s.grip)
+	s.coolant += (SIM_RUNNING_C - s.coolant) * dt / tau
+
+	millis := now.Sub(s.start).Milliseconds()
+	s.queue(millis, now, "rpm", s.rpm)
+	s.queue(millis, now, "grip", math.Floor(s.grip*255))
+	s.queue(millis, now, "throttle", math.Floor(s.grip*255))
+	s.queue(millis, now, "tps", s.grip*100)
+	s.queue(millis, now, "speed", s.speed)
+	// Closed loop hunts either side of stoichiometric, richening up under load
+	lambda := 1 + 0.02*math.Sin(float64(millis)/300) - 0.12*s.grip
+	s.queue(millis, now, "lambda", lambda)
+	// Fuel follows load, on top of a base pulse to idle
+	s.queue(millis, now, "injector", 1.8+s.grip*8*s.rpm/SIM_REDLINE_RPM)
+	s.queue(millis, now, "o2", 0.45+0.4*math.Tanh((1-lambda)*40))
+	if now.Sub(s.lastCoolant) >= SIMULATE_COOLANT_INTERVAL {
+		s.lastCoolant = now
+		s.queue(millis, now, "coolant", s.coolant)
+		// The regulator holds it around 14V, sagging a little at idle
+		s.queue(millis, now, "voltage", 13.6+0.6*math.Min(1, (s.rpm-SIM_IDLE_RPM)/3000)+rand.NormFloat64()*0.05)
+		// Intake air picks up some engine heat as it warms
+		s.queue(millis, now, "iat", SIM_AMBIENT_C+(s.coolant-SIM_AMBIENT_C)*0.15)
+		s.queue(millis, now, "baro", 101)
+	}
+}
+
+func (s *Simulator) queue(millis int64, received time.Time, channel string, value float64) {
+	did, data, ok := frames.Encode(channel, value)
+	if !ok {
+		return
+	}
+	frame := frames.Frame{Millis: millis, DID: did, Data: data, Micros: int64(millis) * 1000}
+	s.pending = append(s.pending, Frame{Frame: frame, Received: received})
+}
+
+func randDuration(min, max time.Duration) time.Duration {
+	return min + rand.N(max-min)
+}
@@ -0,0 +1,163 @@
+//go:build linux
+
+package input
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"huskki/frames"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SocketCAN reads frames straight off a CAN interface (e.g. can0 on a Raspberry Pi CAN HAT),
+// without the Arduino in between. See canToDID for how CAN frames map to DIDs. Millis are
+// counted from when the source was first opened. Outputs are controlled with UDS requests to
+// RequestID, UDS_REQUEST_ID if 0.
+type SocketCAN struct {
+	Interface string
+	IDs       map[uint32]uint16
+	RequestID uint32
+
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	uds   *udsClient
+}
+
+func (s *SocketCAN) Open() error {
+	iface, err := net.InterfaceByName(s.Interface)
+	if err != nil {
+		return fmt.Errorf("can interface %s: %w", s.Interface, err)
+	}
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		return fmt.Errorf("can socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("bind %s: %w", s.Interface, err)
+	}
+	// non-blocking so reads go through the poller and Close unblocks them
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	log.Printf("Connected to %s", s.Interface)
+
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	s.mu.Lock()
+	s.file = os.NewFile(uintptr(fd), s.Interface)
+	if s.uds == nil {
+		s.uds = newUDSClient(s.SendCAN, cmp.Or(s.RequestID, UDS_REQUEST_ID))
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *SocketCAN) ReadFrame() (Frame, error) {
+	// struct can_frame: u32 id, u8 len, 3 bytes padding, 8 bytes data
+	buf := make([]byte, unix.CAN_MTU)
+	s.mu.Lock()
+	file, uds := s.file, s.uds
+	s.mu.Unlock()
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return Frame{}, err
+		}
+		received := time.Now()
+		if n < 8 {
+			continue
+		}
+		raw := binary.NativeEndian.Uint32(buf[0:4])
+		if raw&(unix.CAN_RTR_FLAG|unix.CAN_ERR_FLAG) != 0 {
+			continue
+		}
+		mask := uint32(unix.CAN_SFF_MASK)
+		if raw&unix.CAN_EFF_FLAG != 0 {
+			mask = unix.CAN_EFF_MASK
+		}
+		length := min(int(buf[4]), 8, n-8)
+
+		id := raw & mask
+		if uds.deliver(id, buf[8:8+length]) {
+			continue
+		}
+		did, data, ok := canToDID(id, buf[8:8+length], s.IDs)
+		if !ok {
+			continue
+		}
+		elapsed := received.Sub(s.start)
+		return Frame{
+			Frame: frames.Frame{
+				Millis: elapsed.Milliseconds(),
+				DID:    did,
+				Data:   append([]byte(nil), data...),
+				Micros: elapsed.Microseconds(),
+				CANID:  id,
+			},
+			Received: received,
+		}, nil
+	}
+}
+
+// SendCAN writes a frame to the bus, e.g. a UDS request
+func (s *SocketCAN) SendCAN(id uint32, data []byte) error {
+	if len(data) > 8 {
+		return fmt.Errorf("CAN payload too long: %d bytes", len(data))
+	}
+	buf := make([]byte, unix.CAN_MTU)
+	if id > unix.CAN_SFF_MASK {
+		id |= unix.CAN_EFF_FLAG
+	}
+	binary.NativeEndian.PutUint32(buf[0:4], id)
+	buf[4] = byte(len(data))
+	copy(buf[8:], data)
+
+	s.mu.Lock()
+	file := s.file
+	s.mu.Unlock()
+	if file == nil {
+		return ErrClosed
+	}
+	_, err := file.Write(buf)
+	return err
+}
+
+func (s *SocketCAN) HoldOutput(did uint16, state []byte) error {
+	s.mu.Lock()
+	uds := s.uds
+	s.mu.Unlock()
+	if uds == nil {
+		return ErrClosed
+	}
+	return uds.HoldOutput(did, state)
+}
+
+func (s *SocketCAN) ReleaseOutput(did uint16) error {
+	s.mu.Lock()
+	uds := s.uds
+	s.mu.Unlock()
+	if uds == nil {
+		return ErrClosed
+	}
+	return uds.ReleaseOutput(did)
+}
+
+func (s *SocketCAN) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
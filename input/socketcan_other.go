@@ -0,0 +1,36 @@
+//go:build !linux
+
+package input
+
+import "errors"
+
+// SocketCAN is only available on Linux
+type SocketCAN struct {
+	Interface string
+	IDs       map[uint32]uint16
+	RequestID uint32
+}
+
+func (s *SocketCAN) Open() error {
+	return errors.New("SocketCAN is only supported on Linux")
+}
+
+func (s *SocketCAN) ReadFrame() (Frame, error) {
+	return Frame{}, ErrClosed
+}
+
+func (s *SocketCAN) SendCAN(id uint32, data []byte) error {
+	return ErrClosed
+}
+
+func (s *SocketCAN) HoldOutput(uint16, []byte) error {
+	return ErrClosed
+}
+
+func (s *SocketCAN) ReleaseOutput(uint16) error {
+	return ErrClosed
+}
+
+func (s *SocketCAN) Close() error {
+	return nil
+}
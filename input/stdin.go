@@ -0,0 +1,25 @@
+package input
+
+import (
+	"huskki/timeline"
+	"os"
+)
+
+// Stdin reads the logger's rows piped in from another tool, e.g. nc, socat or a capture
+// replayed with pv. The stream ending ends the input.
+type Stdin struct {
+	lines *lineReader
+}
+
+func (s *Stdin) Open() error {
+	s.lines = newLineReader(os.Stdin, timeline.New())
+	return nil
+}
+
+func (s *Stdin) ReadFrame() (Frame, error) {
+	return s.lines.next()
+}
+
+func (s *Stdin) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package input
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 11-bit OBD/UDS functional request ID for the engine ECU
+	UDS_REQUEST_ID        = 0x7E0
+	UDS_READ_DID          = 0x22
+	DEFAULT_POLL_INTERVAL = 100 * time.Millisecond
+)
+
+// CANSender can put a frame on the bus
+type CANSender interface {
+	SendCAN(id uint32, data []byte) error
+}
+
+// PollDID is a DID to request and how often
+type PollDID struct {
+	DID      uint16
+	Interval time.Duration
+}
+
+// ParsePollList parses a comma separated list of DID[@interval], e.g. "0x0100@50ms,0x0009@1s".
+// DIDs without an interval are polled every DEFAULT_POLL_INTERVAL.
+func ParsePollList(s string) ([]PollDID, error) {
+	var dids []PollDID
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		didStr, intervalStr, hasInterval := strings.Cut(item, "@")
+		did, err := strconv.ParseUint(didStr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DID %q: %w", didStr, err)
+		}
+		poll := PollDID{DID: uint16(did), Interval: DEFAULT_POLL_INTERVAL}
+		if hasInterval {
+			if poll.Interval, err = time.ParseDuration(intervalStr); err != nil || poll.Interval <= 0 {
+				return nil, fmt.Errorf("invalid poll interval %q for DID %s", intervalStr, didStr)
+			}
+		}
+		dids = append(dids, poll)
+	}
+	return dids, nil
+}
+
+// UDSPoller actively requests DIDs from the ECU with ReadDataByIdentifier, each at its own
+// rate. The responses come back through the input source like any other frame.
+type UDSPoller struct {
+	Sender    CANSender
+	RequestID uint32
+	DIDs      []PollDID
+}
+
+// Start polls until the returned function is called
+func (p *UDSPoller) Start() func() {
+	done := make(chan struct{})
+	for _, poll := range p.DIDs {
+		go p.poll(poll, done)
+	}
+	return func() { close(done) }
+}
+
+func (p *UDSPoller) poll(poll PollDID, done <-chan struct{}) {
+	ticker := time.NewTicker(poll.Interval)
+	defer ticker.Stop()
+
+	// ISO-TP single frame, padded to 8 bytes
+	request := []byte{3, UDS_READ_DID, byte(poll.DID >> 8), byte(poll.DID), 0, 0, 0, 0}
+	failing := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := p.Sender.SendCAN(p.RequestID, request)
+		// only log the first of a run of failures, the bus may be down for a while
+		if err != nil && !failing {
+			log.Printf("poll DID 0x%04X: %v", poll.DID, err)
+		}
+		failing = err != nil
+	}
+}
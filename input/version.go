@@ -0,0 +1,30 @@
+package input
+
+import (
+	"fmt"
+	"huskki/frames"
+	"time"
+)
+
+// The logger resets when the port opens and unlocks the ECU before it reads commands
+const VERSION_REPLY_TIMEOUT = 15 * time.Second
+
+// VersionReader is a source that can ask the logger for its firmware version
+type VersionReader interface {
+	FirmwareVersion() (string, error)
+}
+
+func (s *Serial) FirmwareVersion() (string, error) {
+	return readLoggerVersion(&s.replies, s.Send)
+}
+
+func readLoggerVersion(r *replies, send func(frames.Command) error) (string, error) {
+	reply, err := r.await(send, frames.Command{Name: frames.VERSION_COMMAND}, VERSION_REPLY_TIMEOUT)
+	if err != nil {
+		return "", err
+	}
+	if len(reply.Args) != 1 {
+		return "", fmt.Errorf("invalid version reply %v", reply.Args)
+	}
+	return reply.Args[0], nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"huskki/hub"
+	"log"
+	"time"
+)
+
+const (
+	LAST_STATE_SETTING          = "hub.last"
+	DEFAULT_CHECKPOINT_INTERVAL = 10 * time.Second
+)
+
+// savedValue is a channel's latest value as checkpointed. The value is kept raw so that ints
+// come back as ints rather than float64.
+type savedValue struct {
+	Value  json.RawMessage `json:"value"`
+	Unit   string          `json:"unit,omitempty"`
+	Source string          `json:"source,omitempty"`
+}
+
+// checkpointLastState saves the latest value of every sensor channel every interval, so the
+// dashboard can show them after a restart rather than zeros
+func checkpointLastState(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := saveLastState(); err != nil {
+			log.Printf("checkpoint last state: %v", err)
+		}
+	}
+}
+
+func saveLastState() error {
+	saved := map[string]savedValue{}
+	for channel, event := range EventHub.Snapshot() {
+		// Status channels, e.g. the link or stale flags, describe now rather than the bike
+		if !event.HasTimestamp {
+			continue
+		}
+		raw, err := json.Marshal(event.Value)
+		if err != nil {
+			return err
+		}
+		saved[channel] = savedValue{Value: raw, Unit: event.Unit, Source: event.Source}
+	}
+	if len(saved) == 0 {
+		return nil
+	}
+	return Settings.Set(LAST_STATE_SETTING, saved)
+}
+
+// restoreLastState puts the values checkpointed before the last restart back on the hub as
+// the latest of their channels. They have no timestamp, so fill the cards but not the charts.
+func restoreLastState() {
+	var saved map[string]savedValue
+	if _, err := Settings.Get(LAST_STATE_SETTING, &saved); err != nil {
+		log.Printf("restore last state: %v", err)
+		return
+	}
+	var events []hub.SensorEvent
+	for channel, s := range saved {
+		decoder := json.NewDecoder(bytes.NewReader(s.Value))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			log.Printf("restore last state of %s: %v", channel, err)
+			continue
+		}
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				value = int(i)
+			} else if f, err := n.Float64(); err == nil {
+				value = f
+			}
+		}
+		events = append(events, hub.SensorEvent{Channel: channel, Value: value, Unit: s.Unit, Source: s.Source})
+	}
+	EventHub.Restore(events...)
+}
@@ -1,41 +1,147 @@
 package main
 
 import (
-	"bufio"
-	"encoding/hex"
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"huskki/ambient"
+	"huskki/discover"
+	"huskki/frames"
+	"huskki/gps"
 	"huskki/hub"
+	"huskki/idle"
+	"huskki/ignition"
+	"huskki/input"
+	"huskki/quarantine"
+	"huskki/rawlog"
+	"huskki/resample"
+	"huskki/settings"
+	"huskki/sink"
+	"huskki/throttle"
+	"huskki/units"
+	"huskki/webhook"
 	"log"
-	"math"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
-
-	"go.bug.st/serial"
-	"go.bug.st/serial/enumerator"
 )
 
-const DEFAULT_BAUD_RATE = 115200
-
 const (
-	RPM_DID      = 0x0100
-	THROTTLE_DID = 0x0001
-	GRIP_DID     = 0x0070
-	TPS_DID      = 0x0076
-	COOLANT_DID  = 0x0009
+	// How often the ignition timeout is checked while idle
+	IDLE_CHECK_INTERVAL = 15 * time.Second
+	// How long requests in flight get to finish on Ctrl-C before the server closes anyway
+	SHUTDOWN_TIMEOUT = 5 * time.Second
 )
 
-// Arduino & clones common VIDs
-var preferredVIDs = map[string]bool{
-	"2341": true, // Arduino
-	"2A03": true, // Arduino (older)
-	"1A86": true, // CH340
-	"10C4": true, // CP210x
-	"0403": true, // FTDI
+// Plausible decoded value ranges per channel
+var channelRanges = map[string][2]float64{
+	"rpm":      {0, 15000},
+	"throttle": {0, 255},
+	"grip":     {0, 255},
+	"tps":      {0, 100},
+	"coolant":  {-40, 150},
+	"voltage":  {0, 20},
+	"iat":      {-40, 150},
+	"baro":     {50, 120},
+	"speed":    {0, 300},
+	"o2":       {0, 1.275},
+	"lambda":   {0, 4},
+	"afr":      {0, 60},
+	"injector": {0, 100},
+	// from the grip and throttle calibration
+	"grip_pct":     {0, 100},
+	"throttle_pct": {0, 100},
+}
+
+// applyProfile layers a bike's decoders and ranges over the built in ones
+func applyProfile(profile frames.Profile) {
+	frames.SetDecoders(profile.Decoders)
+	for channel, r := range profile.Ranges {
+		channelRanges[channel] = r
+	}
+	log.Printf("Using profile %s", profile.Name)
+}
+
+type Flags struct {
+	Port               string
+	Baud               int
+	PreferPorts        string
+	Profile            string
+	Decoders           string
+	Derived            string
+	DBC                string
+	SelfTest           bool
+	BTAddr             string
+	BTChannel          int
+	Protocol           string
+	KWPECU             uint
+	Addr               string
+	ReplayFile         string
+	ReplayStart        string
+	ReplayEnd          string
+	ReplayDIDs         string
+	ReplayLoop         bool
+	Candump            string
+	Simulate           bool
+	Connect            string
+	ListenTCP          string
+	ListenUDP          string
+	MQTT               string
+	GPS                string
+	GPSBaud            int
+	CAN                string
+	CANIDs             string
+	UDSPoll            string
+	UDSRequestID       uint
+	ELM327             string
+	ELM327Baud         int
+	IgnitionTimeout    time.Duration
+	Theme              string
+	Units              string
+	ThemeDir           string
+	Webhooks           string
+	AmbientURL         string
+	BME280             string
+	BME280Addr         int
+	RejectLog          string
+	SQLite             string
+	Influx             string
+	InfluxToken        string
+	LogDir             string
+	LogFile            string
+	NoLog              bool
+	LogJSONL           bool
+	MarkerGPIO         string
+	LogMinFree         int
+	LogPrune           bool
+	Record             string
+	LogCompress        string
+	StaleAfter         time.Duration
+	History            time.Duration
+	UIRates            string
+	Align              time.Duration
+	Coalesce           time.Duration
+	RemoteMaxRate      float64
+	WSOrigins          string
+	AlignMode          string
+	SettingsPath       string
+	BackupDir          string
+	BackupURL          string
+	BackupInterval     time.Duration
+	BackupKeep         int
+	CheckpointInterval time.Duration
+	Idle               bool
+	InjectorDuty       float64
+	Alerts             string
+	AlertSound         bool
+	IOOutputs          string
 }
 
 type GraphData struct {
@@ -45,226 +151,448 @@ type GraphData struct {
 
 // Globals
 var (
-	Templates *template.Template
-	EventHub  *hub.EventHub
+	Templates        *template.Template
+	EventHub         *hub.EventHub
+	ThrottleRecorder *throttle.Recorder
+	Discovery        *discover.Recorder
+	Ignition         *ignition.Detector
+	Quarantine       *quarantine.Log
+	LogFilter        *sink.ChannelFilter
+	Settings         *settings.Store
+	Idle             *idle.Monitor
+	Database         *sink.SQLite
+	Sinks            []*sink.Buffered
 )
 
 func main() {
-	port, baud, addr, replayFile := getFlags()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "slice":
+			runSlice(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		case "summarize":
+			runSummarize(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		}
+	}
 
-	isReplay := *replayFile != ""
+	flags := getFlags()
+	if flags.PreferPorts != "" {
+		preferred, err := parsePreferPorts(flags.PreferPorts)
+		if err != nil {
+			log.Fatalf("-prefer-ports: %v", err)
+		}
+		input.PreferredPorts = preferred
+	}
+	if flags.Profile != "" {
+		profile, err := frames.LoadProfile(flags.Profile)
+		if err != nil {
+			log.Fatalf("-profile: %v", err)
+		}
+		applyProfile(profile)
+	}
+	if flags.Decoders != "" {
+		decoders, err := frames.LoadDecoders(flags.Decoders)
+		if err != nil {
+			log.Fatalf("-decoders: %v", err)
+		}
+		frames.SetDecoders(decoders)
+	}
+	if flags.DBC != "" {
+		loadDBC(flags.DBC)
+	}
+	if flags.SelfTest {
+		runSelfTest(flags)
+		return
+	}
 
-	var serialPort serial.Port
 	var err error
-	if !isReplay {
-		serialPort, err = getArduinoPort(port, baud, serialPort, err)
-		defer func() {
-			if err := serialPort.Close(); err != nil {
-				log.Printf("close serial: %v", err)
-			}
-		}()
+	Quarantine, err = quarantine.NewLog(flags.RejectLog)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer Quarantine.Close()
 
-	EventHub = hub.NewHub()
-
-	// scan CSV lines from scanner
-	go func() {
-		scan(isReplay, replayFile, serialPort, EventHub)
-	}()
-
-	// Initialise HTML templating
-	Templates = template.New("").Funcs(template.FuncMap{
-		"ToLower": strings.ToLower,
-	})
-	Templates, err = Templates.ParseGlob("templates/*.gohtml")
+	Settings, err = settings.Open(flags.SettingsPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer Settings.Close()
 
-	handler := http.NewServeMux()
-	handler.HandleFunc("/", IndexHandler)
-	handler.HandleFunc("/events", EventsHandler)
-
-	log.Printf("Listening on %s …", *addr)
-	log.Fatal(http.ListenAndServe(*addr, handler))
-}
-
-func getFlags() (*string, *int, *string, *string) {
-	port := flag.String("port", "auto", "serial device path or 'auto'")
-	baud := flag.Int("baud", DEFAULT_BAUD_RATE, "baud rate")
-	addr := flag.String("addr", ":8080", "http listen address")
-	replayFile := flag.String("replay", "", "path to replay file (csv log)")
-	flag.Parse()
-	return port, baud, addr, replayFile
-}
-
-func getArduinoPort(port *string, baud *int, serialPort serial.Port, err error) (serial.Port, error) {
-	// auto-select Arduino-ish port if requested
-	if *port == "auto" {
-		name, err := autoSelectPort()
-		if err != nil {
-			log.Fatalf("auto-select: %v", err)
+	if flags.BackupDir != "" || flags.BackupURL != "" {
+		if flags.BackupInterval <= 0 {
+			log.Fatal("-backup-interval must be positive")
 		}
-		*port = name
+		go runBackups(flags.BackupDir, flags.BackupURL, flags.BackupInterval, flags.BackupKeep)
 	}
-	mode := &serial.Mode{BaudRate: *baud}
-	serialPort, err = serial.Open(*port, mode)
-	if err != nil {
-		log.Fatalf("open serial %s: %v", *port, err)
-	}
-	log.Printf("Connected to %s @ %d", *port, *baud)
-
-	return serialPort, err
-}
 
-func autoSelectPort() (string, error) {
-	ports, err := enumerator.GetDetailedPortsList()
+	EventHub = hub.NewHub()
+	EventHub.SetRetention(flags.History)
+	LogFilter = sink.NewChannelFilter()
+	loadLoggingSettings()
+	loadCalibration()
+
+	ThrottleRecorder = throttle.NewRecorder()
+	ThrottleRecorder.Start(EventHub)
+	Discovery = discover.NewRecorder()
+
+	Ignition = ignition.NewDetector(flags.IgnitionTimeout)
+	Ignition.OnChange(func(on bool) {
+		if on {
+			log.Printf("ignition on")
+		} else {
+			log.Printf("ignition off")
+		}
+	})
+	Ignition.Start(EventHub)
+	watchVoltage(EventHub)
+	watchInjector(EventHub, flags.InjectorDuty)
+	alerts, err := parseAlerts(flags.Alerts)
 	if err != nil {
-		return "", fmt.Errorf("enumerate ports: %w", err)
+		log.Fatalf("-alert: %v", err)
+	}
+	Alerts, ALERT_SOUND = alerts, flags.AlertSound
+	if Outputs, err = parseOutputs(flags.IOOutputs); err != nil {
+		log.Fatalf("-io-output: %v", err)
+	}
+	watchAlerts(EventHub, Alerts)
+	watchDerived(EventHub, loadDerived(flags.Derived))
+	watchStale(EventHub)
+
+	Idle = idle.NewMonitor()
+	if flags.Idle {
+		Idle.OnChange(func(idle bool) {
+			log.Printf("idle: %t", idle)
+			EventHub.PauseHistory(idle)
+			if idle {
+				Ignition.SetCheckInterval(IDLE_CHECK_INTERVAL)
+			} else {
+				Ignition.SetCheckInterval(ignition.CHECK_INTERVAL)
+			}
+		})
+		EventHub.PauseHistory(true)
+		Ignition.SetCheckInterval(IDLE_CHECK_INTERVAL)
 	}
-	for _, p := range ports {
-		if p.IsUSB && preferredVIDs[strings.ToUpper(p.VID)] {
-			return p.Name, nil
+	Idle.Start(EventHub)
+
+	if flags.Webhooks != "" {
+		notifier := webhook.NewNotifier(strings.Split(flags.Webhooks, ","))
+		switch {
+		case flags.BME280 != "":
+			notifier.SetAmbient(&ambient.BME280{Bus: flags.BME280, Addr: flags.BME280Addr})
+		case flags.AmbientURL != "":
+			notifier.SetAmbient(&ambient.Weather{URL: flags.AmbientURL})
 		}
+		notifier.Start(EventHub, Ignition)
 	}
-	for _, p := range ports {
-		if p.IsUSB {
-			return p.Name, nil
+
+	if flags.SQLite != "" {
+		if Database, err = sink.OpenSQLite(flags.SQLite); err != nil {
+			log.Fatalf("-sqlite: %v", err)
 		}
+		defer Database.Close()
+		defer startSink(Database)()
 	}
-	if len(ports) > 0 {
-		return ports[0].Name, nil
+	if flags.Influx != "" {
+		influx, err := sink.NewInflux(flags.Influx, cmp.Or(flags.InfluxToken, os.Getenv("INFLUX_TOKEN")))
+		if err != nil {
+			log.Fatalf("-influx: %v", err)
+		}
+		defer startSink(influx)()
 	}
-	return "", fmt.Errorf("no serial ports found")
-}
 
-func scan(isReplay bool, replayFile *string, serialPort serial.Port, eventHub *hub.EventHub) {
-	var scanner *bufio.Scanner
+	// The previous session's values are restored once everything watching the hub has
+	// subscribed, so only dashboards see them and nothing reacts as if they were live
+	restoreLastState()
+	if flags.CheckpointInterval > 0 {
+		go checkpointLastState(flags.CheckpointInterval)
+	}
 
-	if isReplay {
-		file, err := os.Open(*replayFile)
-		if err != nil {
+	source := newInputSource(flags)
+	if err = source.Open(); err != nil {
+		log.Fatal(err)
+	}
+	if Recording, err = NewRecorder(flags); err != nil {
+		log.Fatal(err)
+	}
+	if flags.MarkerGPIO != "" {
+		go watchMarkerGPIO(flags.MarkerGPIO)
+	}
+	if !flags.NoLog && flags.LogMinFree > 0 {
+		go Recording.guardDiskSpace(uint64(flags.LogMinFree)*MEGABYTE, flags.LogPrune, DISK_CHECK_INTERVAL)
+	}
+	Ignition.OnChange(Recording.Ignition)
+	if !flags.NoLog {
+		go watchClockSteps(CLOCK_CHECK_INTERVAL)
+	}
+	if recordsOnStart(flags) {
+		if err := Recording.Start(); err != nil {
 			log.Fatal(err)
 		}
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-		}(file)
-		scanner = bufio.NewScanner(file)
 	} else {
-		scanner = bufio.NewScanner(serialPort)
+		EventHub.Broadcast(hub.Status(RECORDING_CHANNEL, RECORDING_STOPPED))
 	}
+	// Deferred, so they run after the ride log is closed below: the input, then the sinks
+	// flush what they have queued, then the databases
+	defer func() {
+		if err := source.Close(); err != nil {
+			log.Printf("close input: %v", err)
+		}
+	}()
 
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	// read frames from the input source
+	go func() {
+		readFrames(source, EventHub)
+	}()
 
-	readScanner(scanner, eventHub, isReplay)
-}
+	if flags.GPS != "" {
+		cards = append(cards, gpsCards...)
+		charts = chartsFor(cards)
+		go runGPS(flags.GPS, flags.GPSBaud, EventHub)
+	}
 
-func readScanner(scanner *bufio.Scanner, eventHub *hub.EventHub, isReplay bool) {
-	start := time.Now()
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		fmt.Println(line)
+	StaleAfter = flags.StaleAfter
+	if UIRates, err = parseRates(flags.UIRates); err != nil {
+		log.Fatalf("-ui-rate: %v", err)
+	}
+	AlignStep = flags.Align
+	CoalesceWindow = flags.Coalesce
+	RemoteMaxRate = flags.RemoteMaxRate
+	if flags.WSOrigins != "" {
+		WSOrigins = strings.Split(flags.WSOrigins, ",")
+	}
+	if AlignMode, err = resample.ParseMode(flags.AlignMode); err != nil {
+		log.Fatal(err)
+	}
+	DefaultTheme = flags.Theme
+	if DefaultUnits, err = units.Parse(flags.Units); err != nil {
+		log.Fatalf("-units: %v", err)
+	}
+	if flags.ThemeDir != "" {
+		ThemeDirs = append([]string{flags.ThemeDir}, ThemeDirs...)
+	}
 
-		// Parse lines; Expect; millis,DID,data_hex[,u16be]
-		parts := strings.SplitN(line, ",", 4)
-		if len(parts) < 3 {
-			continue
-		}
-		timestamp, err := strconv.Atoi(parts[0])
-		if err != nil {
-			log.Printf("parse timestamp: %v", err)
-			continue
-		}
-		didStr := parts[1]
-		if !strings.HasPrefix(didStr, "0x") {
-			continue
-		}
-		didVal, err := strconv.ParseUint(didStr[2:], 16, 16)
-		if err != nil {
-			continue
-		}
-		dataHex := parts[2]
-		clean := strings.ReplaceAll(dataHex, " ", "")
-		if len(clean)%2 == 1 {
-			continue
-		}
-		dataBytes, err := hex.DecodeString(clean)
-		if err != nil || len(dataBytes) == 0 {
-			continue
-		}
+	// Initialise HTML templating, falling back to a minimal built-in dashboard if they're broken
+	Templates, TemplateError = loadTemplates("templates/*.gohtml")
+	if TemplateError != nil {
+		log.Printf("templates: %v, serving fallback dashboard", TemplateError)
+	}
 
-		// Replay timing
-		if isReplay {
-			elapsed := time.Now().Sub(start)
-			timeToWait := timestamp - int(elapsed.Milliseconds())
-			if timeToWait > 0 {
-				time.Sleep(time.Duration(timeToWait) * time.Millisecond)
-			}
+	handler := http.NewServeMux()
+	handler.HandleFunc("/", IndexHandler)
+	handler.HandleFunc("/events", EventsHandler)
+	handler.HandleFunc("/ws", WebSocketHandler)
+	handler.HandleFunc("/themes/{name}", ThemeHandler)
+	handler.HandleFunc("/quarantine", QuarantineHandler)
+	handler.HandleFunc("/logging", LoggingPageHandler)
+	handler.HandleFunc("/status", StatusHandler)
+	handler.HandleFunc("/diagnostics", DiagnosticsHandler)
+	handler.HandleFunc("/discover", DiscoverHandler)
+	handler.HandleFunc("/discover/events", DiscoverEventsHandler)
+	handler.HandleFunc("POST /discover/reset", DiscoverResetHandler)
+	handler.HandleFunc("GET /api/latency", LatencyHandler)
+	handler.HandleFunc("GET /api/hub", HubStatsHandler)
+	handler.HandleFunc("GET /metrics", MetricsHandler)
+	handler.HandleFunc("GET /api/latest", LatestHandler)
+	handler.HandleFunc("GET /api/history", HistoryHandler)
+	handler.HandleFunc("GET /api/device/config", DeviceConfigHandler)
+	handler.HandleFunc("POST /api/device/config", DeviceConfigUpdateHandler)
+	handler.HandleFunc("GET /api/dtc", DTCHandler)
+	handler.HandleFunc("POST /api/dtc/clear", DTCClearHandler)
+	handler.HandleFunc("/outputs", OutputsHandler)
+	handler.HandleFunc("POST /api/output/{name}/{action}", OutputControlHandler)
+	handler.HandleFunc("/sessions", SessionsHandler)
+	handler.HandleFunc("GET /api/sessions", SessionsAPIHandler)
+	handler.HandleFunc("POST /api/marker", MarkerHandler)
+	handler.HandleFunc("GET /api/slice", SliceHandler)
+	handler.HandleFunc("GET /api/record", RecordingHandler)
+	handler.HandleFunc("POST /api/record/{action}", RecordingControlHandler)
+	handler.HandleFunc("GET /api/replay", ReplayHandler)
+	handler.HandleFunc("POST /api/replay/step", ReplayStepHandler)
+	handler.HandleFunc("POST /api/replay/{action}", ReplayControlHandler)
+	handler.HandleFunc("GET /api/logging", LoggingHandler)
+	handler.HandleFunc("POST /api/logging", LoggingToggleHandler)
+	handler.HandleFunc("/throttle", ThrottleHandler)
+	handler.HandleFunc("/throttle/events", ThrottleEventsHandler)
+	handler.HandleFunc("POST /throttle/reset", ThrottleResetHandler)
+	handler.HandleFunc("GET /api/calibration", CalibrationHandler)
+	handler.HandleFunc("POST /api/calibration", CalibrationStepHandler)
+	handler.HandleFunc("POST /api/calibration/reset", CalibrationResetHandler)
+
+	// Ctrl-C or a service stop ends the dashboards' streams and shuts the server down, then
+	// main returns so the ride log, sinks and databases are closed in order
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: flags.Addr, Handler: handler, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		// A second Ctrl-C exits straight away
+		stop()
+		shutdown, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := server.Shutdown(shutdown); err != nil {
+			log.Printf("shut down server: %v", err)
 		}
+	}()
 
-		broadcastParsedSensorData(eventHub, didVal, dataBytes, timestamp)
+	log.Printf("Listening on %s …", flags.Addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("serial scanner error: %v", err)
+	log.Printf("Shutting down")
+	releaseOutputs()
+	if err := Recording.Stop(); err != nil {
+		log.Printf("close raw log: %v", err)
 	}
 }
 
-func broadcastParsedSensorData(eventHub *hub.EventHub, didVal uint64, dataBytes []byte, timestamp int) {
-	switch uint16(didVal) {
-	case RPM_DID: // RPM = u16be / 4
-		if len(dataBytes) >= 2 {
-			raw := int(dataBytes[0])<<8 | int(dataBytes[1])
-			rpm := raw / 4
-			eventHub.Broadcast(map[string]any{"rpm": rpm, "timestamp": timestamp})
-		}
+// startSink queues hub events for an external destination, leaving out channels with logging
+// disabled, and lists it on the status page. The returned function stops it once it has made
+// a last write of its queue.
+func startSink(s sink.Sink) func() {
+	buffered := sink.NewBuffered(s, sink.Options{Filter: LogFilter})
+	Sinks = append(Sinks, buffered)
+	return buffered.Start(EventHub)
+}
 
-	case THROTTLE_DID: // Throttle: (0..255?) no fucking clue what this is smoking, I think this is computed target throttle?
-		if len(dataBytes) >= 1 {
-			raw8 := int(dataBytes[len(dataBytes)-1])
-			//pct := scalePct(raw8, 3, 17) // -> 0..100%
-			eventHub.Broadcast(map[string]any{"throttle": raw8, "timestamp": timestamp})
-		}
+func getFlags() *Flags {
+	f := &Flags{}
+	flag.StringVar(&f.Port, "port", "auto", "serial device path, 'auto', or '-' to read rows from stdin")
+	flag.IntVar(&f.Baud, "baud", 0, "baud rate (0 probes common rates for valid frames)")
+	flag.StringVar(&f.Profile, "profile", "", "decoders and ranges for a particular bike, one of "+strings.Join(frames.Profiles(), ", ")+" or a profile YAML file")
+	flag.StringVar(&f.Decoders, "decoders", "", "YAML file of DID decoders to add to or override the built in ones, and the -profile's")
+	flag.StringVar(&f.Derived, "derived", "", "YAML file of derived channels to add to or override the built in ones")
+	flag.StringVar(&f.DBC, "dbc", "", "decode signals with the messages of this .dbc file, by CAN ID for -can/-candump frames or by DID")
+	flag.BoolVar(&f.SelfTest, "selftest", false, "ask the logger on -port for a test pattern, check the link and print a pass/fail report")
+	flag.StringVar(&f.PreferPorts, "prefer-ports", "", "USB devices to prefer with -port auto, most preferred first, e.g. 16C0:0483,serial=A1B2C3,vid=2E8A+product=pico, or @file with one per line (replaces the Arduino defaults)")
+	flag.StringVar(&f.BTAddr, "bt-addr", "", "read frames from a paired Bluetooth serial dongle at this address, e.g. AA:BB:CC:DD:EE:FF (Linux only)")
+	flag.IntVar(&f.BTChannel, "bt-channel", input.DEFAULT_RFCOMM_CHANNEL, "RFCOMM channel of the Bluetooth dongle")
+	flag.StringVar(&f.Protocol, "protocol", PROTOCOL_LOGGER, "what's on -port: logger (Arduino CSV rows) or kwp2000 (K-line ECU, polls the -uds-poll DIDs)")
+	flag.UintVar(&f.KWPECU, "kwp-ecu", input.DEFAULT_KWP_ECU, "K-line address of the ECU")
+	flag.StringVar(&f.Addr, "addr", ":8080", "http listen address")
+	flag.StringVar(&f.ReplayFile, "replay", "", "logs to replay back-to-back, comma separated or a glob: huskki logs or CSVs of timestamp,DID,payload from other tools")
+	flag.StringVar(&f.ReplayStart, "replay-start", "", "start the replay here, the logger's millis or a duration into the log, e.g. 43m")
+	flag.StringVar(&f.ReplayEnd, "replay-end", "", "end the replay here, the logger's millis or a duration into the log, e.g. 50m")
+	flag.BoolVar(&f.ReplayLoop, "replay-loop", false, "start the replay over once it reaches the end, rather than holding there")
+	flag.StringVar(&f.ReplayDIDs, "replay-did", "", "only replay these DIDs, comma separated, e.g. 0x0100,0x0009")
+	flag.BoolVar(&f.Simulate, "simulate", false, "synthesize a bike idling, revving and warming up instead of reading an input")
+	flag.StringVar(&f.Candump, "candump", "", "replay a candump -l log, or - to read a live candump stream from stdin, mapping CAN IDs as for -can")
+	flag.StringVar(&f.Connect, "connect", "", "read frames from a network bridge at host:port instead of serial")
+	flag.StringVar(&f.ListenTCP, "listen-tcp", "", "listen on this address for a network bridge to connect and send frames")
+	flag.StringVar(&f.ListenUDP, "listen-udp", "", "listen on this address for frames sent over UDP")
+	flag.StringVar(&f.MQTT, "mqtt", "", "subscribe to frames published to mqtt[s]://[user:pass@]host[:port]/topic")
+	flag.StringVar(&f.GPS, "gps", "", "also read position from an NMEA GPS on this serial device or tcp://host:port")
+	flag.IntVar(&f.GPSBaud, "gps-baud", gps.DEFAULT_BAUD_RATE, "GPS serial baud rate")
+	flag.StringVar(&f.CAN, "can", "", "read frames from a SocketCAN interface, e.g. can0 (Linux only)")
+	flag.StringVar(&f.CANIDs, "can-ids", "", "comma separated canID=DID mappings for CAN frames that carry a DID's data directly")
+	flag.StringVar(&f.UDSPoll, "uds-poll", "", "actively poll these DIDs over -can or -protocol kwp2000, e.g. 0x0100@50ms,0x0009@1s")
+	flag.UintVar(&f.UDSRequestID, "uds-request-id", input.UDS_REQUEST_ID, "CAN ID to send UDS requests to")
+	flag.StringVar(&f.ELM327, "elm327", "", "poll OBD-II PIDs through an ELM327 adapter on this serial device")
+	flag.IntVar(&f.ELM327Baud, "elm327-baud", input.ELM327_BAUD_RATE, "ELM327 adapter baud rate")
+	flag.DurationVar(&f.IgnitionTimeout, "ignition-timeout", ignition.DEFAULT_FRAME_TIMEOUT, "treat the ignition as off after no frames for this long")
+	flag.StringVar(&f.Theme, "theme", DEFAULT_THEME, "default dashboard theme")
+	flag.StringVar(&f.Units, "units", string(units.METRIC), "default dashboard units, metric or imperial")
+	flag.StringVar(&f.ThemeDir, "theme-dir", "", "directory of additional theme stylesheets (<name>.css)")
+	flag.StringVar(&f.Webhooks, "webhook", "", "comma separated URLs to POST session start/end events to")
+	flag.StringVar(&f.AmbientURL, "ambient-url", "", "weather API URL to capture ambient conditions from at session start")
+	flag.StringVar(&f.BME280, "bme280", "", "I2C bus of a BME280 sensor to capture ambient conditions from at session start, e.g. /dev/i2c-1")
+	flag.IntVar(&f.BME280Addr, "bme280-addr", ambient.DEFAULT_BME280_ADDR, "I2C address of the BME280")
+	flag.StringVar(&f.RejectLog, "reject-log", "", "append quarantined out-of-range frames to this CSV file")
+	flag.StringVar(&f.SQLite, "sqlite", "", "also store decoded events in this SQLite database, a row per sample")
+	flag.StringVar(&f.Influx, "influx", "", "also write decoded events to this InfluxDB write URL, e.g. http://localhost:8086/api/v2/write?org=me&bucket=rides")
+	flag.StringVar(&f.InfluxToken, "influx-token", "", "InfluxDB API token (default $INFLUX_TOKEN)")
+	flag.StringVar(&f.LogDir, "log-dir", defaultLogDir(), "directory to record the raw frames of each ride to, a new file per recording")
+	flag.StringVar(&f.LogFile, "log-file", "", "log raw frames to this file instead of a new one in -log-dir, even when replaying")
+	flag.BoolVar(&f.NoLog, "no-log", false, "don't log raw frames")
+	flag.IntVar(&f.LogMinFree, "log-min-free", 200, "stop recording when the log directory has less than this many MB free, 0 to not check")
+	flag.BoolVar(&f.LogPrune, "log-prune", false, "delete the oldest rides to keep -log-min-free, rather than stop recording")
+	flag.StringVar(&f.MarkerGPIO, "marker-gpio", "", "add a marker whenever this GPIO value file goes high, e.g. /sys/class/gpio/gpio17/value")
+	flag.BoolVar(&f.LogJSONL, "log-jsonl", false, "also record each ride's decoded events to a JSON lines file alongside its raw log")
+	flag.StringVar(&f.Record, "record", RECORD_AUTO, "auto to record rides from start up, or manual to only record once started from the dashboard")
+	flag.StringVar(&f.LogCompress, "log-compress", string(rawlog.NONE), "compress raw logs as they're written: none, gzip or zstd")
+	flag.StringVar(&f.UIRates, "ui-rate", "", "cap how often channels are sent to the dashboard, e.g. rpm=20,coolant=1 (Hz); logging keeps every update")
+	flag.DurationVar(&f.History, "history", hub.DEFAULT_RETENTION, "how much of each channel to keep, in logger time, to fill in the charts of a newly opened dashboard")
+	flag.DurationVar(&f.StaleAfter, "stale-after", DEFAULT_STALE_AFTER, "longest a channel can go without an update before it's flagged stale and its card greyed out")
+	flag.Float64Var(&f.RemoteMaxRate, "remote-max-rate", 0, "limit dashboards on other machines to this many updates per second of each channel (0 for no limit), logging is unaffected")
+	flag.StringVar(&f.WSOrigins, "ws-origin", "", "comma separated origins of other sites whose pages may open /ws, e.g. http://pi.local:3000, or * for any")
+	flag.DurationVar(&f.Align, "align", 0, "resample dashboard channels onto a common timebase with this step, e.g. 50ms (0 disables)")
+	flag.DurationVar(&f.Coalesce, "coalesce", 0, "gather dashboard updates for this long, e.g. 50ms, and send them as one message (0 sends each as it arrives)")
+	flag.StringVar(&f.AlignMode, "align-mode", "hold", "how to fill between samples when aligning: hold or linear")
+	flag.StringVar(&f.SettingsPath, "settings", settings.DEFAULT_PATH, "path to the settings database")
+	flag.StringVar(&f.BackupDir, "backup-dir", "", "directory to write periodic settings backups to")
+	flag.StringVar(&f.BackupURL, "backup-url", "", "URL to PUT periodic settings backups to")
+	flag.DurationVar(&f.BackupInterval, "backup-interval", DEFAULT_BACKUP_INTERVAL, "how often to back up settings")
+	flag.IntVar(&f.BackupKeep, "backup-keep", DEFAULT_BACKUP_KEEP, "number of backups to keep in -backup-dir")
+	flag.DurationVar(&f.CheckpointInterval, "checkpoint-interval", DEFAULT_CHECKPOINT_INTERVAL, "how often to save the latest value of every channel, to show after a restart (0 disables)")
+	flag.Float64Var(&f.InjectorDuty, "injector-duty-warn", DEFAULT_INJECTOR_DUTY_WARN, "warn on the dashboard when injector duty cycle exceeds this %")
+	flag.StringVar(&f.Alerts, "alert", DEFAULT_ALERTS, "comma separated thresholds to warn on the dashboard and mark the ride at, in metric units, e.g. coolant>105,rpm>9000 (empty for none)")
+	flag.BoolVar(&f.AlertSound, "alert-sound", false, "sound an alarm on dashboards when an -alert fires")
+	flag.StringVar(&f.IOOutputs, "io-output", "", "ECU outputs that can be held from the outputs page over -can, comma separated name=DID:state in hex, e.g. fan=0x0D31:01")
+	flag.BoolVar(&f.Idle, "idle", false, "wind down background work while no dashboard is open and the engine isn't running")
+	flag.Parse()
+	return f
+}
 
-	case GRIP_DID: // Grip: (0..255) gives raw pot value in percent from the grip (throttle twist)
-		if len(dataBytes) >= 1 {
-			raw8 := int(dataBytes[len(dataBytes)-1])
-			//pct := scalePct(raw8, 20, 59) // -> 0..100%
-			eventHub.Broadcast(map[string]any{"grip": raw8, "timestamp": timestamp})
+// broadcastParsedSensorData decodes a frame's payload and broadcasts the values. canID is
+// only set for raw CAN frames, which have no DID.
+func broadcastParsedSensorData(eventHub *hub.EventHub, didVal uint64, canID uint32, dataBytes []byte, timestamp int64, received time.Time) {
+	// Decoded values outside the plausible range are quarantined rather than broadcast
+	publish := func(channel string, value float64) {
+		value = frames.Round(channel, value)
+		if r, ok := channelRanges[channel]; ok && (value < r[0] || value > r[1]) {
+			Quarantine.Add(quarantine.Reject{
+				Time:      time.Now(),
+				Timestamp: timestamp,
+				DID:       uint16(didVal),
+				Data:      dataBytes,
+				Channel:   channel,
+				Value:     frames.Value(channel, value),
+				Reason:    fmt.Sprintf("%s %v outside %v..%v", channel, frames.Value(channel, value), r[0], r[1]),
+			})
+			return
 		}
+		Quarantine.Accept(uint16(didVal))
+		eventHub.Broadcast(hub.Sample(channel, frames.Value(channel, value), frames.Unit(channel), timestamp, received))
+		BroadcastLatency.Observe(time.Since(received))
+	}
 
-	case TPS_DID: // TPS (0..1023) -> %
-		if len(dataBytes) >= 2 {
-			raw := int(dataBytes[0])<<8 | int(dataBytes[1])
-			if raw > 1023 {
-				raw = 1023
-			}
-			pct := (raw*100 + 511) / 1023 // integer rounding
-			eventHub.Broadcast(map[string]any{"tps": pct, "timestamp": timestamp})
-		}
+	if didVal == frames.MARKER_DID {
+		eventHub.Broadcast(hub.Sample(MARKER_CHANNEL, string(dataBytes), "", timestamp, received))
+		return
+	}
 
-	case COOLANT_DID: // Coolant °C
-		if len(dataBytes) >= 2 {
-			val := int(dataBytes[0])<<8 | int(dataBytes[1])
-			eventHub.Broadcast(map[string]any{"coolant": val - 40, "timestamp": timestamp})
-		} else if len(dataBytes) == 1 {
-			eventHub.Broadcast(map[string]any{"coolant": int(dataBytes[0]) - 40, "timestamp": timestamp})
+	// Messages defined by -dbc take precedence over the built in decoders
+	if msg, ok := dbcMessage(uint16(didVal), canID); ok {
+		for name, value := range msg.Decode(dataBytes) {
+			publish(strings.ToLower(name), value)
 		}
+		return
 	}
-}
 
-func scalePct(raw, min, max int) int {
-	if max <= min {
-		return 0
+	channel, value, ok := frames.Decode(uint16(didVal), dataBytes)
+	if ok && channel == "lambda" {
+		publish("afr", value*frames.STOICHIOMETRIC_AFR)
+	}
+	if cal, calibrated := frames.Calibrated(channel); ok && calibrated {
+		publish(frames.CalibratedChannel(channel), float64(cal.Percent(value)))
 	}
-	if raw < min {
-		raw = min
+	if !ok && channel == "" {
+		// Nothing to decode it with, pass it on as hex so it can still be seen and logged
+		eventHub.Broadcast(hub.Sample(frames.RawChannel(uint16(didVal)), fmt.Sprintf("% X", dataBytes), "", timestamp, received))
 	}
-	if raw > max {
-		raw = max
+	if ok {
+		publish(channel, value)
 	}
-	return int(math.Round(float64(raw-min) * 100.0 / float64(max-min)))
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// MARKER_CHANNEL carries the label of each marker added during a ride, e.g. "lap" or "weird noise"
+const MARKER_CHANNEL = "marker"
+
+const (
+	DEFAULT_MARKER_LABEL = "marker"
+	MAX_MARKER_LABEL     = 200
+	// MARKER_GPIO_POLL is how often -marker-gpio is read, also debouncing it
+	MARKER_GPIO_POLL = 50 * time.Millisecond
+)
+
+// addMarker puts a marker on the logger's timeline now, recording it as a MARKER_DID frame
+// and broadcasting it as if it had been read from the logger
+func addMarker(label string) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		label = DEFAULT_MARKER_LABEL
+	}
+	if len(label) > MAX_MARKER_LABEL {
+		label = label[:MAX_MARKER_LABEL]
+	}
+	now := time.Now()
+	frame := frames.Frame{Millis: LoggerClock.Now(now), DID: frames.MARKER_DID, Data: []byte(label)}
+	Recording.Write(frame)
+	broadcastParsedSensorData(EventHub, frames.MARKER_DID, 0, frame.Data, frame.Millis, now)
+}
+
+// MarkerHandler adds a marker, e.g. POST /api/marker?label=lap
+func MarkerHandler(w http.ResponseWriter, r *http.Request) {
+	addMarker(r.URL.Query().Get("label"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// buildMarkerScript draws a marker across the charts
+func buildMarkerScript(x int64, label any) string {
+	quoted, err := json.Marshal(fmt.Sprint(label))
+	if err != nil {
+		quoted = []byte(`""`)
+	}
+	return fmt.Sprintf(`pushMarker(%d, %s);`, x, quoted)
+}
+
+// watchMarkerGPIO adds a marker each time a GPIO goes high, read from its sysfs value file,
+// e.g. /sys/class/gpio/gpio17/value for a handlebar button
+func watchMarkerGPIO(path string) {
+	high := false
+	for {
+		value, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("marker gpio: %v, no longer watching", err)
+			return
+		}
+		pressed := bytes.HasPrefix(bytes.TrimSpace(value), []byte("1"))
+		if pressed && !high {
+			addMarker("button")
+		}
+		high = pressed
+		time.Sleep(MARKER_GPIO_POLL)
+	}
+}
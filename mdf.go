@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// MDF4 layout, from ASAM MDF 4.1. Only what a single sorted data group of float channels needs
+// is written: the header, file history, one data group and channel group, a master time channel
+// and a channel per column, their names and units, and one block of records.
+const (
+	MDF_VERSION     = 410
+	MDF_ID_SIZE     = 64
+	MDF_HEADER_SIZE = 24
+
+	MDF_CN_FIXED  = 0
+	MDF_CN_MASTER = 2
+	MDF_SYNC_NONE = 0
+	MDF_SYNC_TIME = 1
+	MDF_REAL_LE   = 4
+
+	// MDF_CN_INVAL_VALID marks a channel as having an invalidation bit, set in records from
+	// before its first sample
+	MDF_CN_INVAL_VALID = 1 << 1
+)
+
+// mdfWriter builds an MDF file in memory. Blocks are appended 8 byte aligned, links patched in
+// once the blocks they point to are written.
+type mdfWriter struct {
+	buf bytes.Buffer
+}
+
+// block appends a block and returns its offset. Links may be given as 0 and set later.
+func (m *mdfWriter) block(id string, links []uint64, data []byte) uint64 {
+	offset := uint64(m.buf.Len())
+	length := MDF_HEADER_SIZE + 8*len(links) + len(data)
+	m.buf.WriteString("##" + id)
+	m.buf.Write(make([]byte, 4))
+	binary.Write(&m.buf, binary.LittleEndian, uint64(length))
+	binary.Write(&m.buf, binary.LittleEndian, uint64(len(links)))
+	for _, link := range links {
+		binary.Write(&m.buf, binary.LittleEndian, link)
+	}
+	m.buf.Write(data)
+	if pad := length % 8; pad != 0 {
+		m.buf.Write(make([]byte, 8-pad))
+	}
+	return offset
+}
+
+// link sets a block's nth link
+func (m *mdfWriter) link(block uint64, n int, target uint64) {
+	binary.LittleEndian.PutUint64(m.buf.Bytes()[block+MDF_HEADER_SIZE+8*uint64(n):], target)
+}
+
+// text writes a TX block, or returns a nil link for an empty string
+func (m *mdfWriter) text(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	return m.block("TX", nil, append([]byte(s), 0))
+}
+
+func (m *mdfWriter) metadata(xml string) uint64 {
+	return m.block("MD", nil, append([]byte(xml), 0))
+}
+
+// channel writes a CN block for a float64 at byteOffset in the record
+func (m *mdfWriter) channel(name, unit string, kind, sync uint8, byteOffset, flags, invalBit uint32) uint64 {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, struct {
+		Type, Sync, DataType, BitOffset uint8
+		ByteOffset, BitCount            uint32
+		Flags, InvalBitPos              uint32
+		Precision, Reserved             uint8
+		AttachmentCount                 uint16
+		Ranges                          [6]float64
+	}{
+		Type: kind, Sync: sync, DataType: MDF_REAL_LE,
+		ByteOffset: byteOffset, BitCount: 64,
+		Flags: flags, InvalBitPos: invalBit,
+	})
+	// Links: next, composition, name, source, conversion, data, unit, comment
+	return m.block("CN", []uint64{0, 0, m.text(name), 0, 0, 0, m.text(unit), 0}, data.Bytes())
+}
+
+// writeWideMDF writes the table as an MDF4 file, with a master channel of seconds since the
+// log started and a float channel per column. Channels hold no value, their invalidation bit
+// set, until their first sample. It returns the rows written.
+func writeWideMDF(w io.Writer, table *wideTable, source string) (int, error) {
+	m := &mdfWriter{}
+	var id bytes.Buffer
+	id.WriteString("MDF     4.10    huskki  ")
+	id.Write(make([]byte, 4))
+	binary.Write(&id, binary.LittleEndian, uint16(MDF_VERSION))
+	id.Write(make([]byte, MDF_ID_SIZE-id.Len()))
+	m.buf.Write(id.Bytes())
+
+	now := uint64(time.Now().UnixNano())
+	var timeData bytes.Buffer
+	binary.Write(&timeData, binary.LittleEndian, now)
+	timeData.Write(make([]byte, 8))
+
+	// Links: first data group, file history, channel hierarchy, attachment, event, comment.
+	// The start time is unknown, so left at 0; timestamps are relative to the log's start.
+	hd := m.block("HD", make([]uint64, 6), make([]byte, 32))
+	m.link(hd, 5, m.metadata(fmt.Sprintf(`<HDcomment xmlns="http://www.asam.net/mdf/v4"><TX>Decoded from %s</TX></HDcomment>`, xmlEscape(source))))
+	fh := m.block("FH", []uint64{0, m.metadata(`<FHcomment xmlns="http://www.asam.net/mdf/v4"><TX>Exported</TX><tool_id>huskki</tool_id><tool_vendor>huskki</tool_vendor><tool_version>1</tool_version></FHcomment>`)}, timeData.Bytes())
+	m.link(hd, 1, fh)
+
+	// Records are the time, then each channel, then their invalidation bits
+	channels := len(table.channels)
+	invalBytes := (channels + 7) / 8
+	recordSize := 8 + 8*channels + invalBytes
+
+	master := m.channel("time", "s", MDF_CN_MASTER, MDF_SYNC_TIME, 0, 0, 0)
+	previous := master
+	for i, channel := range table.channels {
+		cn := m.channel(channel, table.units[i], MDF_CN_FIXED, MDF_SYNC_NONE, uint32(8+8*i), MDF_CN_INVAL_VALID, uint32(i))
+		m.link(previous, 0, cn)
+		previous = cn
+	}
+
+	var records bytes.Buffer
+	record := make([]byte, recordSize)
+	rows, err := table.Rows(func(timestamp int64, values []any) error {
+		clear(record)
+		binary.LittleEndian.PutUint64(record, math.Float64bits(float64(timestamp)/1000))
+		for i, value := range values {
+			var v float64
+			switch value := value.(type) {
+			case int:
+				v = float64(value)
+			case float64:
+				v = value
+			default:
+				record[8+8*channels+i/8] |= 1 << (i % 8)
+				continue
+			}
+			binary.LittleEndian.PutUint64(record[8+8*i:], math.Float64bits(v))
+		}
+		_, err := records.Write(record)
+		return err
+	})
+	if err != nil {
+		return rows, err
+	}
+	dt := m.block("DT", nil, records.Bytes())
+
+	var cgData bytes.Buffer
+	binary.Write(&cgData, binary.LittleEndian, struct {
+		RecordID, CycleCount  uint64
+		Flags, PathSeparator  uint16
+		Reserved              uint32
+		DataBytes, InvalBytes uint32
+	}{CycleCount: uint64(rows), DataBytes: uint32(8 + 8*channels), InvalBytes: uint32(invalBytes)})
+	// Links: next, first channel, acquisition name, source, sample reduction, comment
+	cg := m.block("CG", []uint64{0, master, m.text("huskki"), 0, 0, 0}, cgData.Bytes())
+	// Links: next, first channel group, data, comment. Records have no ID, there's one group.
+	dg := m.block("DG", []uint64{0, cg, dt, 0}, make([]byte, 8))
+	m.link(hd, 0, dg)
+
+	_, err = w.Write(m.buf.Bytes())
+	return rows, err
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// LATENCY_BUCKETS are upper bounds in milliseconds, suitable for frame → screen latency
+var LATENCY_BUCKETS = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// Histogram counts durations into fixed buckets, Prometheus style
+type Histogram struct {
+	Name string
+
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, last is +Inf
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+type Bucket struct {
+	Le    float64 `json:"le"` // upper bound in ms, +Inf for the last bucket
+	Count uint64  `json:"count"`
+}
+
+type HistogramSnapshot struct {
+	Name    string   `json:"name"`
+	Count   uint64   `json:"count"`
+	SumMs   float64  `json:"sumMs"`
+	MaxMs   float64  `json:"maxMs"`
+	P50Ms   float64  `json:"p50Ms"`
+	P90Ms   float64  `json:"p90Ms"`
+	P99Ms   float64  `json:"p99Ms"`
+	Buckets []Bucket `json:"-"` // cumulative
+}
+
+func NewHistogram(name string, bounds []float64) *Histogram {
+	return &Histogram{Name: name, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(h.bounds) && ms > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += ms
+	h.max = math.Max(h.max, ms)
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{Name: h.Name, Count: h.count, SumMs: h.sum, MaxMs: h.max}
+	var cumulative uint64
+	for i, c := range h.counts {
+		cumulative += c
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		s.Buckets = append(s.Buckets, Bucket{Le: le, Count: cumulative})
+	}
+	s.P50Ms, s.P90Ms, s.P99Ms = h.quantile(0.5), h.quantile(0.9), h.quantile(0.99)
+	return s
+}
+
+// quantile estimates by interpolating linearly within the bucket the rank falls in
+func (h *Histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var cumulative float64
+	for i, c := range h.counts {
+		if cumulative+float64(c) >= rank && c > 0 {
+			lower := 0.0
+			if i > 0 {
+				lower = h.bounds[i-1]
+			}
+			upper := h.max
+			if i < len(h.bounds) {
+				upper = math.Min(h.bounds[i], h.max)
+			}
+			return lower + (upper-lower)*(rank-cumulative)/float64(c)
+		}
+		cumulative += float64(c)
+	}
+	return h.max
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Prometheus writes metrics in Prometheus' text exposition format. Each metric's HELP and TYPE
+// are written before its first sample, so samples of a metric must be written together.
+type Prometheus struct {
+	w         io.Writer
+	described map[string]bool
+}
+
+func NewPrometheus(w io.Writer) *Prometheus {
+	return &Prometheus{w: w, described: map[string]bool{}}
+}
+
+// Gauge writes a sample of a gauge, labelled by pairs of names and values, e.g. "channel", "rpm"
+func (p *Prometheus) Gauge(name, help string, value float64, labels ...string) {
+	p.sample(name, "gauge", help, name, value, labels)
+}
+
+// Counter writes a sample of a counter, labelled as for Gauge
+func (p *Prometheus) Counter(name, help string, value float64, labels ...string) {
+	p.sample(name, "counter", help, name, value, labels)
+}
+
+// Histogram writes a histogram snapshot, converting its milliseconds to seconds
+func (p *Prometheus) Histogram(name, help string, s HistogramSnapshot, labels ...string) {
+	for _, b := range s.Buckets {
+		le := "+Inf"
+		if !math.IsInf(b.Le, 1) {
+			le = strconv.FormatFloat(b.Le/1000, 'g', -1, 64)
+		}
+		p.sample(name, "histogram", help, name+"_bucket", float64(b.Count), append(labels[:len(labels):len(labels)], "le", le))
+	}
+	p.sample(name, "histogram", help, name+"_sum", s.SumMs/1000, labels)
+	p.sample(name, "histogram", help, name+"_count", float64(s.Count), labels)
+}
+
+func (p *Prometheus) sample(name, kind, help, series string, value float64, labels []string) {
+	if !p.described[name] {
+		p.described[name] = true
+		fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabel(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		series += "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(p.w, "%s %s\n", series, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
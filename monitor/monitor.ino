@@ -1,6 +1,7 @@
 // ECU DID logger — Serial only (initial snapshot, then change-only)
 // Uses autowp/arduino-mcp2515. ISO-TP + UDS (0x22, 0x3E, 0x27).
 // Output rows to Serial (CSV): millis,DID,data_hex
+// Reads commands from huskki on Serial: $NAME[,arg...]*CRC (see huskki's frames package)
 //
 // Depends on your did_list.h providing:
 //   extern const uint16_t DID_LIST[] PROGMEM;
@@ -10,6 +11,7 @@
 #include <mcp2515.h>
 #include "did_list.h"
 
+#define FIRMWARE_VERSION "1.0.0"
 #define LOG_ONLY_ON_CHANGE 1   // after first snapshot, only log when payload changes
 
 // ===== Pins / CAN config =====
@@ -25,6 +27,8 @@ static const uint32_t CAN_ID_RSP = 0x7E8;
 #define SID_TesterPresent              0x3E
 #define SID_SecurityAccess             0x27
 #define SID_ReadDataByIdentifier       0x22
+#define SID_ReadDTCInformation         0x19
+#define SID_ClearDiagnosticInformation 0x14
 #define POS_OFFSET                     0x40
 #define SUB_ExtendedSession            0x03
 
@@ -64,6 +68,15 @@ static uint8_t  lastChkFast[FAST_COUNT];
 static uint8_t  lastLenFast[FAST_COUNT];
 static bool     loggedOnceFast[FAST_COUNT];
 
+// ===== Sampling config from huskki ($CFG), polled instead of the FAST list until reset =====
+#define MAX_CFG_DIDS 12
+struct CfgDid { uint16_t did; uint16_t intervalMs; unsigned long lastReq; };
+static CfgDid   cfgDids[MAX_CFG_DIDS];
+static size_t   cfgCount = 0;
+static uint8_t  lastChkCfg[MAX_CFG_DIDS];
+static uint8_t  lastLenCfg[MAX_CFG_DIDS];
+static bool     loggedOnceCfg[MAX_CFG_DIDS];
+
 static uint8_t  lastChkSlow[DID_COUNT];
 static uint8_t  lastLenSlow[DID_COUNT];
 static bool     loggedOnceSlow[DID_COUNT];
@@ -295,6 +308,149 @@ void logLine(uint16_t did, const uint8_t* data, uint16_t len) {
   Serial.println();
 }
 
+// ===== Commands from huskki =====
+// CRC-16/CCITT-FALSE, as used by commands and v2 rows
+uint16_t crc16(const char* s, size_t len) {
+  uint16_t crc = 0xFFFF;
+  for (size_t i = 0; i < len; i++) {
+    crc ^= (uint16_t)(uint8_t)s[i] << 8;
+    for (uint8_t b = 0; b < 8; b++) crc = (crc & 0x8000) ? (crc << 1) ^ 0x1021 : crc << 1;
+  }
+  return crc;
+}
+
+void printCRC(const char* body, size_t len) {
+  char crc[6];
+  snprintf(crc, sizeof(crc), "*%04X", crc16(body, len));
+  Serial.println(crc);
+}
+
+void reply(const char* body) {
+  Serial.print('$');
+  Serial.print(body);
+  printCRC(body, strlen(body));
+}
+
+#define TEST_DID 0xFFFF
+
+// v2 row: v2,micros,canID,DID,data_hex*CRC
+void logLineV2(uint16_t did, const uint8_t* data, uint16_t len) {
+  char row[96];
+  int n = snprintf(row, sizeof(row), "v2,%lu,0,0x%04X,", micros(), did);
+  static const char *digits = "0123456789ABCDEF";
+  for (uint16_t i = 0; i < len && n + 3 < (int)sizeof(row); i++) {
+    if (i) row[n++] = ' ';
+    row[n++] = digits[(data[i] >> 4) & 0xF];
+    row[n++] = digits[data[i] & 0xF];
+  }
+  row[n] = 0;
+  Serial.print(row);
+  printCRC(row, n);
+}
+
+// Known pattern for huskki -selftest: sequence number, then bytes that exercise every bit
+void selfTest(uint16_t count) {
+  for (uint16_t seq = 0; seq < count; seq++) {
+    uint8_t data[6] = { (uint8_t)(seq >> 8), (uint8_t)(seq & 0xFF), 0x55, 0xAA, 0x00, 0xFF };
+    logLineV2(TEST_DID, data, sizeof(data));
+  }
+}
+
+// Stored and pending codes as "DTC,XXXXXX:SS,...", as many as fit in a reply
+void readDTCs() {
+  uint8_t req[] = { SID_ReadDTCInformation, 0x02, 0xFF }; // reportDTCByStatusMask, any status
+  uint8_t rsp[256]; uint16_t rlen = 0;
+  if (!udsRequest(req, sizeof(req), rsp, rlen, sizeof(rsp)) || rlen < 3 ||
+      rsp[0] != (SID_ReadDTCInformation + POS_OFFSET)) {
+    reply("NACK,DTC");
+    return;
+  }
+  char body[160] = "DTC";
+  int n = 3;
+  for (uint16_t i = 3; i + 4 <= rlen && n + 11 < (int)sizeof(body); i += 4) {
+    n += snprintf(body + n, sizeof(body) - n, ",%02X%02X%02X:%02X", rsp[i], rsp[i + 1], rsp[i + 2], rsp[i + 3]);
+  }
+  reply(body);
+}
+
+void clearDTCs() {
+  uint8_t req[] = { SID_ClearDiagnosticInformation, 0xFF, 0xFF, 0xFF }; // all groups
+  uint8_t rsp[8]; uint16_t rlen = 0;
+  if (udsRequest(req, sizeof(req), rsp, rlen, sizeof(rsp)) && rlen >= 1 &&
+      rsp[0] == (SID_ClearDiagnosticInformation + POS_OFFSET)) {
+    reply("ACK,CLR");
+  } else {
+    reply("NACK,CLR");
+  }
+}
+
+void handleCommand(char* line) {
+  char* star = strchr(line, '*');
+  if (line[0] != '$' || !star) return;
+  char* body = line + 1;
+  size_t len = star - body;
+  if (crc16(body, len) != (uint16_t)strtoul(star + 1, nullptr, 16)) { reply("NACK,CRC"); return; }
+  *star = 0;
+
+  if (strncmp(body, "TEST,", 5) == 0) {
+    reply("ACK,TEST");
+    selfTest((uint16_t)atol(body + 5));
+    return;
+  }
+  if (strcmp(body, "DTC") == 0) { readDTCs(); return; }
+  if (strcmp(body, "CLR") == 0) { clearDTCs(); return; }
+  if (strcmp(body, "VER") == 0) { reply("VER," FIRMWARE_VERSION); return; }
+  if (strncmp(body, "CFG,", 4) == 0) { configure(body + 4); return; }
+  char* comma = strchr(body, ',');
+  if (comma) *comma = 0;
+  char nack[32];
+  snprintf(nack, sizeof(nack), "NACK,%s", body);
+  reply(nack);
+}
+
+// "CFG,0100:50,0009:1000": DIDs in hex, each with its interval in ms. Anything invalid is
+// NACKed and leaves the logger on the FAST list.
+void configure(char* args) {
+  cfgCount = 0;
+  size_t n = 0;
+  for (char* tok = strtok(args, ","); tok; tok = strtok(nullptr, ",")) {
+    if (n == MAX_CFG_DIDS) { reply("NACK,CFG,TOO_MANY"); return; }
+    char* colon = strchr(tok, ':');
+    if (!colon) { reply("NACK,CFG,FORMAT"); return; }
+    *colon = 0;
+    char* end;
+    unsigned long did = strtoul(tok, &end, 16);
+    if (*end || end == tok || did > 0xFFFF) { reply("NACK,CFG,DID"); return; }
+    unsigned long interval = strtoul(colon + 1, &end, 10);
+    if (*end || interval < 10 || interval > 60000) { reply("NACK,CFG,INTERVAL"); return; }
+    cfgDids[n].did = (uint16_t)did;
+    cfgDids[n].intervalMs = (uint16_t)interval;
+    cfgDids[n].lastReq = 0;
+    loggedOnceCfg[n] = false;
+    n++;
+  }
+  if (n == 0) { reply("NACK,CFG,EMPTY"); return; }
+  cfgCount = n;
+  reply("ACK,CFG");
+}
+
+// Commands are only read between polls, so replies can lag by an ECU timeout
+void readCommands() {
+  static char cmd[160]; // room for a full $CFG
+  static uint8_t cmdLen = 0;
+  while (Serial.available()) {
+    char c = Serial.read();
+    if (c == '\r') continue;
+    if (c == '\n') {
+      cmd[cmdLen] = 0;
+      handleCommand(cmd);
+      cmdLen = 0;
+    } else if (cmdLen < sizeof(cmd) - 1) {
+      cmd[cmdLen++] = c;
+    }
+  }
+}
+
 // ===== Setup / Loop =====
 void setup() {
   Serial.begin(115200);
@@ -349,11 +505,23 @@ void pollOne(uint16_t did, uint8_t* lastChkArr, uint8_t* lastLenArr, bool* logge
 }
 
 void loop() {
+  readCommands();
+
   unsigned long now = millis();
   if (now - lastTP >= TESTER_PRESENT_PERIOD_MS) { testerPresent(); lastTP = now; }
 
+  // Configured DIDs, each at its own interval, one poll per loop so commands keep being read
+  if (cfgCount > 0) {
+    for (size_t i = 0; i < cfgCount; i++) {
+      if (now - cfgDids[i].lastReq >= cfgDids[i].intervalMs) {
+        cfgDids[i].lastReq = now;
+        pollOne(cfgDids[i].did, lastChkCfg, lastLenCfg, loggedOnceCfg, i);
+        break;
+      }
+    }
+  }
   // FAST round-robin
-  if (now - lastFastReq >= FAST_GAP_MS) {
+  else if (now - lastFastReq >= FAST_GAP_MS) {
     uint16_t did; memcpy_P(&did, &FAST_DIDS[fastIndex], sizeof(uint16_t));
     pollOne(did, lastChkFast, lastLenFast, loggedOnceFast, fastIndex);
     fastIndex = (fastIndex + 1) % FAST_COUNT;
@@ -0,0 +1,160 @@
+package obd
+
+import (
+	"math"
+	"strings"
+)
+
+const MODE_CURRENT_DATA = 0x01
+
+// PID is a standard SAE J1979 mode 01 parameter, mapped onto the huskki channel carrying the same signal
+type PID struct {
+	PID     byte
+	Name    string
+	Aliases []string // other names tools use for the same signal
+	Channel string
+	Unit    string
+	Bytes   int
+	Decode  func(data []byte) float64
+	Encode  func(value float64) []byte
+}
+
+// PIDs is the canonical signal dictionary shared by OBD adapters, importers and exports
+var PIDs = []PID{
+	{
+		PID:     0x05,
+		Name:    "Engine coolant temperature",
+		Aliases: []string{"coolant"},
+		Channel: "coolant",
+		Unit:    "°C",
+		Bytes:   1,
+		Decode:  func(d []byte) float64 { return float64(d[0]) - 40 },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v + 40)} },
+	},
+	{
+		PID:     0x0C,
+		Name:    "Engine RPM",
+		Channel: "rpm",
+		Unit:    "rpm",
+		Bytes:   2,
+		Decode:  func(d []byte) float64 { return float64(int(d[0])<<8|int(d[1])) / 4 },
+		Encode: func(v float64) []byte {
+			raw := int(math.Round(math.Max(0, math.Min(v*4, 0xFFFF))))
+			return []byte{byte(raw >> 8), byte(raw)}
+		},
+	},
+	{
+		PID:     0x0D,
+		Name:    "Vehicle speed",
+		Aliases: []string{"speed"},
+		Channel: "speed",
+		Unit:    "km/h",
+		Bytes:   1,
+		Decode:  func(d []byte) float64 { return float64(d[0]) },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v)} },
+	},
+	{
+		PID:     0x0F,
+		Name:    "Intake air temperature",
+		Aliases: []string{"iat", "intake temp"},
+		Channel: "iat",
+		Unit:    "°C",
+		Bytes:   1,
+		Decode:  func(d []byte) float64 { return float64(d[0]) - 40 },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v + 40)} },
+	},
+	{
+		PID:     0x11,
+		Name:    "Throttle position",
+		Aliases: []string{"absolute throttle"},
+		Channel: "tps",
+		Unit:    "%",
+		Bytes:   1,
+		Decode:  func(d []byte) float64 { return float64(d[0]) * 100 / 255 },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v * 255 / 100)} },
+	},
+	{
+		PID:     0x14,
+		Name:    "Oxygen sensor 1 voltage",
+		Aliases: []string{"o2 sensor", "o2 voltage"},
+		Channel: "o2",
+		Unit:    "V",
+		Bytes:   2,
+		Decode:  func(d []byte) float64 { return float64(d[0]) / 200 },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v * 200), 0xFF} },
+	},
+	{
+		PID:     0x24,
+		Name:    "Oxygen sensor 1 lambda",
+		Aliases: []string{"lambda", "equivalence ratio"},
+		Channel: "lambda",
+		Bytes:   4,
+		Decode:  func(d []byte) float64 { return float64(int(d[0])<<8|int(d[1])) * 2 / 65536 },
+		Encode: func(v float64) []byte {
+			raw := int(math.Round(math.Max(0, math.Min(v*65536/2, 0xFFFF))))
+			return []byte{byte(raw >> 8), byte(raw), 0, 0}
+		},
+	},
+	{
+		PID:     0x33,
+		Name:    "Absolute barometric pressure",
+		Aliases: []string{"baro", "barometric"},
+		Channel: "baro",
+		Unit:    "kPa",
+		Bytes:   1,
+		Decode:  func(d []byte) float64 { return float64(d[0]) },
+		Encode:  func(v float64) []byte { return []byte{clampByte(v)} },
+	},
+	{
+		PID:     0x42,
+		Name:    "Control module voltage",
+		Aliases: []string{"battery voltage", "voltage"},
+		Channel: "voltage",
+		Unit:    "V",
+		Bytes:   2,
+		Decode:  func(d []byte) float64 { return float64(int(d[0])<<8|int(d[1])) / 1000 },
+		Encode: func(v float64) []byte {
+			raw := int(math.Round(math.Max(0, math.Min(v*1000, 0xFFFF))))
+			return []byte{byte(raw >> 8), byte(raw)}
+		},
+	},
+}
+
+func ByPID(pid byte) (PID, bool) {
+	for _, p := range PIDs {
+		if p.PID == pid {
+			return p, true
+		}
+	}
+	return PID{}, false
+}
+
+func ByChannel(channel string) (PID, bool) {
+	for _, p := range PIDs {
+		if p.Channel == channel {
+			return p, true
+		}
+	}
+	return PID{}, false
+}
+
+// ByName finds the PID whose name or alias appears in a tool's column or signal name,
+// e.g. "Engine RPM(rpm)" or "Throttle Position(Manifold)(%)"
+func ByName(name string) (PID, bool) {
+	name = strings.ToLower(name)
+	for _, p := range PIDs {
+		if strings.Contains(name, strings.ToLower(p.Name)) {
+			return p, true
+		}
+		for _, alias := range p.Aliases {
+			if strings.Contains(name, alias) {
+				return p, true
+			}
+		}
+	}
+	return PID{}, false
+}
+
+func clampByte(v float64) byte {
+	return byte(math.Round(math.Max(0, math.Min(v, 255))))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	ds "github.com/starfederation/datastar-go/datastar"
+	"huskki/input"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long an output is held for unless asked otherwise, and the most it can be, after
+	// which it's handed back to the ECU
+	OUTPUT_DEFAULT_HOLD = 2 * time.Minute
+	OUTPUT_MAX_HOLD     = 10 * time.Minute
+)
+
+// IOControl takes over the ECU's outputs, if the input source can
+var IOControl input.IOController
+
+// Outputs are the ECU outputs that can be held from the outputs page, from -io-output
+var Outputs []*output
+
+// output is an ECU output, e.g. the cooling fan, and the state it's held at
+type output struct {
+	Name  string
+	DID   uint16
+	State []byte
+
+	mu    sync.Mutex
+	until time.Time // zero unless held
+	timer *time.Timer
+}
+
+type outputProps struct {
+	Name  string `json:"name"`
+	DID   string `json:"did"`
+	Held  bool   `json:"held"`
+	Until string `json:"until,omitempty"`
+}
+
+// parseOutputs parses comma separated name=DID:state outputs, the state in hex, e.g.
+// fan=0x0D31:01
+func parseOutputs(value string) ([]*output, error) {
+	var outputs []*output
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(s, "=")
+		didStr, stateStr, hasState := strings.Cut(target, ":")
+		if !ok || !hasState || name == "" {
+			return nil, fmt.Errorf("expected name=DID:state, e.g. fan=0x0D31:01, not %q", s)
+		}
+		did, err := strconv.ParseUint(didStr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%q: invalid DID %q", s, didStr)
+		}
+		state, err := hex.DecodeString(stateStr)
+		if err != nil || len(state) == 0 || len(state) > 3 {
+			return nil, fmt.Errorf("%q: expected a state of 1 to 3 bytes of hex, e.g. 01", s)
+		}
+		outputs = append(outputs, &output{Name: strings.ToLower(name), DID: uint16(did), State: state})
+	}
+	return outputs, nil
+}
+
+func findOutput(name string) *output {
+	i := slices.IndexFunc(Outputs, func(o *output) bool { return o.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return Outputs[i]
+}
+
+// hold takes over the output for d, after which it's released
+func (o *output) hold(d time.Duration) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := IOControl.HoldOutput(o.DID, o.State); err != nil {
+		return err
+	}
+	log.Printf("Holding %s for %s", o.Name, d)
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	until := time.Now().Add(d)
+	o.until = until
+	o.timer = time.AfterFunc(d, func() {
+		if err := o.releaseHeld(until); err != nil {
+			log.Printf("release %s: %v", o.Name, err)
+		}
+	})
+	return nil
+}
+
+// release hands the output back to the ECU, if held
+func (o *output) release() error {
+	return o.releaseHeld(time.Time{})
+}
+
+// releaseHeld releases the output if it's held, and held until until unless that's zero, so a
+// timer firing as the output's held again doesn't release it early
+func (o *output) releaseHeld(until time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.until.IsZero() || !until.IsZero() && !o.until.Equal(until) {
+		return nil
+	}
+	o.timer.Stop()
+	o.until, o.timer = time.Time{}, nil
+	log.Printf("Released %s", o.Name)
+	return IOControl.ReleaseOutput(o.DID)
+}
+
+func (o *output) props() outputProps {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	props := outputProps{Name: o.Name, DID: fmt.Sprintf("0x%04X", o.DID), Held: !o.until.IsZero()}
+	if props.Held {
+		props.Until = o.until.Format(time.TimeOnly)
+	}
+	return props
+}
+
+// releaseOutputs hands every output back to the ECU, e.g. on shutdown
+func releaseOutputs() {
+	for _, o := range Outputs {
+		if err := o.release(); err != nil {
+			log.Printf("release %s: %v", o.Name, err)
+		}
+	}
+}
+
+func outputsData() map[string]interface{} {
+	props := []outputProps{}
+	for _, o := range Outputs {
+		props = append(props, o.props())
+	}
+	data := map[string]interface{}{"outputs": props, "hold": OUTPUT_DEFAULT_HOLD.String()}
+	if IOControl == nil {
+		data["error"] = "the input source can't control the ECU's outputs, it needs -can"
+	}
+	return data
+}
+
+// OutputsHandler shows the outputs that can be held, e.g. to run the fan in the paddock
+func OutputsHandler(w http.ResponseWriter, r *http.Request) {
+	data := outputsData()
+	data["theme"], data["themes"] = resolveTheme(w, r), availableThemes()
+	if err := Templates.ExecuteTemplate(w, "outputs", data); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// OutputControlHandler holds or releases an output, e.g.
+// POST /api/output/fan/hold?confirm=fan&seconds=120. Holding has to be confirmed by naming the
+// output, and is released after seconds, OUTPUT_DEFAULT_HOLD if not given, up to
+// OUTPUT_MAX_HOLD. Called from the outputs page, the outputs are patched in.
+func OutputControlHandler(w http.ResponseWriter, r *http.Request) {
+	if IOControl == nil {
+		http.Error(w, "the input source can't control outputs", http.StatusServiceUnavailable)
+		return
+	}
+	o := findOutput(r.PathValue("name"))
+	if o == nil {
+		http.Error(w, "no such output, see -io-output", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch r.PathValue("action") {
+	case "hold":
+		if r.URL.Query().Get("confirm") != o.Name {
+			http.Error(w, fmt.Sprintf("confirm holding the output with ?confirm=%s", o.Name), http.StatusBadRequest)
+			return
+		}
+		d := OUTPUT_DEFAULT_HOLD
+		if seconds := r.URL.Query().Get("seconds"); seconds != "" {
+			n, perr := strconv.Atoi(seconds)
+			if perr != nil || n <= 0 || time.Duration(n)*time.Second > OUTPUT_MAX_HOLD {
+				http.Error(w, fmt.Sprintf("seconds must be 1..%d", int(OUTPUT_MAX_HOLD.Seconds())), http.StatusBadRequest)
+				return
+			}
+			d = time.Duration(n) * time.Second
+		}
+		err = o.hold(d)
+	case "release":
+		err = o.release()
+	default:
+		http.Error(w, "expected /api/output/{name}/hold or /api/output/{name}/release", http.StatusNotFound)
+		return
+	}
+
+	if r.Header.Get("Datastar-Request") != "true" {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	data := outputsData()
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	var fragment strings.Builder
+	if err := Templates.ExecuteTemplate(&fragment, "outputs.list", data); err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sse := ds.NewSSE(w, r)
+	if err := sse.PatchElements(fragment.String()); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// PARQUET_UNITS_KEY is the file metadata holding a JSON object of each column's unit, Parquet
+// columns being named after their channel alone so they're easy to refer to
+const PARQUET_UNITS_KEY = "huskki.units"
+
+// writeWideParquet writes the table as Parquet, with an int64 timestamp_ms column and an
+// optional double per channel, null until the channel's first sample. It returns the rows written.
+func writeWideParquet(w io.Writer, table *wideTable) (int, error) {
+	group := parquet.Group{"timestamp_ms": parquet.Int(64)}
+	unitsByChannel := map[string]string{}
+	for i, channel := range table.channels {
+		group[channel] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		if table.units[i] != "" {
+			unitsByChannel[channel] = table.units[i]
+		}
+	}
+	schema := parquet.NewSchema("ride", group)
+	unitsJSON, err := json.Marshal(unitsByChannel)
+	if err != nil {
+		return 0, err
+	}
+
+	// Columns are ordered by the schema, not the table
+	timestampColumn, _ := schema.Lookup("timestamp_ms")
+	columns := make([]int, len(table.channels))
+	for i, channel := range table.channels {
+		leaf, _ := schema.Lookup(channel)
+		columns[i] = leaf.ColumnIndex
+	}
+
+	writer := parquet.NewWriter(w, schema,
+		parquet.Compression(&zstd.Codec{}),
+		parquet.KeyValueMetadata(PARQUET_UNITS_KEY, string(unitsJSON)))
+	row := make(parquet.Row, len(table.channels)+1)
+	rows, err := table.Rows(func(timestamp int64, values []any) error {
+		row[timestampColumn.ColumnIndex] = parquet.Int64Value(int64(timestamp)).Level(0, 0, timestampColumn.ColumnIndex)
+		for i, value := range values {
+			switch v := value.(type) {
+			case int:
+				row[columns[i]] = parquet.DoubleValue(float64(v)).Level(0, 1, columns[i])
+			case float64:
+				row[columns[i]] = parquet.DoubleValue(v).Level(0, 1, columns[i])
+			default:
+				row[columns[i]] = parquet.NullValue().Level(0, 0, columns[i])
+			}
+		}
+		_, err := writer.WriteRows([]parquet.Row{row})
+		return err
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, writer.Close()
+}
@@ -0,0 +1,151 @@
+package quarantine
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_ENTRIES = 1000
+	// Warn when more than this fraction of a DID's frames are rejected
+	ERROR_BUDGET = 0.01
+	// Don't judge the budget until a DID has been seen this many times
+	MIN_FRAMES_FOR_BUDGET = 100
+)
+
+// Reject is a frame that decoded, but to a value that can't be right
+type Reject struct {
+	Time      time.Time
+	Timestamp int64
+	DID       uint16
+	Data      []byte
+	Channel   string
+	Value     any
+	Reason    string
+}
+
+func (r Reject) Hex() string {
+	return fmt.Sprintf("% X", r.Data)
+}
+
+type DIDStats struct {
+	DID        uint16
+	Frames     int
+	Rejects    int
+	Rate       float64
+	OverBudget bool
+}
+
+// Log keeps the most recent rejects in memory, and optionally appends every reject to a CSV file
+type Log struct {
+	mu      sync.Mutex
+	entries []Reject
+	frames  map[uint16]int
+	rejects map[uint16]int
+	warned  map[uint16]bool
+	file    *os.File
+	csv     *csv.Writer
+}
+
+// NewLog creates a reject log. If path is empty rejects are only kept in memory.
+func NewLog(path string) (*Log, error) {
+	l := &Log{frames: map[uint16]int{}, rejects: map[uint16]int{}, warned: map[uint16]bool{}}
+	if path == "" {
+		return l, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open reject log: %w", err)
+	}
+	l.file, l.csv = file, csv.NewWriter(file)
+	return l, nil
+}
+
+// Accept counts a frame for a DID that decoded within range
+func (l *Log) Accept(did uint16) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames[did]++
+}
+
+func (l *Log) Add(r Reject) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.frames[r.DID]++
+	l.rejects[r.DID]++
+	l.entries = append(l.entries, r)
+	if len(l.entries) > MAX_ENTRIES {
+		l.entries = l.entries[len(l.entries)-MAX_ENTRIES:]
+	}
+
+	if l.csv != nil {
+		err := l.csv.Write([]string{
+			r.Time.Format(time.RFC3339Nano),
+			strconv.FormatInt(r.Timestamp, 10),
+			fmt.Sprintf("0x%04X", r.DID),
+			hex.EncodeToString(r.Data),
+			r.Channel,
+			fmt.Sprintf("%v", r.Value),
+			r.Reason,
+		})
+		l.csv.Flush()
+		if err == nil {
+			err = l.csv.Error()
+		}
+		if err != nil {
+			log.Printf("write reject log: %v", err)
+		}
+	}
+
+	frames, rejects := l.frames[r.DID], l.rejects[r.DID]
+	if !l.warned[r.DID] && frames >= MIN_FRAMES_FOR_BUDGET && float64(rejects)/float64(frames) > ERROR_BUDGET {
+		l.warned[r.DID] = true
+		log.Printf("DID 0x%04X over error budget: %d of %d frames rejected, last: %s", r.DID, rejects, frames, r.Reason)
+	}
+}
+
+// Entries returns the retained rejects, newest first
+func (l *Log) Entries() []Reject {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Reject, len(l.entries))
+	for i, r := range l.entries {
+		out[len(out)-1-i] = r
+	}
+	return out
+}
+
+// Stats returns reject rates for every DID that has had at least one reject
+func (l *Log) Stats() []DIDStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []DIDStats
+	for did, rejects := range l.rejects {
+		frames := l.frames[did]
+		rate := float64(rejects) / float64(frames)
+		out = append(out, DIDStats{
+			DID:        did,
+			Frames:     frames,
+			Rejects:    rejects,
+			Rate:       rate,
+			OverBudget: frames >= MIN_FRAMES_FOR_BUDGET && rate > ERROR_BUDGET,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DID < out[j].DID })
+	return out
+}
+
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"huskki/hub"
+	"huskki/input"
+	"huskki/rawlog"
+	"huskki/summary"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RIDE_TIME_FORMAT = "2006-01-02T15-04-05"
+	RIDE_EXTENSION   = ".husk"
+	// DECODED_EXTENSION is the decoded log written alongside each ride with -log-jsonl
+	DECODED_EXTENSION = ".jsonl"
+
+	// RECORD_AUTO records from when huskki starts, RECORD_MANUAL only once started from the dashboard
+	RECORD_AUTO   = "auto"
+	RECORD_MANUAL = "manual"
+
+	// RECORDER_BUFFER is how many events the decoded log can fall behind by, e.g. while the disk
+	// is slow, before they're dropped rather than holding up the hub
+	RECORDER_BUFFER = 4096
+)
+
+// RECORDING_CHANNEL carries whether a ride is being recorded, one of the RECORDING_ states
+const RECORDING_CHANNEL = "recording"
+
+const (
+	RECORDING_STOPPED = "stopped"
+	RECORDING_ACTIVE  = "recording"
+	RECORDING_PAUSED  = "paused"
+)
+
+var (
+	ErrRecordingDisabled = errors.New("recording is disabled with -no-log")
+	ErrNotRecording      = errors.New("not recording")
+)
+
+var (
+	// Recording records the frames of a live input to ride logs
+	Recording *Recorder
+	// Firmware is the live logger, if the input source can ask it for its version
+	Firmware input.VersionReader
+)
+
+// defaultLogDir is where rides are recorded without -log-dir, the user's data directory for the OS
+func defaultLogDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "rides"
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "huskki", "rides")
+		}
+		return filepath.Join(home, "AppData", "Local", "huskki", "rides")
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "huskki", "rides")
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "huskki", "rides")
+	}
+	return filepath.Join(home, ".local", "share", "huskki", "rides")
+}
+
+// ridePath is the file a recording started at start is written to, the -log-file or a new ride
+// in the -log-dir
+func ridePath(flags *Flags, start time.Time, compression rawlog.Compression) string {
+	if flags.LogFile != "" {
+		return flags.LogFile
+	}
+	return filepath.Join(flags.LogDir, start.Format(RIDE_TIME_FORMAT)+RIDE_EXTENSION+compression.Extension())
+}
+
+// rideBase is a ride's path without its extension or its compression's, e.g. rides/x for
+// rides/x.husk.gz
+func rideBase(path string) string {
+	base := strings.TrimSuffix(path, rawlog.CompressionOf(path).Extension())
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// decodedPath is the decoded log alongside the ride at path, its extension swapped for
+// DECODED_EXTENSION
+func decodedPath(path string, compression rawlog.Compression) string {
+	return rideBase(path) + DECODED_EXTENSION + compression.Extension()
+}
+
+// decodedEvent is a line of the decoded log
+type decodedEvent struct {
+	Channel   string    `json:"channel"`
+	Value     any       `json:"value"`
+	Unit      string    `json:"unit,omitempty"`
+	Timestamp *int64    `json:"timestamp,omitempty"`
+	Received  time.Time `json:"received,omitzero"`
+	Source    string    `json:"source,omitempty"`
+}
+
+// recordsOnStart is whether to start recording as soon as huskki starts, rather than from the
+// dashboard. Unless there's a -log-file, replays and the simulator aren't recorded since
+// they're already recorded or made up.
+func recordsOnStart(flags *Flags) bool {
+	switch {
+	case flags.NoLog, flags.Record == RECORD_MANUAL:
+		return false
+	case flags.LogFile != "":
+		return true
+	case flags.ReplayFile != "", flags.Simulate, flags.Candump != "" && flags.Candump != "-":
+		return false
+	}
+	return true
+}
+
+// Recorder starts, pauses and stops recording rides, broadcasting each change of state on
+// RECORDING_CHANNEL. Each ride's summary is saved alongside it when it stops, and with
+// -log-jsonl the hub's events are also recorded, decoded, to a log alongside it.
+type Recorder struct {
+	flags       *Flags
+	compression rawlog.Compression
+
+	mu      sync.Mutex
+	log     *rawlog.Log
+	decoded *rawlog.Log
+	summary *summary.Builder
+	// started is when the ride started by Clock, corrected once the clock's known. began is
+	// by the system clock, whose monotonic reading is kept to correct started from.
+	started time.Time
+	began   time.Time
+	paused  bool
+	// diskLow is set by guardDiskSpace while there isn't room to record
+	diskLow bool
+	// resume is set when the ignition going off ended the ride, so another starts when it's
+	// back on. Pausing or stopping from the dashboard clears it.
+	resume bool
+}
+
+type RecordingStatus struct {
+	State   string     `json:"state"`
+	File    string     `json:"file,omitempty"`
+	Started *time.Time `json:"started,omitempty"`
+}
+
+func NewRecorder(flags *Flags) (*Recorder, error) {
+	if flags.Record != RECORD_AUTO && flags.Record != RECORD_MANUAL {
+		return nil, fmt.Errorf("-record: unknown mode %q, expected %s or %s", flags.Record, RECORD_AUTO, RECORD_MANUAL)
+	}
+	compression, err := rawlog.ParseCompression(flags.LogCompress)
+	if err != nil {
+		return nil, fmt.Errorf("-log-compress: %w", err)
+	}
+	r := &Recorder{flags: flags, compression: compression}
+	id, ch, _ := EventHub.SubscribeWith(hub.SubscribeOptions{Buffer: RECORDER_BUFFER, Policy: hub.DROP_NEWEST})
+	EventHub.SetName(id, "recorder")
+	go func() {
+		for event := range ch {
+			r.writeEvent(event)
+		}
+	}()
+	return r, nil
+}
+
+// Start opens a new ride log, headed with its metadata, or resumes a paused one. The firmware
+// version is noted once the logger answers, which can take a while after it resets on connect.
+func (r *Recorder) Start() error {
+	changed, err := r.start()
+	if changed {
+		r.broadcast()
+	}
+	return err
+}
+
+// start is Start under r.mu, reporting whether the recording state changed. The change is
+// broadcast once r.mu is released, as the recorder's own subscriber takes it.
+func (r *Recorder) start() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.begin()
+}
+
+// begin starts or resumes the ride, called with r.mu held
+func (r *Recorder) begin() (bool, error) {
+	if r.flags.NoLog {
+		return false, ErrRecordingDisabled
+	}
+	if r.diskLow {
+		return false, ErrDiskFull
+	}
+	if r.log != nil {
+		if !r.paused {
+			return false, nil
+		}
+		r.paused = false
+		r.log.Note("resume", Clock.Now().Format(time.RFC3339))
+		return true, nil
+	}
+
+	began, start := time.Now(), Clock.Now()
+	path := ridePath(r.flags, start, r.compression)
+	l, err := rawlog.Open(path, r.compression)
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Recording to %s", path)
+	r.log, r.started, r.began, r.paused = l, start, began, false
+	r.summary = summary.NewBuilder(filepath.Base(path), start)
+	if err := l.IndexEvery(rawlog.INDEX_INTERVAL); err != nil {
+		log.Print(err)
+	}
+	if r.flags.LogJSONL {
+		if r.decoded, err = rawlog.Open(decodedPath(path, r.compression), r.compression); err != nil {
+			log.Printf("decoded log: %v", err)
+		}
+	}
+
+	l.Note("start", start.Format(time.RFC3339))
+	if r.flags.Profile != "" {
+		l.Note("profile", r.flags.Profile)
+	}
+	if Firmware != nil {
+		go func() {
+			version, err := Firmware.FirmwareVersion()
+			// Not connected yet
+			for errors.Is(err, input.ErrClosed) {
+				time.Sleep(time.Second)
+				version, err = Firmware.FirmwareVersion()
+			}
+			if err != nil {
+				log.Printf("firmware version: %v", err)
+				return
+			}
+			l.Note("firmware", version)
+		}()
+	}
+	return true, nil
+}
+
+// Pause stops writing frames to the ride log, until it's resumed with Start
+func (r *Recorder) Pause() error {
+	r.mu.Lock()
+	if r.log == nil {
+		r.mu.Unlock()
+		return ErrNotRecording
+	}
+	r.resume = false
+	changed := !r.paused
+	if changed {
+		r.paused = true
+		r.log.Note("pause", Clock.Now().Format(time.RFC3339))
+	}
+	r.mu.Unlock()
+
+	if changed {
+		r.broadcast()
+	}
+	return nil
+}
+
+// Stop ends the ride log. The next Start begins a new one.
+func (r *Recorder) Stop() error {
+	changed, err := r.stop()
+	if changed {
+		r.broadcast()
+	}
+	return err
+}
+
+// stop is Stop under r.mu, reporting whether a ride was being recorded
+func (r *Recorder) stop() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resume = false
+	return r.end()
+}
+
+// end closes the ride, called with r.mu held
+func (r *Recorder) end() (bool, error) {
+	if r.log == nil {
+		return false, nil
+	}
+	r.log.Note("end", Clock.Now().Format(time.RFC3339))
+	err := r.log.Close()
+	if derr := r.decoded.Close(); err == nil {
+		err = derr
+	}
+	log.Printf("Stopped recording to %s", r.log.Path())
+	if serr := summary.Save(summary.Path(r.log.Path()), r.summary.Summary()); serr != nil {
+		log.Printf("save ride summary: %v", serr)
+	}
+	r.log, r.decoded, r.paused = nil, nil, false
+	return true, err
+}
+
+// CorrectStart rewrites the ride's start once Clock has learned the true time from source,
+// if it was out. The ride log is noted with the corrected start, which replays take over its
+// start note, and the summary saved when it stops has it.
+func (r *Recorder) CorrectStart(source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log == nil {
+		return
+	}
+	start := Clock.Now().Add(-time.Since(r.began))
+	if start.Sub(r.started).Abs() < CLOCK_TOLERANCE {
+		return
+	}
+	log.Printf("Ride started at %s by the %s, not %s", start.Format(time.RFC3339), source, r.started.Format(time.RFC3339))
+	r.started = start
+	r.log.Note("clock", start.Format(time.RFC3339))
+	r.summary.SetStart(start)
+}
+
+// Ignition splits rides at the ignition: turning it off ends the ride being recorded, and
+// turning it back on starts the next. A ride paused or stopped from the dashboard is left be.
+func (r *Recorder) Ignition(on bool) {
+	var (
+		changed bool
+		err     error
+	)
+	r.mu.Lock()
+	switch {
+	case on && r.resume:
+		r.resume = false
+		changed, err = r.begin()
+	case !on && r.log != nil && !r.paused:
+		changed, err = r.end()
+		r.resume = true
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		log.Printf("split ride at ignition: %v", err)
+	}
+	if changed {
+		r.broadcast()
+	}
+}
+
+// Write records a frame, unless stopped or paused. A nil Recorder records nothing.
+func (r *Recorder) Write(frame frames.Frame) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log != nil && !r.paused {
+		r.log.Write(frame)
+		r.summary.Frames(1)
+	}
+}
+
+// writeEvent adds an event to the ride's summary and decoded log, unless stopped or paused
+func (r *Recorder) writeEvent(event hub.SensorEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log == nil || r.paused {
+		return
+	}
+	r.summary.Add(event)
+	if r.decoded == nil || !LogFilter.Allows(event) {
+		return
+	}
+	line := decodedEvent{Channel: event.Channel, Value: event.Value, Unit: event.Unit, Received: event.Received, Source: event.Source}
+	if event.HasTimestamp {
+		line.Timestamp = &event.Timestamp
+	}
+	r.decoded.WriteJSON(line)
+}
+
+func (r *Recorder) Status() RecordingStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status()
+}
+
+func (r *Recorder) status() RecordingStatus {
+	switch {
+	case r.log == nil:
+		return RecordingStatus{State: RECORDING_STOPPED}
+	case r.paused:
+		return RecordingStatus{State: RECORDING_PAUSED, File: filepath.Base(r.log.Path()), Started: &r.started}
+	}
+	return RecordingStatus{State: RECORDING_ACTIVE, File: filepath.Base(r.log.Path()), Started: &r.started}
+}
+
+// broadcast sends the recording state, which mustn't be done while holding r.mu. It's read
+// afresh so whichever of two changes broadcasts last sends the current state.
+func (r *Recorder) broadcast() {
+	EventHub.Broadcast(hub.Status(RECORDING_CHANNEL, r.Status().State))
+}
@@ -0,0 +1,125 @@
+package rawlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is how a log is compressed as it's written
+type Compression string
+
+const (
+	NONE Compression = "none"
+	GZIP Compression = "gzip"
+	ZSTD Compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case NONE, GZIP, ZSTD:
+		return c, nil
+	case "":
+		return NONE, nil
+	}
+	return NONE, fmt.Errorf("unknown compression %q, expected none, gzip or zstd", s)
+}
+
+// Extension is added to the name of logs compressed this way
+func (c Compression) Extension() string {
+	switch c {
+	case GZIP:
+		return ".gz"
+	case ZSTD:
+		return ".zst"
+	}
+	return ""
+}
+
+// CompressionOf is how a log named path is compressed, going by its extension
+func CompressionOf(path string) Compression {
+	switch {
+	case strings.HasSuffix(path, GZIP.Extension()):
+		return GZIP
+	case strings.HasSuffix(path, ZSTD.Extension()):
+		return ZSTD
+	}
+	return NONE
+}
+
+// NewWriter compresses what's written to w. Close ends the compressed stream but doesn't close w.
+func NewWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	return newCompressor(w, c)
+}
+
+// compressor is a compressed stream that can be flushed so the log is readable up to the last
+// flush, e.g. after a crash
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type nopCompressor struct{ io.Writer }
+
+func (nopCompressor) Flush() error { return nil }
+func (nopCompressor) Close() error { return nil }
+
+func newCompressor(w io.Writer, c Compression) (compressor, error) {
+	switch c {
+	case GZIP:
+		return gzip.NewWriter(w), nil
+	case ZSTD:
+		return zstd.NewWriter(w)
+	}
+	return nopCompressor{w}, nil
+}
+
+// NewReader reads a log whether or not it's compressed, telling gzip and zstd apart by their
+// magic numbers. A compressed log cut short, e.g. by a crash, reads up to where it was cut.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return truncated{gz, gz.Close}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return truncated{zr, func() error { zr.Close(); return nil }}, nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// truncated ends a compressed stream at an unexpected EOF rather than failing
+type truncated struct {
+	r     io.Reader
+	close func() error
+}
+
+func (t truncated) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (t truncated) Close() error {
+	return t.close()
+}
@@ -0,0 +1,171 @@
+package rawlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// INDEX_EXTENSION is added to a log's name for its index
+	INDEX_EXTENSION = ".idx"
+	// INDEX_INTERVAL is how many frames apart a log's index entries are
+	INDEX_INTERVAL = 1000
+)
+
+// IndexEntry is where in a log a frame starts. Offset is into the log's uncompressed rows, so
+// compressed logs still have to be decompressed up to it, but not parsed.
+type IndexEntry struct {
+	Millis int64
+	Offset int64
+}
+
+func IndexPath(path string) string {
+	return path + INDEX_EXTENSION
+}
+
+// IndexEvery writes an index entry for every nth frame from now on, to IndexPath of the log,
+// so replays can seek without reading the whole log
+func (l *Log) IndexEvery(n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Flushed so everything written so far can be read back to find the offset
+	if err := l.flush(); err != nil {
+		return fmt.Errorf("index raw log: %w", err)
+	}
+	offset, err := uncompressedSize(l.path)
+	if err != nil {
+		return fmt.Errorf("index raw log: %w", err)
+	}
+	index, err := os.OpenFile(IndexPath(l.path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("index raw log: %w", err)
+	}
+	l.index, l.indexEvery, l.offset = index, n, offset
+	return nil
+}
+
+// indexFrame adds an entry for the frame about to be written at the current offset, if it's due
+func (l *Log) indexFrame(millis int64) {
+	if l.index == nil {
+		return
+	}
+	if l.frames%l.indexEvery == 0 {
+		if _, err := fmt.Fprintf(l.index, "%d,%d\n", millis, l.offset); err != nil {
+			log.Printf("write raw log index %s: %v, no longer indexing", IndexPath(l.path), err)
+			l.index.Close()
+			l.index = nil
+			return
+		}
+	}
+	l.frames++
+}
+
+// uncompressedSize is the length of the rows already in a log being appended to
+func uncompressedSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r, err := NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(io.Discard, r)
+}
+
+// ReadIndex reads the index of the log at path, oldest first
+func ReadIndex(path string) ([]IndexEntry, error) {
+	file, err := os.Open(IndexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []IndexEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		millis, offset, ok := strings.Cut(scanner.Text(), ",")
+		m, merr := strconv.ParseInt(millis, 10, 64)
+		o, oerr := strconv.ParseInt(offset, 10, 64)
+		if !ok || merr != nil || oerr != nil {
+			return entries, fmt.Errorf("%s line %d: expected millis,offset", IndexPath(path), line)
+		}
+		entries = append(entries, IndexEntry{Millis: m, Offset: o})
+	}
+	return entries, scanner.Err()
+}
+
+// Seek returns the last entry at or before millis, or the start of the log if there's none.
+// Entries must be in millis order, as they are within a recording. A -log-file appended to by
+// several runs starts over each run, so can't be seeked by millis reliably.
+func Seek(entries []IndexEntry, millis int64) IndexEntry {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Millis > millis })
+	if i == 0 {
+		return IndexEntry{}
+	}
+	return entries[i-1]
+}
+
+// OpenAt reads the log at path from the indexed frame at or before millis, or from the start
+// if it has no index. Callers still skip any frames before millis themselves.
+func OpenAt(path string, millis int64) (io.ReadCloser, error) {
+	entries, err := ReadIndex(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	entry := Seek(entries, millis)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(zstdMagic))
+	n, _ := file.ReadAt(magic, 0)
+	compressed := bytes.HasPrefix(magic[:n], gzipMagic) || bytes.HasPrefix(magic[:n], zstdMagic)
+	if !compressed {
+		if _, err := file.Seek(entry.Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	r, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if compressed {
+		if _, err := io.CopyN(io.Discard, r, entry.Offset); err != nil && err != io.EOF {
+			r.Close()
+			file.Close()
+			return nil, err
+		}
+	}
+	return closers{r, file}, nil
+}
+
+// closers closes a log's reader and then its file
+type closers struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (c closers) Close() error {
+	err := c.ReadCloser.Close()
+	if ferr := c.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+// Package rawlog records every frame read from a live input as log rows, so a ride can be
+// replayed or re-decoded later with different decoders.
+package rawlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"huskki/frames"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FLUSH_INTERVAL bounds how much of the log is lost if huskki dies without closing it
+const FLUSH_INTERVAL = time.Second
+
+// Log appends frames to a file. A nil Log records nothing.
+type Log struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	comp   compressor
+	w      *bufio.Writer
+	done   chan struct{}
+	failed bool
+
+	// Indexing, if enabled: the offset of the next row and frames written since
+	index      *os.File
+	indexEvery int
+	offset     int64
+	frames     int
+}
+
+// Open creates or appends to the log at path, creating its directory if needed. Appending to a
+// compressed log adds another stream, which readers carry on into.
+func Open(path string, compression Compression) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create raw log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open raw log: %w", err)
+	}
+	comp, err := newCompressor(file, compression)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open raw log: %w", err)
+	}
+	l := &Log{path: path, file: file, comp: comp, w: bufio.NewWriter(comp), done: make(chan struct{})}
+	go l.flushEvery(FLUSH_INTERVAL)
+	return l, nil
+}
+
+func (l *Log) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Note writes metadata as a "# key: value" comment, which replays skip
+func (l *Log) Note(key, value string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writeLine(fmt.Sprintf("%s %s: %s", frames.COMMENT_PREFIX, key, value))
+}
+
+// Write appends a frame, as a v2 row if it carries a CAN ID or finer timestamp so they're kept.
+// Recording stops at the first error, e.g. the disk filling up, rather than failing every frame.
+func (l *Log) Write(f frames.Frame) {
+	if l == nil {
+		return
+	}
+	row := f.String()
+	if f.CANID != 0 || f.Micros != 0 && f.Micros != f.Millis*1000 {
+		row = f.V2()
+		// Indexed at the millis the row reads back as
+		if f.Micros != 0 {
+			f.Millis = f.Micros / 1000
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.failed {
+		l.indexFrame(f.Millis)
+	}
+	l.writeLine(row)
+}
+
+// WriteJSON appends v as a line of JSON, for logs of decoded events rather than frames
+func (l *Log) WriteJSON(v any) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("write raw log %s: %v", l.path, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writeLine(string(line))
+}
+
+// writeLine is called with mu held
+func (l *Log) writeLine(line string) {
+	if l.failed {
+		return
+	}
+	if _, err := fmt.Fprintln(l.w, line); err != nil {
+		l.failed = true
+		log.Printf("write raw log %s: %v, no longer recording", l.path, err)
+		return
+	}
+	l.offset += int64(len(line)) + 1
+}
+
+// Close flushes and closes the file. Anything written afterwards is dropped.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	close(l.done)
+	err := l.w.Flush()
+	if cerr := l.comp.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	if l.index != nil {
+		if cerr := l.index.Close(); err == nil {
+			err = cerr
+		}
+	}
+	l.file, l.failed = nil, true
+	return err
+}
+
+func (l *Log) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			err := l.flush()
+			l.mu.Unlock()
+			if err != nil {
+				log.Printf("flush raw log: %v", err)
+			}
+		}
+	}
+}
+
+func (l *Log) flush() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.comp.Flush()
+}
@@ -0,0 +1,91 @@
+package rawlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Slice writes the rows of the log at path from from to to after its first frame, verbatim so
+// they replay exactly as recorded. The notes heading the log, e.g. its profile, are kept and
+// the log's index, if it has one, is used to skip to from. It returns the frames written.
+func Slice(w io.Writer, path string, from, to time.Duration) (int, error) {
+	bw := bufio.NewWriter(w)
+	first, err := copyHeader(bw, path)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(bw, "%s slice: %s to %s\n", frames.COMMENT_PREFIX, from, to)
+
+	start, end := first+from.Milliseconds(), first+to.Milliseconds()
+	r, err := OpenAt(path, start)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	written := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, frames.COMMENT_PREFIX) {
+			// Notes made during the slice, e.g. a pause
+			if written > 0 {
+				fmt.Fprintln(bw, line)
+			}
+			continue
+		}
+		frame, err := frames.Parse(line)
+		if err != nil || frame.Millis < start {
+			continue
+		}
+		if frame.Millis > end {
+			break
+		}
+		fmt.Fprintln(bw, line)
+		written++
+	}
+	if err := scanner.Err(); err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// copyHeader copies the notes before the first frame of the log at path, returning the first
+// frame's millis
+func copyHeader(w io.Writer, path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r, err := NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, frames.COMMENT_PREFIX) {
+			fmt.Fprintln(w, line)
+			continue
+		}
+		if frame, err := frames.Parse(line); err == nil {
+			return frame.Millis, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no frames in log")
+}
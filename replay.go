@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huskki/frames"
+	"huskki/hub"
+	"huskki/input"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/starfederation/datastar-go/datastar"
+)
+
+// REPLAY_REWIND_CHANNEL carries how many times the replay has gone back, each time -replay-loop
+// starts it over or it's seeked to earlier in the log
+const REPLAY_REWIND_CHANNEL = "replay_rewind"
+
+// Replay is the -replay file being played back, nil when reading a live source
+var Replay *input.File
+
+// replayStep is a frame stepped to, with its payload read a few ways for working out what an
+// unknown DID carries
+type replayStep struct {
+	Millis  int64  `json:"millis"`
+	DID     string `json:"did"`
+	Data    string `json:"data"`
+	Channel string `json:"channel,omitempty"`
+	Value   any    `json:"value,omitempty"`
+	// Bytes is each byte unsigned, Words each pair of bytes as a big endian uint16
+	Bytes []int `json:"bytes"`
+	Words []int `json:"words"`
+}
+
+// lastStep is shown by the dashboard's replay controls
+var lastStep struct {
+	sync.Mutex
+	step *replayStep
+}
+
+func newReplayStep(frame input.Frame) *replayStep {
+	step := &replayStep{Millis: frame.Millis, DID: fmt.Sprintf("0x%04X", frame.DID), Data: fmt.Sprintf("% X", frame.Data)}
+	if channel, value, ok := frames.Decode(frame.DID, frame.Data); ok {
+		step.Channel, step.Value = channel, frames.Value(channel, value)
+	}
+	for _, b := range frame.Data {
+		step.Bytes = append(step.Bytes, int(b))
+	}
+	for i := 0; i+1 < len(frame.Data); i += 2 {
+		step.Words = append(step.Words, int(binary.BigEndian.Uint16(frame.Data[i:])))
+	}
+	return step
+}
+
+// REPLAY_SPEEDS are offered by the dashboard's replay controls, any speed between
+// input.MIN_REPLAY_SPEED and input.MAX_REPLAY_SPEED can be set through the API
+var REPLAY_SPEEDS = []float64{0.5, 1, 2, 4, 8}
+
+type replayControlsProps struct {
+	Playing  bool
+	Ended    bool
+	Speed    float64
+	Speeds   []float64
+	Position string
+	Duration string
+	Seconds  int
+	Total    int
+	Step     *replayStep
+}
+
+// ReplayHandler reports where the replay is up to, e.g. GET /api/replay
+func ReplayHandler(w http.ResponseWriter, _ *http.Request) {
+	if Replay == nil {
+		http.Error(w, "not replaying a log", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Replay.Status()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ReplayStepHandler pauses the replay and advances it a single frame, responding with the frame
+// decoded, e.g. POST /api/replay/step
+func ReplayStepHandler(w http.ResponseWriter, _ *http.Request) {
+	if Replay == nil {
+		http.Error(w, "not replaying a log", http.StatusNotFound)
+		return
+	}
+	frame, err := Replay.Step()
+	if errors.Is(err, input.ErrReplayEnded) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	step := newReplayStep(frame)
+	log.Printf("step %d ms %s [%s] %s %v u8 %v u16 %v", step.Millis, step.DID, step.Data, step.Channel, step.Value, step.Bytes, step.Words)
+	lastStep.Lock()
+	lastStep.step = step
+	lastStep.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(step); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ReplayControlHandler plays, pauses, seeks or changes the speed of the replay, e.g.
+// POST /api/replay/play, /api/replay/seek?to=12m30s or /api/replay/speed?x=4
+func ReplayControlHandler(w http.ResponseWriter, r *http.Request) {
+	if Replay == nil {
+		http.Error(w, "not replaying a log", http.StatusNotFound)
+		return
+	}
+	switch r.PathValue("action") {
+	case "play":
+		Replay.Play()
+	case "pause":
+		Replay.Pause()
+	case "seek":
+		to, err := time.ParseDuration(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, "expected ?to= a duration into the log, e.g. 12m30s", http.StatusBadRequest)
+			return
+		}
+		Replay.Seek(to)
+	case "speed":
+		speed, err := strconv.ParseFloat(r.URL.Query().Get("x"), 64)
+		if err == nil {
+			err = Replay.SetSpeed(speed)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "expected /api/replay/play, /api/replay/pause, /api/replay/seek or /api/replay/speed", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renderReplayControls renders the dashboard's replay controls, empty when not replaying
+func renderReplayControls() string {
+	if Replay == nil {
+		return ""
+	}
+	status := Replay.Status()
+	lastStep.Lock()
+	step := lastStep.step
+	lastStep.Unlock()
+	var writer strings.Builder
+	Templates.ExecuteTemplate(&writer, "replay.controls", replayControlsProps{
+		Playing:  status.Playing,
+		Ended:    status.Ended,
+		Speed:    status.Speed,
+		Speeds:   REPLAY_SPEEDS,
+		Position: formatReplayTime(status.Position),
+		Duration: formatReplayTime(status.Duration),
+		Seconds:  int(status.Position.Seconds()),
+		Total:    int(status.Duration.Seconds()),
+		Step:     step,
+	})
+	return writer.String()
+}
+
+// buildReplayClockScript has the dashboard's charts follow the replay, on the ride's own timeline
+// if the log says when it was recorded, rather than the browser's clock
+func buildReplayClockScript() string {
+	if Replay == nil {
+		return ""
+	}
+	status := Replay.Status()
+	if status.First < 0 {
+		return ""
+	}
+	epoch := "null"
+	if !status.Started.IsZero() {
+		epoch = strconv.FormatInt(status.Started.UnixMilli()-int64(status.First), 10)
+	}
+	millis := status.First + status.Position.Milliseconds()
+	return fmt.Sprintf(`replayClock(%s, %d, %g, %t);`, epoch, millis, status.Speed, status.Playing)
+}
+
+// syncReplayClock sends the replay's clock to a dashboard, if replaying
+func syncReplayClock(sse *ds.ServerSentEventGenerator) error {
+	script := buildReplayClockScript()
+	if script == "" {
+		return nil
+	}
+	return sse.ExecuteScript(script)
+}
+
+// isReplayRewind is whether event is the replay going back just now. The value retained by the
+// hub for new subscribers has no Received time, and their charts have nothing stale to clear.
+func isReplayRewind(event hub.SensorEvent) bool {
+	return event.Channel == REPLAY_REWIND_CHANNEL && !event.Received.IsZero()
+}
+
+// formatReplayTime formats d as minutes and seconds, e.g. 12:07
+func formatReplayTime(d time.Duration) string {
+	seconds := int(d.Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
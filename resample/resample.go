@@ -0,0 +1,114 @@
+// Package resample aligns channels that arrive at different poll rates onto a common timebase,
+// so a row holds every channel's value at the same instant.
+package resample
+
+import (
+	"fmt"
+	"huskki/hub"
+	"math"
+)
+
+// Mode is how a channel's value is estimated between its samples
+type Mode int
+
+const (
+	// HOLD repeats the last sample until the next one arrives
+	HOLD Mode = iota
+	// LINEAR interpolates between the samples either side
+	LINEAR
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "hold":
+		return HOLD, nil
+	case "linear":
+		return LINEAR, nil
+	}
+	return HOLD, fmt.Errorf("unknown resample mode %q, expected hold or linear", s)
+}
+
+type sample struct {
+	t    int64
+	v    float64
+	unit string
+	// float is whether the channel's values are float64, ints are interpolated to ints
+	float bool
+}
+
+// Aligner turns a stream of hub events into rows every Step ms of logger time, each row being
+// an event per channel at the same timestamp. A row is only emitted once an event has arrived
+// after it, so with LINEAR each channel can be interpolated towards its next sample; channels
+// without one yet are held.
+type Aligner struct {
+	step int64
+	mode Mode
+	last map[string]sample
+	next int64
+}
+
+func NewAligner(step int, mode Mode) *Aligner {
+	return &Aligner{step: int64(max(step, 1)), mode: mode, last: map[string]sample{}, next: -1}
+}
+
+// Push adds a timestamped event and returns the rows it completes. Events without a timestamp,
+// or that aren't numbers, are ignored.
+func (a *Aligner) Push(event hub.SensorEvent) []hub.SensorEvent {
+	v, ok := event.Float()
+	if !event.HasTimestamp || !ok {
+		return nil
+	}
+	_, float := event.Value.(float64)
+	ts := event.Timestamp
+	incoming := sample{t: ts, v: v, unit: event.Unit, float: float}
+
+	if a.next < 0 {
+		a.next = ts - ts%a.step
+	}
+	var rows []hub.SensorEvent
+	for ; a.next < ts; a.next += a.step {
+		for channel, last := range a.last {
+			value := last.v
+			if channel == event.Channel {
+				value = a.valueAt(a.next, last, incoming)
+			}
+			var v any = value
+			if !last.float {
+				v = int(math.Round(value))
+			}
+			rows = append(rows, hub.SensorEvent{Channel: channel, Value: v, Unit: last.unit, Timestamp: a.next, HasTimestamp: true})
+		}
+	}
+
+	a.last[event.Channel] = incoming
+	return rows
+}
+
+// Reset forgets the channels' samples, for when the timeline goes back, e.g. a replay seeking
+// back, so rows start afresh from the next event rather than once it's caught up
+func (a *Aligner) Reset() {
+	a.last, a.next = map[string]sample{}, -1
+}
+
+func (a *Aligner) valueAt(t int64, last, next sample) float64 {
+	if a.mode != LINEAR || next.t <= last.t {
+		return last.v
+	}
+	frac := float64(t-last.t) / float64(next.t-last.t)
+	return last.v + frac*(next.v-last.v)
+}
+
+// Align resamples a recorded series of events in one go. Events that can't be aligned, e.g.
+// markers, are passed through as they are.
+func Align(events []hub.SensorEvent, step int, mode Mode) []hub.SensorEvent {
+	a := NewAligner(step, mode)
+	var rows []hub.SensorEvent
+	for _, event := range events {
+		if _, ok := event.Float(); !ok || !event.HasTimestamp {
+			rows = append(rows, event)
+			continue
+		}
+		rows = append(rows, a.Push(event)...)
+	}
+	return rows
+}
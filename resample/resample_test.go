@@ -0,0 +1,74 @@
+package resample
+
+import (
+	"huskki/hub"
+	"reflect"
+	"testing"
+)
+
+func event(channel string, value any, timestamp int64) hub.SensorEvent {
+	return hub.SensorEvent{Channel: channel, Value: value, Timestamp: timestamp, HasTimestamp: true}
+}
+
+// values gathers the rows' values of a channel in order
+func values(rows []hub.SensorEvent, channel string) []any {
+	var got []any
+	for _, row := range rows {
+		if row.Channel == channel {
+			got = append(got, row.Value)
+		}
+	}
+	return got
+}
+
+func TestAlign(t *testing.T) {
+	// Each channel is interpolated towards its next sample as that arrives, and held otherwise
+	events := []hub.SensorEvent{
+		event("voltage", 12.0, 0),
+		event("rpm", 1000, 0),
+		event("voltage", 13.0, 100),
+		event("rpm", 2000, 100),
+		event("voltage", 14.0, 200),
+		event("rpm", 3000, 200),
+		event("rpm", 4000, 300),
+	}
+	for _, tt := range []struct {
+		mode    Mode
+		rpm     []any
+		voltage []any
+	}{
+		{HOLD, []any{1000, 1000, 2000, 2000, 3000, 3000}, []any{12.0, 12.0, 13.0, 13.0, 14.0, 14.0}},
+		{LINEAR, []any{1000, 1000, 2000, 2000, 3000, 3500}, []any{12.0, 12.5, 13.0, 13.5, 14.0, 14.0}},
+	} {
+		rows := Align(events, 50, tt.mode)
+		if got := values(rows, "rpm"); !reflect.DeepEqual(got, tt.rpm) {
+			t.Errorf("mode %d: rpm aligned to %v, want %v", tt.mode, got, tt.rpm)
+		}
+		if got := values(rows, "voltage"); !reflect.DeepEqual(got, tt.voltage) {
+			t.Errorf("mode %d: voltage aligned to %v, want %v", tt.mode, got, tt.voltage)
+		}
+	}
+}
+
+func TestAlignPassesThroughMarkers(t *testing.T) {
+	marker := event("marker", "pit", 50)
+	rows := Align([]hub.SensorEvent{event("rpm", 1000, 0), marker, event("rpm", 2000, 100)}, 50, HOLD)
+	if got := values(rows, "marker"); !reflect.DeepEqual(got, []any{"pit"}) {
+		t.Errorf("markers aligned to %v, want [pit]", got)
+	}
+}
+
+// TestAlignerReset checks rows pick up straight away after a rewind, rather than waiting for
+// the timeline to catch up with where it was
+func TestAlignerReset(t *testing.T) {
+	a := NewAligner(50, HOLD)
+	a.Push(event("rpm", 1000, 5000))
+	a.Push(event("rpm", 2000, 5100))
+
+	a.Reset()
+	a.Push(event("rpm", 3000, 100))
+	rows := a.Push(event("rpm", 4000, 200))
+	if len(rows) != 2 || rows[0].Timestamp != 100 || rows[0].Value != 3000 {
+		t.Errorf("after a reset got rows %v, want rpm at 100 and 150", rows)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"huskki/input"
+	"log"
+	"os"
+	"time"
+)
+
+// runSelfTest checks the serial link to the logger with a known pattern, printing a report and
+// exiting non-zero if it fails
+func runSelfTest(flags *Flags) {
+	report, err := input.SelfTest(flags.Port, flags.Baud, input.SELFTEST_FRAMES)
+	if err != nil {
+		log.Fatalf("self-test %s: %v", report.Port, err)
+	}
+
+	fmt.Printf("self-test %s @ %d\n", report.Port, report.Baud)
+	fmt.Printf("  ack latency     %s\n", report.Latency.Round(100*time.Microsecond))
+	fmt.Printf("  frames          %d/%d received, %d missing, %d out of order\n", report.Received, report.Expected, report.Missing, report.OutOfOrder)
+	fmt.Printf("  framing errors  %d\n", report.Malformed)
+	fmt.Printf("  CRC errors      %d\n", report.BadCRC)
+	fmt.Printf("  corrupt pattern %d\n", report.Corrupt)
+	fmt.Printf("  throughput      %.0f frames/s, %.1f kB/s\n", report.FramesPerSecond(), report.BytesPerSecond()/1000)
+	if !report.Passed() {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"huskki/hub"
+	"slices"
+	"sync"
+)
+
+// ChannelFilter controls which channels are passed on to sinks, independent of what the
+// dashboard displays. It is safe to change while sinks are running.
+type ChannelFilter struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+func NewChannelFilter() *ChannelFilter {
+	return &ChannelFilter{disabled: map[string]bool{}}
+}
+
+func (f *ChannelFilter) SetEnabled(channel string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if enabled {
+		delete(f.disabled, channel)
+	} else {
+		f.disabled[channel] = true
+	}
+}
+
+func (f *ChannelFilter) Enabled(channel string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !f.disabled[channel]
+}
+
+// Disabled lists the channels that currently have logging disabled
+func (f *ChannelFilter) Disabled() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var out []string
+	for channel := range f.disabled {
+		out = append(out, channel)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Allows reports whether an event's channel is passed on to sinks
+func (f *ChannelFilter) Allows(event hub.SensorEvent) bool {
+	return f.Enabled(event.Channel)
+}
@@ -0,0 +1,132 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"huskki/hub"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	INFLUX_MEASUREMENT     = "huskki"
+	INFLUX_REQUEST_TIMEOUT = 10 * time.Second
+)
+
+var (
+	influxTagEscaper    = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Influx writes events to InfluxDB's HTTP write API as line protocol, a point per event in the
+// huskki measurement tagged with its channel, unit and source. Numbers and status flags are
+// written as the value field, anything else as the text field.
+//
+// The URL is the write endpoint, /api/v2/write?org=...&bucket=... for InfluxDB 2 and later or
+// /write?db=... for 1.x.
+type Influx struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewInflux writes to the endpoint at rawURL, authenticating with token if it isn't empty
+func NewInflux(rawURL, token string) (*Influx, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse influx url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("influx url %q is not http or https", rawURL)
+	}
+	// Points are timestamped in millis, the resolution events are received at
+	query := u.Query()
+	query.Set("precision", "ms")
+	u.RawQuery = query.Encode()
+	return &Influx{url: u.String(), token: token, client: &http.Client{Timeout: INFLUX_REQUEST_TIMEOUT}}, nil
+}
+
+func (i *Influx) Name() string {
+	u, err := url.Parse(i.url)
+	if err != nil {
+		return "influx"
+	}
+	return "influx " + u.Host
+}
+
+func (i *Influx) Write(events []hub.SensorEvent) error {
+	var body bytes.Buffer
+	now := time.Now()
+	for _, event := range events {
+		writeInfluxLine(&body, event, now)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx write: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// writeInfluxLine appends an event as a line of line protocol, timestamped when it was
+// received. The logger's own timestamp is kept as the logger_ms field.
+func writeInfluxLine(buf *bytes.Buffer, event hub.SensorEvent, now time.Time) {
+	buf.WriteString(INFLUX_MEASUREMENT)
+	buf.WriteString(",channel=")
+	buf.WriteString(influxTagEscaper.Replace(event.Channel))
+	if event.Unit != "" {
+		buf.WriteString(",unit=")
+		buf.WriteString(influxTagEscaper.Replace(event.Unit))
+	}
+	if event.Source != "" {
+		buf.WriteString(",source=")
+		buf.WriteString(influxTagEscaper.Replace(event.Source))
+	}
+
+	switch v := event.Value.(type) {
+	case bool:
+		buf.WriteString(" value=")
+		if v {
+			buf.WriteString("1")
+		} else {
+			buf.WriteString("0")
+		}
+	default:
+		if f, ok := event.Float(); ok {
+			buf.WriteString(" value=")
+			buf.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+		} else {
+			buf.WriteString(` text="`)
+			buf.WriteString(influxStringEscaper.Replace(fmt.Sprint(v)))
+			buf.WriteString(`"`)
+		}
+	}
+	if event.HasTimestamp {
+		fmt.Fprintf(buf, ",logger_ms=%di", event.Timestamp)
+	}
+
+	at := event.Received
+	if at.IsZero() {
+		at = now
+	}
+	buf.WriteString(" ")
+	buf.WriteString(strconv.FormatInt(at.UnixMilli(), 10))
+	buf.WriteString("\n")
+}
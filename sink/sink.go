@@ -0,0 +1,212 @@
+package sink
+
+import (
+	"huskki/hub"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_QUEUE_SIZE  = 4096
+	DEFAULT_BATCH_SIZE  = 256
+	DEFAULT_MIN_BACKOFF = 500 * time.Millisecond
+	DEFAULT_MAX_BACKOFF = 30 * time.Second
+)
+
+// Sink is an external destination for hub events, e.g. InfluxDB or an MQTT broker.
+// Write is called with batches of events and should return an error if the batch
+// could not be delivered, in which case it will be retried.
+type Sink interface {
+	Name() string
+	Write(events []hub.SensorEvent) error
+}
+
+type Options struct {
+	// Filter, if set, removes channels that have had logging disabled
+	Filter *ChannelFilter
+	// Subscribe is how the sink's hub subscription copes with the sink falling behind,
+	// hub.DROP_OLDEST by default so it catches up on the latest
+	Subscribe  *hub.SubscribeOptions
+	QueueSize  int
+	BatchSize  int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type Stats struct {
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	Written   uint64 `json:"written"`
+	Dropped   uint64 `json:"dropped"`
+	Retries   uint64 `json:"retries"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Buffered gives a Sink its own bounded queue so that a slow or unreachable destination
+// never blocks the hub. Events that arrive while the queue is full are dropped and counted.
+type Buffered struct {
+	sink  Sink
+	opts  Options
+	queue chan hub.SensorEvent
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+	retries atomic.Uint64
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func NewBuffered(s Sink, opts Options) *Buffered {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DEFAULT_QUEUE_SIZE
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DEFAULT_BATCH_SIZE
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DEFAULT_MAX_BACKOFF, opts.MinBackoff)
+	}
+	if opts.Subscribe == nil {
+		opts.Subscribe = &hub.SubscribeOptions{Policy: hub.DROP_OLDEST}
+	}
+	return &Buffered{sink: s, opts: opts, queue: make(chan hub.SensorEvent, opts.QueueSize)}
+}
+
+// Enqueue adds an event to the queue without blocking.
+func (b *Buffered) Enqueue(event hub.SensorEvent) {
+	if b.opts.Filter != nil && !b.opts.Filter.Allows(event) {
+		return
+	}
+	select {
+	case b.queue <- event:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// Start subscribes to the hub and delivers its events to the sink in the background.
+// The returned function unsubscribes, makes one last attempt to write what's still queued
+// and returns once delivery has stopped.
+func (b *Buffered) Start(h *hub.EventHub) func() {
+	id, ch, cancel := h.SubscribeWith(*b.opts.Subscribe)
+	h.SetName(id, "sink "+b.sink.Name())
+	forwarded := make(chan struct{})
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(forwarded)
+		for event := range ch {
+			b.Enqueue(event)
+		}
+	}()
+	go func() {
+		defer close(stopped)
+		b.run(done)
+	}()
+
+	return func() {
+		cancel()
+		<-forwarded
+		close(done)
+		<-stopped
+	}
+}
+
+func (b *Buffered) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Stats{
+		Name:    b.sink.Name(),
+		Queued:  len(b.queue),
+		Written: b.written.Load(),
+		Dropped: b.dropped.Load(),
+		Retries: b.retries.Load(),
+	}
+	if b.lastErr != nil {
+		s.LastError = b.lastErr.Error()
+	}
+	return s
+}
+
+func (b *Buffered) run(done <-chan struct{}) {
+	batch := make([]hub.SensorEvent, 0, b.opts.BatchSize)
+	for {
+		select {
+		case <-done:
+			b.flush(batch[:0])
+			return
+		case event := <-b.queue:
+			batch = append(batch[:0], event)
+		}
+
+		batch = b.fill(batch)
+		if !b.deliver(batch, done) {
+			// Stopped while retrying, the batch gets one last go with the rest of the queue
+			b.flush(batch)
+			return
+		}
+	}
+}
+
+// fill adds whatever else is already waiting to the batch, up to the batch size
+func (b *Buffered) fill(batch []hub.SensorEvent) []hub.SensorEvent {
+	for len(batch) < b.opts.BatchSize {
+		select {
+		case event := <-b.queue:
+			batch = append(batch, event)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// flush writes the batch and what's left in the queue once the sink is stopped, without
+// retrying, so shutting down isn't held up by a sink that's unreachable
+func (b *Buffered) flush(batch []hub.SensorEvent) {
+	for {
+		if batch = b.fill(batch); len(batch) == 0 {
+			return
+		}
+		if err := b.sink.Write(batch); err != nil {
+			dropped := len(batch) + len(b.queue)
+			b.dropped.Add(uint64(dropped))
+			log.Printf("sink %s: dropped %d events on stopping: %v", b.sink.Name(), dropped, err)
+			return
+		}
+		b.written.Add(uint64(len(batch)))
+		batch = batch[:0]
+	}
+}
+
+// deliver writes the batch, retrying with exponential backoff until it succeeds or the sink
+// is stopped. New events keep queueing (and eventually dropping) while we wait.
+func (b *Buffered) deliver(batch []hub.SensorEvent, done <-chan struct{}) bool {
+	backoff := b.opts.MinBackoff
+	for {
+		err := b.sink.Write(batch)
+		b.mu.Lock()
+		b.lastErr = err
+		b.mu.Unlock()
+		if err == nil {
+			b.written.Add(uint64(len(batch)))
+			return true
+		}
+
+		b.retries.Add(1)
+		log.Printf("sink %s: write failed, retrying in %s: %v", b.sink.Name(), backoff, err)
+		select {
+		case <-done:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, b.opts.MaxBackoff)
+	}
+}
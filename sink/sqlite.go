@@ -0,0 +1,164 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"huskki/hub"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	time      INTEGER NOT NULL, -- wall clock, unix millis
+	timestamp INTEGER,          -- logger millis
+	channel   TEXT NOT NULL,
+	value     REAL,             -- numbers, and status flags as 0 or 1
+	text      TEXT,             -- anything else, e.g. undecoded DIDs' hex
+	unit      TEXT,
+	source    TEXT
+);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (time);
+CREATE INDEX IF NOT EXISTS samples_channel_time ON samples (channel, time);
+`
+
+// SQLite stores every event as a row of a samples table, indexed by time and by channel and
+// time, so rides can be queried with SQL
+type SQLite struct {
+	path string
+	db   *sql.DB
+}
+
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create sqlite schema in %s: %w", path, err)
+	}
+	return &SQLite{path: path, db: db}, nil
+}
+
+func (s *SQLite) Name() string {
+	return "sqlite " + s.path
+}
+
+// Write inserts the batch in one transaction
+func (s *SQLite) Write(events []hub.SensorEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO samples (time, timestamp, channel, value, text, unit, source) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, event := range events {
+		at := event.Received
+		if at.IsZero() {
+			at = now
+		}
+		var timestamp, value, text any
+		if event.HasTimestamp {
+			timestamp = event.Timestamp
+		}
+		switch v := event.Value.(type) {
+		case bool:
+			value = 0
+			if v {
+				value = 1
+			}
+		default:
+			if f, ok := event.Float(); ok {
+				value = f
+			} else {
+				text = fmt.Sprint(v)
+			}
+		}
+		if _, err := stmt.Exec(at.UnixMilli(), timestamp, event.Channel, value, text, event.Unit, event.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Sample is a stored event
+type Sample struct {
+	Time      time.Time
+	Timestamp int
+	Channel   string
+	Value     float64
+}
+
+// Query returns the numeric samples of the channels between from and to, oldest first. All
+// channels are returned if none are given.
+func (s *SQLite) Query(from, to time.Time, channels ...string) ([]Sample, error) {
+	query := `SELECT time, COALESCE(timestamp, 0), channel, value FROM samples WHERE time >= ? AND time <= ? AND value IS NOT NULL`
+	args := []any{from.UnixMilli(), to.UnixMilli()}
+	if len(channels) > 0 {
+		query += ` AND channel IN (?` + strings.Repeat(`, ?`, len(channels)-1) + `)`
+		for _, channel := range channels {
+			args = append(args, channel)
+		}
+	}
+	rows, err := s.db.Query(query+` ORDER BY time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var samples []Sample
+	for rows.Next() {
+		var sample Sample
+		var millis int64
+		if err := rows.Scan(&millis, &sample.Timestamp, &sample.Channel, &sample.Value); err != nil {
+			return nil, err
+		}
+		sample.Time = time.UnixMilli(millis)
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// Bucket summarises a channel's samples over a step of time starting at Time
+type Bucket struct {
+	Time  time.Time
+	Mean  float64
+	Min   float64
+	Max   float64
+	Count int
+}
+
+// Aggregate downsamples a channel's numeric samples between from and to into buckets of step,
+// oldest first. Buckets without samples are left out.
+func (s *SQLite) Aggregate(channel string, from, to time.Time, step time.Duration) ([]Bucket, error) {
+	rows, err := s.db.Query(`SELECT (time - ?) / ? AS bucket, AVG(value), MIN(value), MAX(value), COUNT(*) FROM samples
+		WHERE channel = ? AND time >= ? AND time <= ? AND value IS NOT NULL GROUP BY bucket ORDER BY bucket`,
+		from.UnixMilli(), step.Milliseconds(), channel, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var buckets []Bucket
+	for rows.Next() {
+		var bucket Bucket
+		var n int64
+		if err := rows.Scan(&n, &bucket.Mean, &bucket.Min, &bucket.Max, &bucket.Count); err != nil {
+			return nil, err
+		}
+		bucket.Time = from.Add(time.Duration(n) * step)
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"huskki/rawlog"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runSlice cuts the rows between two times of a recorded log into a new log, to share just
+// the interesting part of a ride. Times are since the log's first frame.
+//
+//	huskki slice -from 12m -to 15m [-o out.husk] ride.husk
+func runSlice(args []string) {
+	fs := flag.NewFlagSet("slice", flag.ExitOnError)
+	from := fs.Duration("from", 0, "start of the slice, since the log's first frame")
+	to := fs.Duration("to", 0, "end of the slice, since the log's first frame (default the end of the log)")
+	out := fs.String("o", "", "output log path, compressed if it ends .gz or .zst (default stdout)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("usage: huskki slice -from 12m -to 15m [-o out.husk] ride.husk")
+	}
+	end := *to
+	if end == 0 {
+		end = time.Duration(1<<63 - 1)
+	}
+	if end < *from {
+		log.Fatalf("-to %s is before -from %s", *to, *from)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	n, err := sliceLog(w, fs.Arg(0), *from, end, rawlog.CompressionOf(*out))
+	if err != nil {
+		log.Fatalf("slice %s: %v", fs.Arg(0), err)
+	}
+	log.Printf("sliced %d frames from %s", n, fs.Arg(0))
+}
+
+func sliceLog(w io.Writer, path string, from, to time.Duration, compression rawlog.Compression) (int, error) {
+	cw, err := rawlog.NewWriter(w, compression)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rawlog.Slice(cw, path, from, to)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// SliceHandler downloads part of a ride recorded to the -log-dir, e.g.
+// GET /api/slice?file=2025-06-01T10-00-00.husk&from=12m&to=15m
+func SliceHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// Only rides in the log directory can be sliced
+	name := filepath.Base(query.Get("file"))
+	from, ferr := time.ParseDuration(query.Get("from"))
+	to, terr := time.ParseDuration(query.Get("to"))
+	if name == "." || name == "/" || ferr != nil || terr != nil || to < from {
+		http.Error(w, "expected ?file=<ride>&from=<duration>&to=<duration>, e.g. from=12m&to=15m", http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(Recording.flags.LogDir, name)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "no such ride", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_%s-%s%s"`, rideBase(name), from, to, RIDE_EXTENSION))
+	if _, err := sliceLog(w, path, from, to, rawlog.NONE); err != nil {
+		fmt.Println(err)
+	}
+}
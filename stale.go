@@ -0,0 +1,76 @@
+package main
+
+import (
+	"huskki/hub"
+	"sync"
+	"time"
+)
+
+const (
+	STALE_CHECK_INTERVAL = 250 * time.Millisecond
+	// A channel is stale once it has missed this many of its usual updates
+	STALE_MISSED_UPDATES = 5
+	// Fast channels aren't flagged on a shorter gap than this, to ride out hiccups
+	MIN_STALE_AFTER = time.Second
+)
+
+// STALE_SUFFIX names the status channel carrying whether a channel has stopped updating
+const STALE_SUFFIX = "_stale"
+
+// StaleChannel is the status channel of channel's staleness, e.g. rpm_stale
+func StaleChannel(channel string) string {
+	return channel + STALE_SUFFIX
+}
+
+type staleTracker struct {
+	mu    sync.Mutex
+	stale map[string]bool
+}
+
+// Stale tracks which channels have stopped updating, see watchStale
+var Stale = &staleTracker{stale: map[string]bool{}}
+
+// Is reports whether a channel is stale. Channels that have never updated are.
+func (t *staleTracker) Is(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stale, seen := t.stale[channel]
+	return stale || !seen
+}
+
+// expectedWithin is how long a channel can go without an update before it's stale: a few of its
+// usual intervals, between MIN_STALE_AFTER and StaleAfter
+func expectedWithin(status hub.ChannelStatus) time.Duration {
+	if status.Interval == 0 {
+		return StaleAfter
+	}
+	return min(max(STALE_MISSED_UPDATES*status.Interval, MIN_STALE_AFTER), StaleAfter)
+}
+
+// watchStale broadcasts StaleChannel for every sensor channel whenever it stops or starts
+// updating again. The returned function stops it.
+func watchStale(h *hub.EventHub) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(STALE_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for channel, status := range h.ChannelStatus() {
+				stale := status.Age > expectedWithin(status)
+				Stale.mu.Lock()
+				was, seen := Stale.stale[channel]
+				Stale.stale[channel] = stale
+				Stale.mu.Unlock()
+				if stale != was || !seen {
+					h.Broadcast(hub.Status(StaleChannel(channel), stale))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
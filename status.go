@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"huskki/input"
+	"huskki/metrics"
+	"huskki/sink"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// End-to-end latency from a frame being read to it being broadcast on the hub, and to it
+// being written to a dashboard's SSE stream
+var (
+	BroadcastLatency = metrics.NewHistogram("receive_to_broadcast", metrics.LATENCY_BUCKETS)
+	SSELatency       = metrics.NewHistogram("receive_to_sse_write", metrics.LATENCY_BUCKETS)
+)
+
+func latencySnapshots() []metrics.HistogramSnapshot {
+	return []metrics.HistogramSnapshot{BroadcastLatency.Snapshot(), SSELatency.Snapshot()}
+}
+
+// StatusHandler shows internal health: the latency histograms, hub delivery counters and how
+// each sink is keeping up
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	err := Templates.ExecuteTemplate(w, "status", map[string]interface{}{
+		"theme":   resolveTheme(w, r),
+		"themes":  availableThemes(),
+		"latency": latencySnapshots(),
+		"hub":     EventHub.Stats(),
+		"sinks":   sinkStats(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func sinkStats() []sink.Stats {
+	var stats []sink.Stats
+	for _, s := range Sinks {
+		stats = append(stats, s.Stats())
+	}
+	return stats
+}
+
+// LatencyHandler returns the latency histogram summaries as JSON
+func LatencyHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(latencySnapshots()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// HubStatsHandler returns the hub's broadcast, delivery and drop counters as JSON, to spot a
+// subscriber that isn't keeping up
+func HubStatsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(EventHub.Stats()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// MetricsHandler exposes the latest value of every numeric channel as a gauge, alongside the
+// internal counters, in Prometheus' text format for scraping
+func MetricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p := metrics.NewPrometheus(w)
+
+	snapshot := EventHub.Snapshot()
+	channels := slices.Sorted(maps.Keys(snapshot))
+	for _, channel := range channels {
+		event := snapshot[channel]
+		value, ok := event.Float()
+		if b, isBool := event.Value.(bool); isBool {
+			value, ok = 0, true
+			if b {
+				value = 1
+			}
+		}
+		if ok {
+			p.Gauge("huskki_sensor_value", "Latest value of a channel, booleans as 0 or 1", value, "channel", channel, "unit", event.Unit)
+		}
+	}
+	status := EventHub.ChannelStatus()
+	for _, channel := range slices.Sorted(maps.Keys(status)) {
+		p.Gauge("huskki_sensor_age_seconds", "Time since a channel last updated", status[channel].Age.Seconds(), "channel", channel)
+	}
+
+	p.Counter("huskki_frames_read_total", "Frames read from the input source", float64(FramesRead.Load()))
+	p.Counter("huskki_crc_failures_total", "Logger rows skipped for a missing or mismatched CRC", float64(input.CRCFailures.Load()))
+
+	stats := EventHub.Stats()
+	p.Counter("huskki_hub_broadcasts_total", "Events broadcast on the hub", float64(stats.Broadcasts))
+	p.Counter("huskki_hub_delivered_total", "Events delivered to subscribers", float64(stats.Delivered))
+	p.Counter("huskki_hub_dropped_total", "Events dropped for subscribers that weren't keeping up", float64(stats.Dropped))
+	p.Counter("huskki_hub_limited_total", "Events skipped by subscribers' rate limits", float64(stats.Limited))
+	clients := map[string]int{"sse": 0, "websocket": 0}
+	for _, s := range stats.Subscribers {
+		switch {
+		case strings.HasPrefix(s.Name, "dashboard "):
+			clients["sse"]++
+		case strings.HasPrefix(s.Name, "websocket "):
+			clients["websocket"]++
+		}
+	}
+	for _, kind := range []string{"sse", "websocket"} {
+		p.Gauge("huskki_clients", "Connected dashboards and WebSocket clients", float64(clients[kind]), "kind", kind)
+	}
+
+	sinks := sinkStats()
+	for _, s := range sinks {
+		p.Counter("huskki_sink_written_total", "Events written by a sink", float64(s.Written), "sink", s.Name)
+	}
+	for _, s := range sinks {
+		p.Counter("huskki_sink_dropped_total", "Events a sink dropped", float64(s.Dropped), "sink", s.Name)
+	}
+	for _, s := range sinks {
+		p.Gauge("huskki_sink_queued", "Events waiting to be written by a sink", float64(s.Queued), "sink", s.Name)
+	}
+
+	for _, h := range latencySnapshots() {
+		p.Histogram("huskki_latency_seconds", "Latency from a frame being read to each stage", h, "stage", h.Name)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"huskki/summary"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runSummarize works out the summary of recorded rides, saving each alongside its log as
+// recording does when a ride stops, e.g. for rides recorded before summaries were
+//
+//	huskki summarize [-profile name] [-decoders file] [-dbc file] ride.husk...
+func runSummarize(args []string) {
+	fs := flag.NewFlagSet("summarize", flag.ExitOnError)
+	decoding := addDecodeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("usage: huskki summarize [-profile name] [-decoders file] [-dbc file] ride.husk...")
+	}
+	decoding.apply()
+
+	for _, path := range fs.Args() {
+		s, err := summarizeLog(path)
+		if err != nil {
+			log.Fatalf("summarize %s: %v", path, err)
+		}
+		if err := summary.Save(summary.Path(path), s); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %s, %d frames, max %.0f RPM, max %.0f °C coolant, %.1fs at WOT",
+			path, time.Duration(s.DurationSeconds*float64(time.Second)).Round(time.Second), s.Frames, s.MaxRPM, s.MaxCoolant, s.WOTSeconds)
+	}
+}
+
+func summarizeLog(path string) (summary.Summary, error) {
+	events, frames, err := decodeLog(path)
+	if err != nil {
+		return summary.Summary{}, err
+	}
+	// Rides are named after when they started
+	name := filepath.Base(path)
+	start, _ := time.ParseInLocation(RIDE_TIME_FORMAT, strings.SplitN(name, ".", 2)[0], time.Local)
+	b := summary.NewBuilder(name, start)
+	b.Frames(frames)
+	for _, event := range events {
+		b.Add(event)
+	}
+	return b.Summary(), nil
+}
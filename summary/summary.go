@@ -0,0 +1,150 @@
+// Package summary works out a ride's headline numbers, e.g. its top RPM and time at wide open
+// throttle, and keeps them alongside the ride's log for the sessions page.
+package summary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huskki/hub"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// WOT_TPS is the throttle position, in %, from which the throttle counts as wide open
+	WOT_TPS = 95
+	// EXTENSION replaces a ride log's extension for its summary
+	EXTENSION = ".summary.json"
+)
+
+type Summary struct {
+	// File is the ride log's name
+	File            string    `json:"file"`
+	Start           time.Time `json:"start,omitzero"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Frames          int       `json:"frames"`
+	MaxRPM          float64   `json:"maxRpm"`
+	MaxCoolant      float64   `json:"maxCoolant"`
+	WOTSeconds      float64   `json:"wotSeconds"`
+}
+
+// Builder accumulates a ride's frames and decoded events into its Summary. Durations are
+// measured on the logger's timeline.
+type Builder struct {
+	summary Summary
+
+	started     bool
+	first, last int64
+
+	wot   bool
+	wotAt int64
+}
+
+func NewBuilder(file string, start time.Time) *Builder {
+	return &Builder{summary: Summary{File: file, Start: start}}
+}
+
+// SetStart corrects when the ride started, e.g. once the clock's been set
+func (b *Builder) SetStart(start time.Time) {
+	b.summary.Start = start
+}
+
+// Frames counts n frames read from the logger
+func (b *Builder) Frames(n int) {
+	b.summary.Frames += n
+}
+
+// Add takes a decoded event into account. Status changes aren't on the logger's timeline, so
+// are ignored.
+func (b *Builder) Add(event hub.SensorEvent) {
+	if !event.HasTimestamp {
+		return
+	}
+	if !b.started {
+		b.started, b.first = true, event.Timestamp
+	}
+	b.last = max(b.last, event.Timestamp)
+
+	v, ok := event.Float()
+	if !ok {
+		return
+	}
+	switch event.Channel {
+	case "rpm":
+		b.summary.MaxRPM = max(b.summary.MaxRPM, v)
+	case "coolant":
+		b.summary.MaxCoolant = max(b.summary.MaxCoolant, v)
+	case "tps":
+		// Time at WOT runs from one throttle reading to the next while it's open
+		if b.wot {
+			b.summary.WOTSeconds += float64(event.Timestamp-b.wotAt) / 1000
+		}
+		b.wot, b.wotAt = v >= WOT_TPS, event.Timestamp
+	}
+}
+
+func (b *Builder) Summary() Summary {
+	s := b.summary
+	if b.started {
+		s.DurationSeconds = float64(b.last-b.first) / 1000
+	}
+	return s
+}
+
+// Path is where the summary of the ride logged to path is kept, e.g. ride.summary.json for
+// ride.husk.gz
+func Path(path string) string {
+	base := path
+	for _, ext := range []string{".gz", ".zst"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)) + EXTENSION
+}
+
+func Save(path string, s Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func Load(path string) (Summary, error) {
+	var s Summary
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// List loads the summaries of the rides in dir, newest first
+func List(dir string) ([]Summary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+EXTENSION))
+	if err != nil {
+		return nil, err
+	}
+	var summaries []Summary
+	for _, path := range paths {
+		s, err := Load(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("ride summary: %v", err)
+			continue
+		}
+		summaries = append(summaries, s)
+	}
+	slices.SortFunc(summaries, func(a, b Summary) int { return b.Start.Compare(a.Start) })
+	return summaries, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// requiredTemplates are executed directly by handlers, so must all be defined
+var requiredTemplates = []string{
+	"index", "card.value", "card.rate", "link.status", "voltage.low", "injector.duty.high", "recording.status", "disk.low",
+	"alert",
+	"replay.controls",
+	"throttle", "throttle.analysis", "throttle.calibration", "quarantine", "logging", "status", "diagnostics", "diagnostics.codes",
+	"outputs", "outputs.list",
+	"discover", "discover.dids", "sessions",
+}
+
+// TemplateError is set when the templates on disk couldn't be used and the fallback is being served
+var TemplateError error
+
+var templateFuncs = template.FuncMap{
+	"ToLower": strings.ToLower,
+	"mulf":    func(a, b float64) float64 { return a * b },
+	"templateError": func() string {
+		if TemplateError == nil {
+			return ""
+		}
+		return TemplateError.Error()
+	},
+}
+
+// loadTemplates parses the templates matching glob. If they fail to parse or any required
+// template is missing, the built-in fallback is returned along with the error, so the
+// dashboard, logging and API stay up on a broken deployment.
+func loadTemplates(glob string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseGlob(glob)
+	if err == nil {
+		var missing []string
+		for _, name := range requiredTemplates {
+			if t.Lookup(name) == nil {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			err = fmt.Errorf("missing templates: %s", strings.Join(missing, ", "))
+		}
+	}
+	if err != nil {
+		return template.Must(template.New("").Funcs(templateFuncs).Parse(fallbackTemplates)), err
+	}
+	return t, nil
+}
+
+// fallbackTemplates is a minimal cards-only dashboard. Chart updates are accepted and ignored.
+const fallbackTemplates = `
+{{ define "head" }}
+    <meta charset="utf-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1" />
+    <title>ECU Live</title>
+    <script type="module" src="https://cdn.jsdelivr.net/gh/starfederation/datastar@main/bundles/datastar.js"></script>
+    <style>
+        body { font-family: system-ui, sans-serif; margin: 2rem; display:flex; gap:1rem; flex-wrap:wrap; }
+        .card { padding:1.25rem 1.5rem; border-radius:14px; box-shadow:0 8px 24px rgba(0,0,0,.08); min-width:200px; }
+        .value { font-size:3rem; font-weight:700; }
+        .banner { flex:1 1 100%; color:#fff; background:#c0392b; padding:.75rem 1rem; border-radius:8px; }
+        .rate { float:right; font-size:.75rem; }
+        .card:has(.rate.stale) { opacity:.35; }
+    </style>
+    <script>
+    function pushData() {}
+    function pushDataBatch() {}
+    function pushMarker() {}
+    function replayClock() {}
+    function resetCharts() {}
+    function alarm() {}
+    </script>
+{{ end }}
+
+{{ define "banner" }}
+    <div class="banner">Dashboard templates failed to load, showing a minimal fallback: {{ templateError }}</div>
+{{ end }}
+
+{{ define "page" }}
+<!doctype html>
+<html lang="en">
+<head>{{ template "head" }}</head>
+<body>{{ template "banner" }}</body>
+</html>
+{{ end }}
+
+{{ define "index" }}
+<!doctype html>
+<html lang="en">
+<head>{{ template "head" }}</head>
+<body>
+<div data-on-load="@get('/events?channels={{ .channels }}', {openWhenHidden: true})"></div>
+{{ template "banner" }}
+<div id="link"></div>
+<div id="voltage-low"></div>
+<div id="injector-duty-high"></div>
+<div id="recording"></div>
+<div id="disk-low"></div>
+{{ range .alerts }}{{ template "alert" . }}{{ end }}
+<div id="replay"></div>
+{{ range .cards }}
+    <div class="card">
+        <div>{{ .Name }} <span id="{{ .Name | ToLower }}-rate" class="rate stale">no data</span></div>
+        <div class="value">{{ template "card.value" . }} {{ .Unit }}</div>
+    </div>
+{{ end }}
+</body>
+</html>
+{{ end }}
+
+{{ define "card.value" }}<span id="{{ .Name | ToLower }}">{{ .Value }}</span>{{ end }}
+
+{{ define "card.rate" }}<span id="{{ .Name | ToLower }}-rate" class="rate{{ if .Stale }} stale{{ end }}">{{ if .Seen }}{{ printf "%.1f" .Rate }} Hz{{ else }}no data{{ end }}</span>{{ end }}
+
+{{ define "link.status" }}<div id="link">{{ if . }}Connected{{ else }}Logger disconnected{{ end }}</div>{{ end }}
+
+{{ define "voltage.low" }}<div id="voltage-low">{{ if . }}Low voltage, check the charging system{{ end }}</div>{{ end }}
+
+{{ define "injector.duty.high" }}<div id="injector-duty-high">{{ if . }}Injector duty cycle high, running out of fuel headroom{{ end }}</div>{{ end }}
+
+{{ define "disk.low" }}<div id="disk-low">{{ if . }}Disk almost full, recording stopped{{ end }}</div>{{ end }}
+
+{{ define "alert" }}<div id="{{ .ID }}">{{ if .Firing }}{{ .Message }}{{ end }}</div>{{ end }}
+
+{{ define "recording.status" }}<div id="recording">{{ .State }} {{ .File }}</div>{{ end }}
+
+{{ define "replay.controls" }}<div id="replay">{{ .Position }} / {{ .Duration }}</div>{{ end }}
+
+{{ define "throttle" }}{{ template "page" }}{{ end }}
+{{ define "throttle.analysis" }}<div id="throttle-analysis"></div>{{ end }}
+{{ define "throttle.calibration" }}<div id="throttle-calibration"></div>{{ end }}
+{{ define "quarantine" }}{{ template "page" }}{{ end }}
+{{ define "logging" }}{{ template "page" }}{{ end }}
+{{ define "status" }}{{ template "page" }}{{ end }}
+{{ define "diagnostics" }}{{ template "page" }}{{ end }}
+{{ define "diagnostics.codes" }}<div id="dtc-codes"></div>{{ end }}
+{{ define "outputs" }}{{ template "page" }}{{ end }}
+{{ define "outputs.list" }}<div id="outputs"></div>{{ end }}
+{{ define "discover" }}{{ template "page" }}{{ end }}
+{{ define "sessions" }}{{ template "page" }}{{ end }}
+{{ define "discover.dids" }}<div id="discover-dids"></div>{{ end }}
+`
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_THEME = "default"
+	THEME_COOKIE  = "theme"
+)
+
+var (
+	// ThemeDirs are searched in order, so user supplied themes can override the built-in ones
+	ThemeDirs    = []string{"themes"}
+	DefaultTheme = DEFAULT_THEME
+
+	themeNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+// availableThemes lists the names of all stylesheets found in the theme directories
+func availableThemes() []string {
+	var themes []string
+	for _, dir := range ThemeDirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.css"))
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".css")
+			if themeNamePattern.MatchString(name) && !slices.Contains(themes, name) {
+				themes = append(themes, name)
+			}
+		}
+	}
+	slices.Sort(themes)
+	return themes
+}
+
+// resolveTheme picks the theme for a request. A ?theme= query parameter selects a theme and
+// remembers it in a cookie for that client, otherwise the cookie or the configured default is used.
+func resolveTheme(w http.ResponseWriter, r *http.Request) string {
+	themes := availableThemes()
+	if name := r.URL.Query().Get("theme"); slices.Contains(themes, name) {
+		http.SetCookie(w, &http.Cookie{
+			Name:    THEME_COOKIE,
+			Value:   name,
+			Path:    "/",
+			Expires: time.Now().AddDate(1, 0, 0),
+		})
+		return name
+	}
+	if cookie, err := r.Cookie(THEME_COOKIE); err == nil && slices.Contains(themes, cookie.Value) {
+		return cookie.Value
+	}
+	return DefaultTheme
+}
+
+// ThemeHandler serves a theme stylesheet by name from the first theme directory that has it
+func ThemeHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(r.PathValue("name"), ".css")
+	if !themeNamePattern.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	for _, dir := range ThemeDirs {
+		path := filepath.Join(dir, name+".css")
+		if _, err := os.Stat(path); err == nil {
+			http.ServeFile(w, r, path)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"huskki/throttle"
+	"net/http"
+	"strings"
+	"time"
+
+	ds "github.com/starfederation/datastar-go/datastar"
+)
+
+const THROTTLE_ANALYSIS_INTERVAL = 500 * time.Millisecond
+
+var throttleChannels = []string{"grip", "throttle", "tps"}
+
+type throttleAnalysisProps struct {
+	throttle.Analysis
+	EngineRunning bool
+}
+
+func currentThrottleAnalysis() throttleAnalysisProps {
+	return throttleAnalysisProps{
+		Analysis:      ThrottleRecorder.Analysis(),
+		EngineRunning: ThrottleRecorder.EngineRunning(),
+	}
+}
+
+// ThrottleHandler serves the throttle tester page
+func ThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	err := Templates.ExecuteTemplate(w, "throttle", map[string]interface{}{
+		"theme":       resolveTheme(w, r),
+		"themes":      availableThemes(),
+		"analysis":    currentThrottleAnalysis(),
+		"calibration": currentCalibration(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ThrottleEventsHandler streams every grip, throttle and TPS update to the tester chart
+// and periodically patches in the latest sweep analysis.
+func ThrottleEventsHandler(w http.ResponseWriter, r *http.Request) {
+	sse := ds.NewSSE(w, r)
+	defer Idle.Connect()()
+
+	id, ch, cancel := EventHub.Subscribe(throttleChannels...)
+	defer cancel()
+	EventHub.SetName(id, "throttle tester "+r.RemoteAddr)
+
+	ticker := time.NewTicker(THROTTLE_ANALYSIS_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			v, ok := event.Int()
+			if !ok || !event.HasTimestamp {
+				continue
+			}
+			if err := sse.ExecuteScript(buildUpdateChartScript(event.Channel, event.Timestamp, float64(v))); err != nil {
+				fmt.Println(err)
+				return
+			}
+		case <-ticker.C:
+			var writer strings.Builder
+			err := Templates.ExecuteTemplate(&writer, "throttle.analysis", currentThrottleAnalysis())
+			if err == nil {
+				err = sse.PatchElements(writer.String())
+			}
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	}
+}
+
+// ThrottleResetHandler discards the recorded sweeps so a fresh test can be started
+func ThrottleResetHandler(w http.ResponseWriter, _ *http.Request) {
+	ThrottleRecorder.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,222 @@
+package throttle
+
+import (
+	"huskki/hub"
+	"math"
+	"sync"
+)
+
+const (
+	MAX_SAMPLES = 20000
+	BINS        = 20
+	// TPS must move this many percentage points off its end stop before the grip is considered "live"
+	DEAD_ZONE_THRESHOLD = 1.0
+)
+
+type Sample struct {
+	Grip     float64
+	TPS      float64
+	Throttle float64
+	Opening  bool
+}
+
+// Analysis summarises a set of grip sweeps. Percentages are of grip travel for dead zones
+// and of TPS span for nonlinearity and asymmetry.
+type Analysis struct {
+	Samples      int
+	GripMin      float64
+	GripMax      float64
+	TPSMin       float64
+	TPSMax       float64
+	DeadZoneLow  float64
+	DeadZoneHigh float64
+	Nonlinearity float64
+	Asymmetry    float64
+}
+
+// Recorder collects grip/TPS/throttle samples from the hub while the engine is off,
+// for diagnosing ride-by-wire units with the ignition on.
+type Recorder struct {
+	mu            sync.Mutex
+	grip          float64
+	tps           float64
+	throttle      float64
+	haveGrip      bool
+	haveTPS       bool
+	haveThrottle  bool
+	opening       bool
+	engineRunning bool
+	samples       []Sample
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start subscribes the recorder to the hub. The returned function unsubscribes.
+func (r *Recorder) Start(h *hub.EventHub) func() {
+	id, ch, cancel := h.Subscribe()
+	h.SetName(id, "throttle recorder")
+	go func() {
+		for event := range ch {
+			r.Record(event)
+		}
+	}()
+	return cancel
+}
+
+func (r *Recorder) Record(event hub.SensorEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value, ok := event.Float()
+	if !ok {
+		return
+	}
+	changed := false
+	switch event.Channel {
+	case "rpm":
+		r.engineRunning = value > 0
+	case "throttle":
+		r.throttle, r.haveThrottle = value, true
+	case "grip":
+		if r.haveGrip && value != r.grip {
+			r.opening = value > r.grip
+		}
+		r.grip, r.haveGrip, changed = value, true, true
+	case "tps":
+		r.tps, r.haveTPS, changed = value, true, true
+	}
+
+	if !changed || r.engineRunning || !r.haveGrip || !r.haveTPS {
+		return
+	}
+	r.samples = append(r.samples, Sample{Grip: r.grip, TPS: r.tps, Throttle: r.throttle, Opening: r.opening})
+	if len(r.samples) > MAX_SAMPLES {
+		r.samples = r.samples[len(r.samples)-MAX_SAMPLES:]
+	}
+}
+
+// Latest returns the most recent raw grip and throttle readings, ok once both have been seen
+func (r *Recorder) Latest() (grip, throttle float64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.grip, r.throttle, r.haveGrip && r.haveThrottle
+}
+
+func (r *Recorder) EngineRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.engineRunning
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = nil
+}
+
+func (r *Recorder) Analysis() Analysis {
+	r.mu.Lock()
+	samples := make([]Sample, len(r.samples))
+	copy(samples, r.samples)
+	r.mu.Unlock()
+	return Analyse(samples)
+}
+
+// Analyse bins samples across the observed grip travel and looks for dead zones at either
+// end, deviation from a straight line, and differences between opening and closing.
+func Analyse(samples []Sample) Analysis {
+	a := Analysis{Samples: len(samples)}
+	if len(samples) == 0 {
+		return a
+	}
+
+	a.GripMin, a.GripMax = samples[0].Grip, samples[0].Grip
+	a.TPSMin, a.TPSMax = samples[0].TPS, samples[0].TPS
+	for _, s := range samples {
+		a.GripMin, a.GripMax = math.Min(a.GripMin, s.Grip), math.Max(a.GripMax, s.Grip)
+		a.TPSMin, a.TPSMax = math.Min(a.TPSMin, s.TPS), math.Max(a.TPSMax, s.TPS)
+	}
+	gripSpan, tpsSpan := a.GripMax-a.GripMin, a.TPSMax-a.TPSMin
+	if gripSpan == 0 || tpsSpan == 0 {
+		return a
+	}
+
+	var openSum, closeSum, allSum [BINS]float64
+	var openN, closeN, allN [BINS]int
+	for _, s := range samples {
+		bin := int((s.Grip - a.GripMin) / gripSpan * BINS)
+		if bin == BINS {
+			bin--
+		}
+		allSum[bin] += s.TPS
+		allN[bin]++
+		if s.Opening {
+			openSum[bin] += s.TPS
+			openN[bin]++
+		} else {
+			closeSum[bin] += s.TPS
+			closeN[bin]++
+		}
+	}
+
+	// Dead zones: grip travel at each end before TPS leaves its end stop
+	for i := 0; i < BINS; i++ {
+		if allN[i] > 0 && allSum[i]/float64(allN[i]) > a.TPSMin+DEAD_ZONE_THRESHOLD {
+			a.DeadZoneLow = float64(i) * 100 / BINS
+			break
+		}
+	}
+	for i := BINS - 1; i >= 0; i-- {
+		if allN[i] > 0 && allSum[i]/float64(allN[i]) < a.TPSMax-DEAD_ZONE_THRESHOLD {
+			a.DeadZoneHigh = float64(BINS-1-i) * 100 / BINS
+			break
+		}
+	}
+
+	// Nonlinearity: worst deviation of the binned response from a least-squares line
+	var xs, ys []float64
+	for i := 0; i < BINS; i++ {
+		if allN[i] > 0 {
+			xs = append(xs, float64(i))
+			ys = append(ys, allSum[i]/float64(allN[i]))
+		}
+	}
+	slope, intercept := fitLine(xs, ys)
+	for i := range xs {
+		dev := math.Abs(ys[i]-(slope*xs[i]+intercept)) * 100 / tpsSpan
+		a.Nonlinearity = math.Max(a.Nonlinearity, dev)
+	}
+
+	// Asymmetry: worst difference between opening and closing at the same grip position
+	for i := 0; i < BINS; i++ {
+		if openN[i] == 0 || closeN[i] == 0 {
+			continue
+		}
+		diff := math.Abs(openSum[i]/float64(openN[i])-closeSum[i]/float64(closeN[i])) * 100 / tpsSpan
+		a.Asymmetry = math.Max(a.Asymmetry, diff)
+	}
+
+	return a
+}
+
+func fitLine(xs, ys []float64) (slope, intercept float64) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, sy / n
+	}
+	slope = (n*sxy - sx*sy) / den
+	return slope, (sy - slope*sx) / n
+}
@@ -0,0 +1,51 @@
+package timeline
+
+import "log"
+
+const (
+	// Arduino millis() is an unsigned long, so it wraps after 2^32 ms (~49.7 days)
+	MILLIS_WRAP int64 = 1 << 32
+	// How close to the wrap point the previous reading must be for a jump backwards to count as a wrap
+	WRAP_WINDOW int64 = 60 * 1000
+)
+
+// Timeline turns the logger's millis readings into a continuous timeline, across millis
+// wrapping around and the MCU resetting (e.g. a brown-out), either of which would otherwise
+// show up as a huge negative jump.
+type Timeline struct {
+	started bool
+	last    int64
+	offset  int64
+	resets  int
+}
+
+func New() *Timeline {
+	return &Timeline{}
+}
+
+// Next returns the position of a raw millis reading on the continuous timeline. Readings and
+// the timeline are int64 so that it runs on past 2^31 ms on 32-bit targets too.
+func (t *Timeline) Next(raw int64) int64 {
+	if !t.started {
+		t.started, t.last = true, raw
+		return raw
+	}
+
+	if raw < t.last {
+		if t.last > MILLIS_WRAP-WRAP_WINDOW && raw < WRAP_WINDOW {
+			t.offset += MILLIS_WRAP
+		} else {
+			// Logger restarted, carry on from where we were rather than going back in time
+			log.Printf("logger millis went backwards (%d -> %d), assuming a reset", t.last, raw)
+			t.offset += t.last - raw
+			t.resets++
+		}
+	}
+	t.last = raw
+	return raw + t.offset
+}
+
+// Resets is how many times the logger has been seen to reset
+func (t *Timeline) Resets() int {
+	return t.resets
+}
@@ -0,0 +1,34 @@
+package timeline
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNext(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		raw  []int64
+		want []int64
+	}{
+		{"steady", []int64{100, 200, 300}, []int64{100, 200, 300}},
+		{"past 2^31", []int64{1<<31 - 10, 1 << 31, 1<<31 + 10}, []int64{1<<31 - 10, 1 << 31, 1<<31 + 10}},
+		{"wrap", []int64{MILLIS_WRAP - 20, MILLIS_WRAP - 5, 5, 20}, []int64{MILLIS_WRAP - 20, MILLIS_WRAP - 5, MILLIS_WRAP + 5, MILLIS_WRAP + 20}},
+		{"reset", []int64{5000, 6000, 50, 100}, []int64{5000, 6000, 6000, 6050}},
+		// A brown-out shortly before the wrap point is still a reset, the reading isn't near zero
+		{"reset near wrap", []int64{MILLIS_WRAP - 10, 2 * WRAP_WINDOW}, []int64{MILLIS_WRAP - 10, MILLIS_WRAP - 10}},
+		{"reset past 2^31", []int64{1<<31 + 1000, 200, 300}, []int64{1<<31 + 1000, 1<<31 + 1000, 1<<31 + 1100}},
+		{"wrap then reset", []int64{MILLIS_WRAP - 5, 5, 3, 13}, []int64{MILLIS_WRAP - 5, MILLIS_WRAP + 5, MILLIS_WRAP + 5, MILLIS_WRAP + 15}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			timeline := New()
+			var got []int64
+			for _, raw := range tt.raw {
+				got = append(got, timeline.Next(raw))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
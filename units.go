@@ -0,0 +1,43 @@
+package main
+
+import (
+	"huskki/units"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const UNITS_COOKIE = "units"
+
+// DefaultUnits are shown to clients that haven't picked any
+var DefaultUnits = units.METRIC
+
+// resolveUnits picks the unit system for a request. A ?units= query parameter selects one and
+// remembers it in a cookie for that client, otherwise the cookie or the configured default is used.
+func resolveUnits(w http.ResponseWriter, r *http.Request) units.System {
+	if system, err := units.Parse(r.URL.Query().Get("units")); err == nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:    UNITS_COOKIE,
+			Value:   string(system),
+			Path:    "/",
+			Expires: time.Now().AddDate(1, 0, 0),
+		})
+		return system
+	}
+	if cookie, err := r.Cookie(UNITS_COOKIE); err == nil {
+		if system, err := units.Parse(cookie.Value); err == nil {
+			return system
+		}
+	}
+	return DefaultUnits
+}
+
+// cardsIn relabels the dashboard's cards with their units under system
+func cardsIn(system units.System) []cardProps {
+	out := make([]cardProps, len(cards))
+	for i, card := range cards {
+		card.Unit = units.Unit(system, strings.ToLower(card.Name), card.Unit)
+		out[i] = card
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+// Package units converts channel values from the metric units they're decoded in to the
+// unit system a user has picked for display and export.
+package units
+
+import (
+	"fmt"
+	"math"
+)
+
+type System string
+
+const (
+	METRIC   System = "metric"
+	IMPERIAL System = "imperial"
+)
+
+var Systems = []System{METRIC, IMPERIAL}
+
+type conversion struct {
+	unit     string
+	convert  func(float64) float64
+	decimals int
+}
+
+func celsiusToFahrenheit(c float64) float64 { return c*9/5 + 32 }
+func kphToMph(kph float64) float64          { return kph * 0.621371 }
+func kpaToInHg(kpa float64) float64         { return kpa * 0.2953 }
+
+// imperial converts channels that aren't in the same units either way, by channel
+var imperial = map[string]conversion{
+	"coolant":     {"°F", celsiusToFahrenheit, 0},
+	"iat":         {"°F", celsiusToFahrenheit, 0},
+	"speed":       {"mph", kphToMph, 0},
+	"groundspeed": {"mph", kphToMph, 0},
+	"baro":        {"inHg", kpaToInHg, 1},
+}
+
+func Parse(s string) (System, error) {
+	for _, system := range Systems {
+		if string(system) == s {
+			return system, nil
+		}
+	}
+	return "", fmt.Errorf("unknown units %q, expected %s or %s", s, METRIC, IMPERIAL)
+}
+
+// Convert converts a channel's value, an int or float64 as broadcast, to system. Anything
+// else, and channels that don't need converting, are returned as they are.
+func Convert(system System, channel string, value any) any {
+	c, ok := imperial[channel]
+	if system != IMPERIAL || !ok {
+		return value
+	}
+	var v float64
+	switch value := value.(type) {
+	case int:
+		v = float64(value)
+	case float64:
+		v = value
+	default:
+		return value
+	}
+	pow := math.Pow10(c.decimals)
+	v = math.Round(c.convert(v)*pow) / pow
+	if c.decimals == 0 {
+		return int(v)
+	}
+	return v
+}
+
+// Unit is the unit a channel is shown in under system, given its metric unit
+func Unit(system System, channel, metric string) string {
+	if c, ok := imperial[channel]; ok && system == IMPERIAL {
+		return c.unit
+	}
+	return metric
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"huskki/hub"
+	"log"
+	"time"
+)
+
+// LOW_VOLTAGE_CHANNEL carries whether the supply voltage is low for whether the engine is running
+const LOW_VOLTAGE_CHANNEL = "voltage_low"
+
+const (
+	// A healthy regulator holds 13.5-14.5V with the engine running, a rested battery ~12.6V
+	LOW_VOLTAGE_RUNNING = 13.0
+	LOW_VOLTAGE_STOPPED = 12.0
+	// How long the voltage has to stay low before it's flagged, to ride out cranking
+	LOW_VOLTAGE_HOLD = 5 * time.Second
+	// How far above the threshold it has to recover before the alert clears
+	LOW_VOLTAGE_HYSTERESIS = 0.3
+)
+
+// watchVoltage broadcasts LOW_VOLTAGE_CHANNEL when the voltage sags below what the charging
+// system should manage, e.g. a dying regulator or battery. The returned function stops it.
+func watchVoltage(h *hub.EventHub) func() {
+	id, ch, cancel := h.Subscribe("voltage", "rpm")
+	h.SetName(id, "voltage")
+	go func() {
+		ticker := time.NewTicker(ALERT_CHECK_INTERVAL)
+		defer ticker.Stop()
+		rpm, voltage := 0, 0.0
+		low := threshold{hold: LOW_VOLTAGE_HOLD}
+		changed := func() {
+			if low.firing {
+				log.Printf("low voltage: %.1fV at %d RPM", voltage, rpm)
+			} else {
+				log.Printf("voltage recovered: %.1fV", voltage)
+			}
+			h.Broadcast(hub.Status(LOW_VOLTAGE_CHANNEL, low.firing))
+		}
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				if event.Channel == "rpm" {
+					rpm, _ = event.Int()
+					continue
+				}
+				v, ok := event.Float()
+				if !ok {
+					continue
+				}
+				voltage = v
+
+				limit := LOW_VOLTAGE_STOPPED
+				if rpm > 0 {
+					limit = LOW_VOLTAGE_RUNNING
+				}
+				if low.observe(voltage < limit, voltage >= limit+LOW_VOLTAGE_HYSTERESIS, time.Now()) {
+					changed()
+				}
+			case now := <-ticker.C:
+				if low.elapse(now) {
+					changed()
+				}
+			}
+		}
+	}()
+	return cancel
+}
@@ -1,14 +1,36 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	ds "github.com/starfederation/datastar-go/datastar"
+	"huskki/hub"
+	"huskki/resample"
+	"huskki/summary"
+	"huskki/units"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	DISABLE_CHARTS = false
+
+	CARD_STATUS_INTERVAL = time.Second
+	DEFAULT_STALE_AFTER  = 5 * time.Second
+)
+
+// StaleAfter is the longest a channel can go without an update before it's flagged stale and its
+// card greyed out. Channels that usually update faster are flagged sooner, see expectedWithin.
+var StaleAfter = DEFAULT_STALE_AFTER
+
+// AlignStep, if set, resamples channels onto a common timebase before they're sent to the
+// dashboard, so charts of channels polled at different rates line up
+var (
+	AlignStep time.Duration
+	AlignMode resample.Mode
 )
 
 type cardProps struct {
@@ -22,32 +44,137 @@ var cards = []cardProps{
 	{"Grip", 0, "%"},
 	{"TPS", 0, "%"},
 	{"RPM", 0, "RPM"},
+	{"Speed", 0, "km/h"},
 	{"Coolant", 0, "°C"},
+	{"Voltage", 0, "V"},
+	{"IAT", 0, "°C"},
+	{"Baro", 0, "kPa"},
+	{"O2", 0, "V"},
+	{"Lambda", 0, "λ"},
+	{"AFR", 0, ":1"},
+	{"Injector", 0, "ms"},
+	{"Injector_Duty", 0, "%"},
+}
+
+type cardRateProps struct {
+	Name  string
+	Seen  bool
+	Rate  float64
+	Stale bool
 }
 
 type chartProps struct {
 	Name        string
 	Description string
+	Unit        string
 }
 
-var charts = []chartProps{
-	{"TPS", "Throttle"},
-	{"RPM", "Revolutions Per Minute"},
+// charts are drawn for every card, their points backfilled from the hub's retained history
+var charts = chartsFor(cards)
+
+var chartDescriptions = map[string]string{
+	"throttle":      "Throttle Grip",
+	"grip":          "Grip Position",
+	"tps":           "Throttle Position Sensor",
+	"rpm":           "Revolutions Per Minute",
+	"speed":         "Wheel Speed",
+	"coolant":       "Coolant Temperature",
+	"voltage":       "Supply Voltage",
+	"iat":           "Intake Air Temperature",
+	"baro":          "Barometric Pressure",
+	"o2":            "O2 Sensor",
+	"lambda":        "Lambda",
+	"afr":           "Air Fuel Ratio",
+	"injector":      "Injector Pulse Width",
+	"injector_duty": "Injector Duty Cycle",
+}
+
+// chartsFor makes a chart of each card's channel
+func chartsFor(cards []cardProps) []chartProps {
+	out := make([]chartProps, len(cards))
+	for i, card := range cards {
+		description, ok := chartDescriptions[strings.ToLower(card.Name)]
+		if !ok {
+			description = card.Name
+		}
+		out[i] = chartProps{Name: card.Name, Description: description, Unit: card.Unit}
+	}
+	return out
+}
+
+// chartValue is the value of a point on a chart, from an int or float64 event
+func chartValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
 }
 
 // IndexHandler is the main entrypoint for the UI
-func IndexHandler(w http.ResponseWriter, _ *http.Request) {
+func IndexHandler(w http.ResponseWriter, r *http.Request) {
+	system := resolveUnits(w, r)
 	err := Templates.ExecuteTemplate(w, "index", map[string]interface{}{
-		"cards":         cards,
+		"channels":      strings.Join(dashboardChannels(), ","),
+		"theme":         resolveTheme(w, r),
+		"themes":        availableThemes(),
+		"units":         system,
+		"unitSystems":   units.Systems,
+		"cards":         cardsIn(system),
+		"alerts":        alertBanners(system),
 		"chartsEnabled": !DISABLE_CHARTS,
-		"tpsChartProps": chartProps{
-			Name:        "TPS",
-			Description: "Throttle Position Sensor",
-		},
-		"rpmChartProps": chartProps{
-			Name:        "RPM",
-			Description: "Revolutions Per Minute",
-		},
+		"charts":        chartsFor(cardsIn(system)),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// dashboardChannels lists the channels rendered by the index page's cards, charts, link and
+// recording status
+func dashboardChannels() []string {
+	channels := []string{LINK_CHANNEL, LOW_VOLTAGE_CHANNEL, INJECTOR_DUTY_HIGH_CHANNEL, RECORDING_CHANNEL, DISK_LOW_CHANNEL, MARKER_CHANNEL, REPLAY_REWIND_CHANNEL}
+	for _, rule := range Alerts {
+		channels = append(channels, rule.AlertChannel())
+	}
+	for _, card := range cards {
+		channels = append(channels, strings.ToLower(card.Name), StaleChannel(strings.ToLower(card.Name)))
+	}
+	for _, chart := range charts {
+		if name := strings.ToLower(chart.Name); !slices.Contains(channels, name) {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// QuarantineHandler lists frames that were rejected for decoding to out-of-range values
+func QuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	err := Templates.ExecuteTemplate(w, "quarantine", map[string]interface{}{
+		"theme":   resolveTheme(w, r),
+		"themes":  availableThemes(),
+		"stats":   Quarantine.Stats(),
+		"entries": Quarantine.Entries(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// SessionsHandler lists the summaries of the rides recorded to the -log-dir
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := summary.List(Recording.flags.LogDir)
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = Templates.ExecuteTemplate(w, "sessions", map[string]interface{}{
+		"theme":    resolveTheme(w, r),
+		"themes":   availableThemes(),
+		"sessions": sessions,
 	})
 	if err != nil {
 		fmt.Println(err)
@@ -56,21 +183,150 @@ func IndexHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 // EventsHandler is called on page load and pushes page changes to the client via SSE,
-// based on events generated by the Huskki input source (live / replay)
+// based on events generated by the Huskki input source (live / replay).
+// Pages list the channels they render in ?channels=a,b,c and only receive those.
+// A newly opened page has its charts filled in with the hub's retained history in a single
+// batch. Events carry their timestamp as the SSE event ID, so a reconnecting client sends
+// Last-Event-ID and only has the chart data it missed backfilled.
 func EventsHandler(w http.ResponseWriter, r *http.Request) {
-	sse := ds.NewSSE(w, r)
+	system := resolveUnits(w, r)
+	sse := ds.NewSSE(w, r, ds.WithCompression())
+	defer Idle.Connect()()
 
-	_, ch, cancel := EventHub.Subscribe()
+	var channels []string
+	for _, c := range strings.Split(r.URL.Query().Get("channels"), ",") {
+		if c = strings.TrimSpace(strings.ToLower(c)); c != "" {
+			channels = append(channels, c)
+		}
+	}
+
+	id, ch, cancel := EventHub.SubscribeWith(hub.SubscribeOptions{MaxRate: maxRate(r)}, channels...)
 	defer cancel()
+	EventHub.SetName(id, "dashboard "+r.RemoteAddr)
+
+	ticker := time.NewTicker(CARD_STATUS_INTERVAL)
+	defer ticker.Stop()
+
+	decimate := newDecimator(UIRates)
+	var aligner *resample.Aligner
+	if AlignStep > 0 {
+		aligner = resample.NewAligner(int(AlignStep.Milliseconds()), AlignMode)
+	}
+
+	// A newly opened page gets everything the hub has retained, so its charts start populated
+	since := int64(-1)
+	if lastEventID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		since = lastEventID
+	}
+	if err := syncReplayClock(sse); err != nil {
+		fmt.Println(err)
+		return
+	}
+	backfilledUntil, err := backfillCharts(sse, since, system)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	pending := &coalescer{system: system}
+	var flush <-chan time.Time    // nil until there are events waiting to be coalesced
+	var trailing <-chan time.Time // nil until the decimator is holding back an update
+	var trailingAt time.Time
+
+	send := func(event hub.SensorEvent) error {
+		events := []hub.SensorEvent{event}
+		if _, ok := event.Float(); ok && event.HasTimestamp && aligner != nil {
+			events = aligner.Push(event)
+		}
+		for i, event := range events {
+			// Already sent as part of the backfill, only the cards need patching
+			if event.HasTimestamp && event.Timestamp <= backfilledUntil {
+				events[i].HasTimestamp = false
+			} else if event.HasTimestamp {
+				// Caught up with the backfill, anything older from here on is a replay
+				// seeking back or the logger restarting
+				backfilledUntil = -1
+			}
+		}
+		if CoalesceWindow > 0 {
+			// Latency is observed as the coalesced events are sent
+			for _, event := range events {
+				pending.Add(event)
+			}
+			if pending.Pending() && flush == nil {
+				flush = time.After(CoalesceWindow)
+			}
+			return nil
+		}
+		for _, event := range events {
+			if err := generatePatch(event, system)(sse); err != nil {
+				return err
+			}
+		}
+		if !event.Received.IsZero() {
+			SSELatency.Observe(time.Since(event.Received))
+		}
+		return nil
+	}
+	// armTrailing has the held updates sent once the earliest of them is due
+	armTrailing := func() {
+		if due, ok := decimate.Next(); ok && (trailing == nil || due.Before(trailingAt)) {
+			trailing, trailingAt = time.After(time.Until(due)), due
+		}
+	}
 
 	for {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-ticker.C:
+			if err := sse.PatchElements(renderCardRates() + renderReplayControls()); err != nil {
+				fmt.Println(err)
+				return
+			}
+			if err := syncReplayClock(sse); err != nil {
+				fmt.Println(err)
+				return
+			}
+		case <-flush:
+			flush = nil
+			if err := pending.Flush(sse); err != nil {
+				fmt.Println(err)
+				return
+			}
+		case now := <-trailing:
+			trailing = nil
+			for _, event := range decimate.Flush(now) {
+				if err := send(event); err != nil {
+					fmt.Println(err)
+					return
+				}
+			}
+			armTrailing()
 		case event := <-ch:
-			updateFunc := generatePatch(event)
-			err := updateFunc(sse)
-			if err != nil {
+			if isReplayRewind(event) {
+				// Points from before the rewind are later on the timeline, clear them away
+				// rather than draw a line from there back to where the replay is now
+				flush, trailing, backfilledUntil = nil, nil, -1
+				decimate = newDecimator(UIRates)
+				if aligner != nil {
+					aligner.Reset()
+				}
+				if err := pending.Flush(sse); err != nil {
+					fmt.Println(err)
+					return
+				}
+				if err := sse.ExecuteScript(`resetCharts();`); err != nil {
+					fmt.Println(err)
+					return
+				}
+				continue
+			}
+			if !decimate.Keep(event) {
+				armTrailing()
+				continue
+			}
+			if err := send(event); err != nil {
 				fmt.Println(err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
@@ -79,49 +335,113 @@ func EventsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func buildUpdateChartScript(name string, x, y int) string {
-	return fmt.Sprintf(`pushData("%s", %d, %d);`, strings.ToLower(name), x, y)
+// renderCardRates templates the update rate of every card, marking those whose channel
+// is stale
+func renderCardRates() string {
+	var writer strings.Builder
+	status := EventHub.ChannelStatus()
+	for _, card := range cards {
+		renderCardRate(&writer, card, status)
+	}
+	return writer.String()
 }
 
-// generatePatch takes an event received from the event queue, iterates the cards that are displayed on the UI,
-// and returns a closure that can be used to patch the client.
-func generatePatch(event map[string]any) func(*ds.ServerSentEventGenerator) error {
+func renderCardRate(writer *strings.Builder, card cardProps, status map[string]hub.ChannelStatus) {
+	name := strings.ToLower(card.Name)
+	props := cardRateProps{Name: card.Name, Stale: Stale.Is(name)}
+	if s, ok := status[name]; ok {
+		props.Seen, props.Rate = true, s.Rate
+	}
+	Templates.ExecuteTemplate(writer, "card.rate", props)
+}
 
-	var writer = strings.Builder{}
-	var funcs []func(generator *ds.ServerSentEventGenerator) error
+func buildUpdateChartScript(name string, x int64, y float64) string {
+	return fmt.Sprintf(`pushData("%s", %d, %v);`, strings.ToLower(name), x, y)
+}
 
-	// For each card, see if we have an update and template a response
-	for _, card := range cards {
-		if value, ok := event[strings.ToLower(card.Name)]; ok {
-			Templates.ExecuteTemplate(&writer, "card.value", cardProps{Name: card.Name, Value: fmt.Sprintf("%v", value)})
-		}
+// backfillCharts sends every chart point recorded after since as one script, rather than
+// replaying each event as its own patch. It returns the newest timestamp sent.
+func backfillCharts(sse *ds.ServerSentEventGenerator, since int64, system units.System) (int64, error) {
+	if DISABLE_CHARTS {
+		return since, nil
 	}
 
-	// For each chart see if we have an update and form an SSE update function
-	for _, chart := range charts {
-		if DISABLE_CHARTS {
-			continue
+	latest := since
+	batch := map[string][][2]float64{}
+	var markers strings.Builder
+	history := EventHub.History(since)
+	if AlignStep > 0 {
+		history = resample.Align(history, int(AlignStep.Milliseconds()), AlignMode)
+	}
+	for _, event := range history {
+		latest = max(latest, event.Timestamp)
+		if event.Channel == MARKER_CHANNEL {
+			markers.WriteString(buildMarkerScript(event.Timestamp, event.Value))
 		}
-		value, ok := event[strings.ToLower(chart.Name)]
-		if !ok {
+		if !slices.ContainsFunc(charts, func(c chartProps) bool { return strings.ToLower(c.Name) == event.Channel }) {
 			continue
 		}
-		timestamp, ok := event["timestamp"]
-		if !ok {
-			continue
+		if v, ok := chartValue(units.Convert(system, event.Channel, event.Value)); ok {
+			batch[event.Channel] = append(batch[event.Channel], [2]float64{float64(event.Timestamp), v})
 		}
+	}
+	if len(batch) == 0 && markers.Len() == 0 {
+		return latest, nil
+	}
 
-		v, ok := value.(int)
-		if !ok {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return since, err
+	}
+	// Markers go after the points, which place the charts' timeline
+	script := fmt.Sprintf(`pushDataBatch(%s);`, payload) + markers.String()
+	return latest, sse.ExecuteScript(script, ds.WithExecuteScriptEventID(strconv.FormatInt(latest, 10)))
+}
+
+// generatePatch takes an event received from the event queue, iterates the cards that are displayed on the UI,
+// and returns a closure that can be used to patch the client. Values are converted to the client's units.
+func generatePatch(event hub.SensorEvent, system units.System) func(*ds.ServerSentEventGenerator) error {
+
+	var writer = strings.Builder{}
+	var funcs []func(generator *ds.ServerSentEventGenerator) error
+
+	// Tag timestamped events so the client can resume from them after a dropout
+	var patchOpts []ds.PatchElementOption
+	var scriptOpts []ds.ExecuteScriptOption
+	if event.HasTimestamp {
+		patchOpts = append(patchOpts, ds.WithPatchElementsEventID(strconv.FormatInt(event.Timestamp, 10)))
+		scriptOpts = append(scriptOpts, ds.WithExecuteScriptEventID(strconv.FormatInt(event.Timestamp, 10)))
+	}
+	value := units.Convert(system, event.Channel, event.Value)
+
+	renderElements(&writer, event, value, system)
+
+	if soundsAlarm(event) {
+		funcs = append(funcs, func(sse *ds.ServerSentEventGenerator) error {
+			return sse.ExecuteScript(`alarm();`, scriptOpts...)
+		})
+	}
+
+	if event.Channel == MARKER_CHANNEL && event.HasTimestamp && !DISABLE_CHARTS {
+		script := buildMarkerScript(event.Timestamp, event.Value)
+		funcs = append(funcs, func(sse *ds.ServerSentEventGenerator) error {
+			return sse.ExecuteScript(script, scriptOpts...)
+		})
+	}
+
+	// For each chart see if we have an update and form an SSE update function
+	for _, chart := range charts {
+		if DISABLE_CHARTS || strings.ToLower(chart.Name) != event.Channel || !event.HasTimestamp {
 			continue
 		}
-		ts, ok := timestamp.(int)
+		v, ok := chartValue(value)
 		if !ok {
 			continue
 		}
+		ts := event.Timestamp
 
 		funcs = append(funcs, func(sse *ds.ServerSentEventGenerator) error {
-			err := sse.ExecuteScript(buildUpdateChartScript(chart.Name, ts, v))
+			err := sse.ExecuteScript(buildUpdateChartScript(chart.Name, ts, v), scriptOpts...)
 			return err
 		})
 	}
@@ -130,7 +450,7 @@ func generatePatch(event map[string]any) func(*ds.ServerSentEventGenerator) erro
 	return func(sse *ds.ServerSentEventGenerator) error {
 		// Patch UI elements
 		if writer.String() != "" {
-			err := sse.PatchElements(writer.String())
+			err := sse.PatchElements(writer.String(), patchOpts...)
 			if err != nil {
 				return err
 			}
@@ -147,3 +467,39 @@ func generatePatch(event map[string]any) func(*ds.ServerSentEventGenerator) erro
 		return nil
 	}
 }
+
+// renderElements templates the cards and alerts an event updates, with its value already
+// converted to the client's units
+func renderElements(writer *strings.Builder, event hub.SensorEvent, value any, system units.System) {
+	// For each card, see if we have an update and template a response. Cards are greyed out
+	// as soon as their channel goes stale, rather than on the next rate update.
+	for _, card := range cards {
+		switch name := strings.ToLower(card.Name); event.Channel {
+		case name:
+			Templates.ExecuteTemplate(writer, "card.value", cardProps{Name: card.Name, Value: fmt.Sprintf("%v", value)})
+		case StaleChannel(name):
+			renderCardRate(writer, card, EventHub.ChannelStatus())
+		}
+	}
+
+	// Connection status of the live input, and alerts
+	if on, ok := event.Value.(bool); ok {
+		switch event.Channel {
+		case LINK_CHANNEL:
+			Templates.ExecuteTemplate(writer, "link.status", on)
+		case LOW_VOLTAGE_CHANNEL:
+			Templates.ExecuteTemplate(writer, "voltage.low", on)
+		case DISK_LOW_CHANNEL:
+			Templates.ExecuteTemplate(writer, "disk.low", on)
+		case INJECTOR_DUTY_HIGH_CHANNEL:
+			Templates.ExecuteTemplate(writer, "injector.duty.high", on)
+		default:
+			if rule, ok := alertFor(event.Channel); ok {
+				Templates.ExecuteTemplate(writer, "alert", rule.props(system, on))
+			}
+		}
+	}
+	if event.Channel == RECORDING_CHANNEL {
+		Templates.ExecuteTemplate(writer, "recording.status", Recording.Status())
+	}
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"huskki/ambient"
+	"huskki/hub"
+	"huskki/ignition"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_START = "session.start"
+	SESSION_END   = "session.end"
+
+	REQUEST_TIMEOUT = 10 * time.Second
+	MAX_ATTEMPTS    = 3
+)
+
+type Payload struct {
+	Event           string     `json:"event"`
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds,omitempty"`
+	Stats           *Stats     `json:"stats,omitempty"`
+	// Ambient conditions captured at the start of the session, if a source is configured
+	Ambient *ambient.Conditions `json:"ambient,omitempty"`
+}
+
+type Stats struct {
+	Frames     int `json:"frames"`
+	MaxRPM     int `json:"maxRpm"`
+	MaxCoolant int `json:"maxCoolant"`
+}
+
+// Notifier posts a JSON payload to each configured URL when a session (ignition cycle)
+// starts and ends, so external systems can react to rides.
+type Notifier struct {
+	urls   []string
+	client *http.Client
+
+	ambient ambient.Source
+
+	mu         sync.Mutex
+	active     bool
+	start      time.Time
+	stats      Stats
+	conditions *ambient.Conditions
+}
+
+func NewNotifier(urls []string) *Notifier {
+	return &Notifier{urls: urls, client: &http.Client{Timeout: REQUEST_TIMEOUT}}
+}
+
+// SetAmbient captures conditions from src at the start of every session, included in both
+// the start and end payloads
+func (n *Notifier) SetAmbient(src ambient.Source) {
+	n.ambient = src
+}
+
+// Start collects session stats from the hub and fires webhooks on ignition changes.
+// The returned function stops collecting.
+func (n *Notifier) Start(h *hub.EventHub, d *ignition.Detector) func() {
+	id, ch, cancel := h.Subscribe()
+	h.SetName(id, "webhook")
+	go func() {
+		for event := range ch {
+			n.record(event)
+		}
+	}()
+	d.OnChange(n.ignitionChanged)
+	return cancel
+}
+
+func (n *Notifier) record(event hub.SensorEvent) {
+	if !event.HasTimestamp || event.Source != "" {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.active {
+		return
+	}
+	n.stats.Frames++
+	switch v, _ := event.Int(); event.Channel {
+	case "rpm":
+		n.stats.MaxRPM = max(n.stats.MaxRPM, v)
+	case "coolant":
+		n.stats.MaxCoolant = max(n.stats.MaxCoolant, v)
+	}
+}
+
+func (n *Notifier) ignitionChanged(on bool) {
+	n.mu.Lock()
+	now := time.Now()
+	if on {
+		n.active, n.start, n.stats, n.conditions = true, now, Stats{}, nil
+		n.mu.Unlock()
+		go n.sessionStarted(now)
+		return
+	}
+	if !n.active {
+		n.mu.Unlock()
+		return
+	}
+	stats := n.stats
+	n.active = false
+	payload := Payload{
+		Event:           SESSION_END,
+		Start:           n.start,
+		End:             &now,
+		DurationSeconds: now.Sub(n.start).Seconds(),
+		Stats:           &stats,
+		Ambient:         n.conditions,
+	}
+	n.mu.Unlock()
+
+	for _, url := range n.urls {
+		go n.send(url, payload)
+	}
+}
+
+// sessionStarted captures the ambient conditions, if configured, before announcing the session
+func (n *Notifier) sessionStarted(start time.Time) {
+	payload := Payload{Event: SESSION_START, Start: start}
+	if n.ambient != nil {
+		conditions, err := ambient.Capture(n.ambient)
+		if err != nil {
+			log.Printf("webhook: ambient conditions: %v", err)
+		} else {
+			payload.Ambient = &conditions
+			n.mu.Lock()
+			if n.active && n.start.Equal(start) {
+				n.conditions = &conditions
+			}
+			n.mu.Unlock()
+		}
+	}
+	for _, url := range n.urls {
+		go n.send(url, payload)
+	}
+}
+
+func (n *Notifier) send(url string, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("webhook: %v", err)
+		return
+	}
+	for attempt := 1; attempt <= MAX_ATTEMPTS; attempt++ {
+		if err = n.post(url, body); err == nil {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	log.Printf("webhook %s %s: %v", payload.Event, url, err)
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
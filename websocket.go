@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"huskki/hub"
+	"huskki/units"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_WRITE_TIMEOUT = 10 * time.Second
+	WS_PING_INTERVAL = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+
+// WSOrigins are the other origins whose pages may open /ws, e.g. a dashboard served from
+// elsewhere, or * for any, set by -ws-origin
+var WSOrigins []string
+
+// checkOrigin allows clients that aren't browsers, which send no Origin, pages served by
+// huskki itself and the -ws-origin ones. Browsers don't hold websockets to the same-origin
+// policy, so without this any site a rider visits could read the feed.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range WSOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(allowed), "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketHandler streams decoded events as a JSON message each, the same as the lines of the
+// decoded log, for clients that would rather not speak datastar's SSE, e.g. a Python script.
+// ?channels=rpm,coolant picks the channels, ?units=imperial converts them and ?max-rate= limits
+// them as for /events.
+//
+//	{"channel":"rpm","value":4200,"unit":"RPM","timestamp":1234}
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	system := resolveUnits(w, r)
+	var channels []string
+	for _, c := range strings.Split(r.URL.Query().Get("channels"), ",") {
+		if c = strings.TrimSpace(strings.ToLower(c)); c != "" {
+			channels = append(channels, c)
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	defer Idle.Connect()()
+
+	id, ch, cancel := EventHub.SubscribeWith(hub.SubscribeOptions{MaxRate: maxRate(r)}, channels...)
+	defer cancel()
+	EventHub.SetName(id, "websocket "+r.RemoteAddr)
+
+	// Nothing is expected from the client, but reading handles its pongs and notices it going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(WS_PING_INTERVAL)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WS_WRITE_TIMEOUT)); err != nil {
+				return
+			}
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			line := decodedEvent{
+				Channel: event.Channel,
+				Value:   units.Convert(system, event.Channel, event.Value),
+				Unit:    units.Unit(system, event.Channel, event.Unit),
+				Source:  event.Source,
+			}
+			if event.HasTimestamp {
+				line.Timestamp = &event.Timestamp
+			}
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if err := conn.WriteJSON(line); err != nil {
+				return
+			}
+		}
+	}
+}